@@ -0,0 +1,155 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/sso"
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+// AgentCmd defines the Kong args for the agent command and sub-commands
+type AgentCmd struct {
+	Start  AgentStartCmd  `kong:"cmd,default='1',help='Start the agent in the foreground (default)'"`
+	Lock   AgentLockCmd   `kong:"cmd,help='Forget the unlocked SecureStore and all cached secrets'"`
+	Unlock AgentUnlockCmd `kong:"cmd,help='Unlock the SecureStore of a locked agent'"`
+	Stop   AgentStopCmd   `kong:"cmd,help='Stop the agent'"`
+}
+
+type AgentStartCmd struct {
+	Socket string `kong:"help='Path to the agent socket',default='${AGENT_SOCKET}'"`
+	TTL    int    `kong:"name='ttl',help='Minutes to cache secrets in memory',default=15"`
+}
+
+// Run executes the `agent start` command
+func (cc *AgentStartCmd) Run(ctx *RunContext) error {
+	socket := utils.GetHomePath(ctx.Cli.Agent.Start.Socket)
+	ttl := time.Duration(ctx.Cli.Agent.Start.TTL) * time.Minute
+
+	server := storage.NewAgentServer(socket, ttl, ctx.Store, agentOpenStore(ctx.Settings))
+	ctx.Store = nil // `agent lock` must be able to forget the unlocked store
+	if err := server.Listen(); err != nil {
+		return fmt.Errorf("Unable to start agent: %s", err.Error())
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		if err := server.Stop(); err != nil {
+			log.WithError(err).Errorf("Unable to stop agent")
+		}
+	}()
+
+	fmt.Printf("%s=%s; export %s;\n", storage.ENV_SSO_AGENT_SOCK, socket, storage.ENV_SSO_AGENT_SOCK)
+	log.Infof("Agent listening on %s", socket)
+	return server.Serve()
+}
+
+// agentOpenStore returns the function used by the agent to re-open our
+// SecureStore when it is unlocked
+func agentOpenStore(s *sso.Settings) storage.OpenStoreFunc {
+	return func(password string) (storage.SecureStorage, error) {
+		return openSecureStorePassword(s, s.SecureStore, password)
+	}
+}
+
+// agentClient returns a client for the agent specified via AWS_SSO_AGENT_SOCK
+func agentClient() (*storage.AgentClient, error) {
+	socket := os.Getenv(storage.ENV_SSO_AGENT_SOCK)
+	if socket == "" {
+		return nil, fmt.Errorf("%s is not set", storage.ENV_SSO_AGENT_SOCK)
+	}
+	return storage.OpenAgentClient(socket)
+}
+
+type AgentLockCmd struct{} // takes no arguments
+
+// Run executes the `agent lock` command
+func (cc *AgentLockCmd) Run(ctx *RunContext) error {
+	client, err := agentClient()
+	if err != nil {
+		return err
+	}
+	return client.Lock()
+}
+
+type AgentUnlockCmd struct {
+	PasswordCommand []string `kong:"help='Command which prints the SecureStore password on stdout'"`
+}
+
+// Run executes the `agent unlock` command
+func (cc *AgentUnlockCmd) Run(ctx *RunContext) error {
+	client, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	locked, err := client.IsLocked()
+	if err != nil {
+		return err
+	} else if !locked {
+		log.Infof("Agent is already unlocked")
+		return nil
+	}
+
+	password := ""
+	var source *storage.PassphraseSource
+	switch ctx.Settings.SecureStore {
+	case "encrypted":
+		source = &storage.PassphraseSource{
+			EnvVar:   storage.ENV_SSO_STORE_PASSWORD,
+			FdEnvVar: storage.ENV_SSO_STORE_PASSWORD_FD,
+			Command:  ctx.Settings.EncryptedStoreCmd,
+			Prompt:   "Store password",
+		}
+	case "file":
+		source = &storage.PassphraseSource{
+			EnvVar: storage.ENV_SSO_FILE_PASSWORD,
+			Prompt: "Store password",
+		}
+	}
+	if source != nil {
+		if len(ctx.Cli.Agent.Unlock.PasswordCommand) > 0 {
+			source.Command = ctx.Cli.Agent.Unlock.PasswordCommand
+		}
+		if password, err = source.Func()(); err != nil {
+			return err
+		}
+	}
+
+	return client.Unlock(password)
+}
+
+type AgentStopCmd struct{} // takes no arguments
+
+// Run executes the `agent stop` command
+func (cc *AgentStopCmd) Run(ctx *RunContext) error {
+	client, err := agentClient()
+	if err != nil {
+		return err
+	}
+	return client.Stop()
+}
@@ -19,6 +19,7 @@ package main
  */
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -36,6 +37,8 @@ type Predictor struct {
 	roles      []string
 	arns       []string
 	profiles   []string
+	tags       []string
+	services   []string
 }
 
 // AvailableAwsRegions lists all the AWS regions that AWS provides
@@ -84,6 +87,10 @@ func NewPredictor(cacheFile, configFile string) *Predictor {
 		return &p
 	}
 
+	for service := range utils.ConsoleServices(settings.ConsoleServices) {
+		p.services = append(p.services, service)
+	}
+
 	c, err := sso.OpenCache(cacheFile, settings)
 	if err != nil {
 		return &p
@@ -112,6 +119,12 @@ func NewPredictor(cacheFile, configFile string) *Predictor {
 		p.roles = append(p.roles, k)
 	}
 
+	for key, values := range *cache.Roles.GetAllTags() {
+		for _, value := range values {
+			p.tags = append(p.tags, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+
 	return &p
 }
 
@@ -180,3 +193,22 @@ func (p *Predictor) ProfileComplete() complete.Predictor {
 
 	return complete.PredictSet(profiles...)
 }
+
+// TagComplete returns a list of all the Key=Value tags of our roles
+func (p *Predictor) TagComplete() complete.Predictor {
+	tags := []string{}
+
+	// The `:` and ` ` characters are considered a word delimiter by bash
+	// complete so we need to escape them
+	r := strings.NewReplacer(":", "\\:", " ", "\\ ")
+	for _, t := range p.tags {
+		tags = append(tags, r.Replace(t))
+	}
+
+	return complete.PredictSet(tags...)
+}
+
+// ServiceComplete returns a list of the AWS Console service shortcuts
+func (p *Predictor) ServiceComplete() complete.Predictor {
+	return complete.PredictSet(p.services...)
+}
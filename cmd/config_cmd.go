@@ -19,16 +19,20 @@ package main
  */
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 
 	"github.com/hexops/gotextdiff"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
+	"github.com/synfinatic/aws-sso-cli/sso"
 	"github.com/synfinatic/aws-sso-cli/utils"
 )
 
@@ -36,14 +40,32 @@ const (
 	AWS_CONFIG_FILE = "~/.aws/config"
 	CONFIG_PREFIX   = "# BEGIN_AWS_SSO_CLI"
 	CONFIG_SUFFIX   = "# END_AWS_SSO_CLI"
-	CONFIG_TEMPLATE = `# BEGIN_AWS_SSO_CLI
-{{ range $sso, $struct := . }}{{ range $arn, $profile := $struct }}
+	CONFIG_TEMPLATE = `{{ range $sso, $struct := .Profiles }}{{ range $arn, $profile := $struct }}
 [profile {{ $profile.Profile }}]
 credential_process = {{ $profile.BinaryPath }} -u {{ $profile.Open }} -S "{{ $profile.Sso }}" process --arn {{ $profile.Arn }}
 {{ range $key, $value := $profile.ConfigVariables }}{{ $key }} = {{ $value }}
-{{end}}{{end}}{{end}}
-# END_AWS_SSO_CLI
-`
+{{end}}{{end}}{{end}}`
+	// native AWS CLI v2 / SDK SSO support via sso-session.  Roles which
+	// require role chaining use source_profile or credential_process instead.
+	SSO_SESSION_TEMPLATE = `{{ range $name, $session := .Sessions }}
+[sso-session {{ $name }}]
+sso_start_url = {{ $session.StartUrl }}
+sso_region = {{ $session.SSORegion }}
+sso_registration_scopes = sso:account:access
+{{ end }}{{ range $sso, $struct := .Profiles }}{{ range $arn, $profile := $struct }}
+[profile {{ $profile.Profile }}]
+{{ if $profile.SourceProfile }}source_profile = {{ $profile.SourceProfile }}
+role_arn = {{ $profile.Arn }}
+{{ else if $profile.SsoSession }}sso_session = {{ $profile.SsoSession }}
+sso_account_id = {{ $profile.AccountId }}
+sso_role_name = {{ $profile.RoleName }}
+{{ else }}credential_process = {{ $profile.BinaryPath }} -u {{ $profile.Open }} -S "{{ $profile.Sso }}" process --arn {{ $profile.Arn }}
+{{ end }}{{ if $profile.Region }}region = {{ $profile.Region }}
+{{ end }}{{ range $key, $value := $profile.ConfigVariables }}{{ $key }} = {{ $value }}
+{{end}}{{end}}{{end}}`
+
+	CONFIG_MODE_PROCESS     = "credential-process"
+	CONFIG_MODE_SSO_SESSION = "sso-session"
 )
 
 type ProfileMap map[string]map[string]ProfileConfig
@@ -55,34 +77,183 @@ type ProfileConfig struct {
 	Open            string
 	Profile         string
 	Sso             string
+	Role            *sso.AWSRoleFlat // for user templates
+
+	// only used with --mode sso-session
+	AccountId     string
+	RoleName      string
+	Region        string
+	SsoSession    string
+	SourceProfile string
+}
+
+// SSOSession is an [sso-session] block in ~/.aws/config
+type SSOSession struct {
+	StartUrl  string
+	SSORegion string
+}
+
+// ConfigTemplateData is what is passed to the config template
+type ConfigTemplateData struct {
+	Mode     string
+	Sessions map[string]SSOSession // only used with --mode sso-session
+	Profiles ProfileMap
 }
 
 type ConfigCmd struct {
-	Diff  bool   `kong:"help='Print a diff of changes to the config file instead of modifying it'"`
-	Open  string `kong:"help='Override how to open URLs: [clip|exec|open]',required,enum='clip,exec,open'"`
-	Print bool   `kong:"help='Print profile entries instead of modifying config file'"`
+	Diff        bool     `kong:"help='Print a diff of changes to the config file instead of modifying it'"`
+	HistoryOnly bool     `kong:"help='Only generate profiles for roles in the History'"`
+	Mode        string   `kong:"help='Type of profiles to generate: [credential-process|sso-session]',enum='credential-process,sso-session',default='credential-process'"`
+	Open        string   `kong:"help='Override how to open URLs: [clip|exec|open] (required)'"`
+	Print       bool     `kong:"help='Print profile entries instead of modifying config file'"`
+	Tag         []string `kong:"help='Only generate profiles for roles matching the tag query (repeatable)',sep='none'"`
+	Restore     bool     `kong:"help='Restore the config file from the latest or specified backup'"`
+	Backup      string   `kong:"arg,optional,help='Backup to restore'"`
 }
 
 func (cc *ConfigCmd) Run(ctx *RunContext) error {
+	if ctx.Cli.Config.Restore {
+		return restoreConfig(ctx)
+	} else if ctx.Cli.Config.Backup != "" {
+		return fmt.Errorf("Backup may only be specified with --restore")
+	}
+
+	// not enforced by kong since --restore does not need it
+	switch ctx.Cli.Config.Open {
+	case "clip", "exec", "open":
+	case "":
+		return fmt.Errorf("missing flags: --open=STRING")
+	default:
+		return fmt.Errorf("--open must be one of \"clip\",\"exec\",\"open\" but got \"%s\"", ctx.Cli.Config.Open)
+	}
+
 	set := ctx.Settings
 	binaryPath, err := os.Executable()
 	if err != nil {
 		return err
 	}
 
+	filter, err := configFilter(ctx)
+	if err != nil {
+		return err
+	}
+
+	// migrate the single block used by older versions to per-SSO blocks
+	legacy, err := markedBlockExists(awsConfigFile(), CONFIG_PREFIX)
+	if err != nil {
+		return err
+	}
+	if legacy && len(filter.SSO) > 0 {
+		log.Warnf("Updating profiles for all AWS SSO instances to migrate %s", awsConfigFile())
+		filter.SSO = []string{}
+	}
+
+	profiles, err := configProfiles(ctx, binaryPath, filter)
+	if err != nil {
+		return err
+	}
+
+	data := ConfigTemplateData{
+		Mode:     ctx.Cli.Config.Mode,
+		Sessions: map[string]SSOSession{},
+		Profiles: profiles,
+	}
+	format := CONFIG_TEMPLATE
+	if ctx.Cli.Config.Mode == CONFIG_MODE_SSO_SESSION {
+		data = ssoSessionConfig(ctx, profiles)
+		format = SSO_SESSION_TEMPLATE
+	}
+
+	if set.ConfigTemplate != "" {
+		userFormat, err := os.ReadFile(utils.GetHomePath(set.ConfigTemplate))
+		if err != nil {
+			return fmt.Errorf("Unable to read ConfigTemplate: %s", err.Error())
+		}
+		format = string(userFormat)
+	}
+
+	templ, err := template.New("profile").Funcs(sso.TemplateFuncMap()).Parse(format)
+	if err != nil {
+		return err
+	}
+
+	// each AWS SSO instance gets its own block
+	ssoNames := []string{}
+	for ssoName := range set.Cache.SSO {
+		if filter.MatchSSO(ssoName) {
+			ssoNames = append(ssoNames, ssoName)
+		}
+	}
+	sort.Strings(ssoNames)
+
+	blocks := []markedBlock{}
+	for _, ssoName := range ssoNames {
+		ssoData := ConfigTemplateData{
+			Mode:     data.Mode,
+			Sessions: map[string]SSOSession{},
+			Profiles: ProfileMap{},
+		}
+		body := ""
+		if p, ok := data.Profiles[ssoName]; ok && len(p) > 0 {
+			ssoData.Profiles[ssoName] = p
+			sessionName := ssoSessionName(ssoName)
+			if session, ok := data.Sessions[sessionName]; ok {
+				ssoData.Sessions[sessionName] = session
+			}
+			if body, err = configBody(templ, ssoData); err != nil {
+				return err
+			}
+		}
+		prefix, suffix := configMarkers(ssoName)
+		blocks = append(blocks, markedBlock{Prefix: prefix, Suffix: suffix, Body: body})
+	}
+
+	if ctx.Cli.Config.Print {
+		for _, block := range blocks {
+			fmt.Printf("%s", block.String())
+		}
+		return nil
+	}
+
+	return updateMarkedFile(awsConfigFile(), ctx.Cli.Config.Diff, set.ConfigBackups, func(contents string) string {
+		if legacy {
+			// put the new blocks where the old block was
+			var all strings.Builder
+			for _, block := range blocks {
+				all.WriteString(block.String())
+			}
+			contents = replaceLines(contents, CONFIG_PREFIX, CONFIG_SUFFIX, all.String())
+		}
+		for _, block := range blocks {
+			contents = replaceMarkedBlock(contents, block)
+		}
+		return contents
+	})
+}
+
+// configProfiles returns the profiles for the roles matching the filter in
+// every AWS SSO instance
+func configProfiles(ctx *RunContext, binaryPath string, filter sso.ProfilesFilter) (ProfileMap, error) {
+	set := ctx.Settings
 	profiles := ProfileMap{}
 	profileUniqueCheck := map[string][]string{} // ProfileName() => Arn
 
-	// Find all the roles across all of the SSO instances
+	// Find all the selected roles across all of the SSO instances
 	for ssoName, s := range set.Cache.SSO {
+		if !filter.MatchSSO(ssoName) {
+			continue
+		}
 		for _, role := range s.Roles.GetAllRoles() {
+			if !filter.Match(role, s) {
+				continue
+			}
 			profile, err := role.ProfileName(ctx.Settings)
 			if err != nil {
 				log.Errorf("Unable to generate profile name for %s: %s", role.Arn, err.Error())
 			}
 
 			if match, duplicate := profileUniqueCheck[profile]; duplicate {
-				return fmt.Errorf("Duplicate profile name '%s' for:\n%s: %s\n%s: %s",
+				return profiles, fmt.Errorf("Duplicate profile name '%s' for:\n%s: %s\n%s: %s",
 					profile, match[0], match[1], ssoName, role.Arn)
 			}
 			profileUniqueCheck[profile] = []string{ssoName, role.Arn}
@@ -91,126 +262,314 @@ func (cc *ConfigCmd) Run(ctx *RunContext) error {
 				profiles[ssoName] = map[string]ProfileConfig{}
 			}
 
+			role.SSO = ssoName
 			profiles[ssoName][role.Arn] = ProfileConfig{
 				Arn:             role.Arn,
 				BinaryPath:      binaryPath,
-				ConfigVariables: ctx.Settings.ConfigVariables,
+				ConfigVariables: set.GetConfigVariables(ssoName, role.AccountId, role.RoleName),
 				Open:            ctx.Cli.Config.Open,
 				Profile:         profile,
 				Sso:             ssoName,
+				Role:            role,
 			}
 		}
 	}
+	return profiles, nil
+}
 
-	templ, err := template.New("profile").Parse(CONFIG_TEMPLATE)
-	if err != nil {
-		return err
-	}
-
-	if ctx.Cli.Config.Print {
-		if err := templ.Execute(os.Stdout, profiles); err != nil {
-			return err
+// configFilter returns the ConfigProfilesFilter with the CLI overrides.  Only
+// an explicit --sso limits the profiles since $AWS_SSO is set by eval & exec.
+func configFilter(ctx *RunContext) (sso.ProfilesFilter, error) {
+	filter := ctx.Settings.ConfigFilter
+	if ctx.Cli.SSO != "" && flagOnCli(ctx, "sso") {
+		if _, ok := ctx.Settings.SSO[ctx.Cli.SSO]; !ok {
+			return filter, fmt.Errorf("'%s' is not a valid AWS SSO Instance", ctx.Cli.SSO)
 		}
+		filter.SSO = []string{ctx.Cli.SSO}
+	}
+	if len(ctx.Cli.Config.Tag) > 0 {
+		filter.Tags = ctx.Cli.Config.Tag
+	}
+	if ctx.Cli.Config.HistoryOnly {
+		filter.HistoryOnly = true
 	}
-	return updateConfig(ctx, templ, profiles)
+	return filter, filter.Validate()
 }
 
-// updateConfig calculates the diff
-func updateConfig(ctx *RunContext, templ *template.Template, profiles ProfileMap) error {
-	// open our config file
-	configFile := awsConfigFile()
-	input, err := os.Open(configFile)
-	if err != nil {
-		return err
+var invalidSessionChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ssoSessionName returns the name of the [sso-session] for the SSO instance
+func ssoSessionName(ssoName string) string {
+	return invalidSessionChars.ReplaceAllString(ssoName, "-")
+}
+
+// ssoSessionConfig converts our profiles to use sso-session where possible
+func ssoSessionConfig(ctx *RunContext, profiles ProfileMap) ConfigTemplateData {
+	config := ConfigTemplateData{
+		Mode:     CONFIG_MODE_SSO_SESSION,
+		Sessions: map[string]SSOSession{},
+		Profiles: ProfileMap{},
 	}
 
-	// open a temp file
-	output, err := os.CreateTemp("", "config.*")
-	if err != nil {
-		return err
+	// Via is the ARN of the role used to assume the role
+	arnProfiles := map[string]string{}
+	for _, p := range profiles {
+		for arn, profile := range p {
+			arnProfiles[arn] = profile.Profile
+		}
 	}
-	tempFileName := output.Name()
-	defer os.Remove(tempFileName)
 
-	w := bufio.NewWriter(output)
+	for ssoName, p := range profiles {
+		sessionName := ssoSessionName(ssoName)
+		config.Profiles[ssoName] = map[string]ProfileConfig{}
 
-	// read & write up to the prefix
-	r := bufio.NewReader(input)
+		for arn, profile := range p {
+			rFlat := profile.Role
 
-	line, err := r.ReadString('\n')
-	for err == nil && line != fmt.Sprintf("%s\n", CONFIG_PREFIX) {
-		if _, err = w.WriteString(line); err != nil {
-			return err
+			profile.Region = rFlat.DefaultRegion
+			if _, ok := profile.ConfigVariables["region"]; ok {
+				profile.Region = "" // user knows best
+			}
+
+			if rFlat.Via != "" {
+				if source, ok := arnProfiles[rFlat.Via]; ok {
+					profile.SourceProfile = source
+				} else {
+					log.Warnf("Unable to find profile for %s, using credential_process for %s", rFlat.Via, arn)
+				}
+			} else {
+				profile.SsoSession = sessionName
+				profile.AccountId, _ = utils.AccountIdToString(rFlat.AccountId)
+				profile.RoleName = rFlat.RoleName
+				config.Sessions[sessionName] = SSOSession{
+					StartUrl:  rFlat.StartUrl,
+					SSORegion: rFlat.SSORegion,
+				}
+				if s, ok := ctx.Settings.SSO[ssoName]; ok {
+					config.Sessions[sessionName] = SSOSession{
+						StartUrl:  s.StartUrl,
+						SSORegion: s.SSORegion,
+					}
+				}
+			}
+			config.Profiles[ssoName][arn] = profile
 		}
-		line, err = r.ReadString('\n')
 	}
+	return config
+}
+
+// markedBlock is our section of a file between the Prefix and Suffix lines
+type markedBlock struct {
+	Prefix string
+	Suffix string
+	Body   string // an empty Body removes the block
+}
+
+// String returns the block with the markers
+func (m markedBlock) String() string {
+	if m.Body == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s\n%s\n%s\n", m.Prefix, m.Body, m.Suffix)
+}
+
+// configMarkers returns the markers for the given AWS SSO instance
+func configMarkers(ssoName string) (string, string) {
+	return fmt.Sprintf("%s %s", CONFIG_PREFIX, ssoName), fmt.Sprintf("%s %s", CONFIG_SUFFIX, ssoName)
+}
+
+// configBody executes the template and returns the body of our block
+func configBody(templ *template.Template, data ConfigTemplateData) (string, error) {
+	var body bytes.Buffer
+	if err := templ.Execute(&body, data); err != nil {
+		return "", err
+	}
+
+	ret := strings.TrimRight(body.String(), "\n")
+	if strings.TrimSpace(ret) == "" {
+		return "", nil
+	}
+	return ret + "\n", nil
+}
 
-	endOfFile := false
-	if err != nil && err != io.EOF {
+// updateMarkedFile rewrites the file with the contents returned by update or
+// prints the diff if diffOnly is set.  A missing file is treated as empty.
+// The number of backups to keep may be zero.
+func updateMarkedFile(path string, diffOnly bool, backups int, update func(string) string) error {
+	input, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
-	} else if err == io.EOF {
-		// Reached EOF before finding our CONFIG_PREFIX
-		endOfFile = true
 	}
 
-	// write our template out
-	if err = templ.Execute(w, profiles); err != nil {
+	output := update(string(input))
+	if output == string(input) {
+		// do nothing if there is no diff
+		log.Infof("No changes to made to %s", path)
+		return nil
+	}
+
+	if diffOnly {
+		fmt.Printf("%s", generateDiff(path, string(input), output))
+		return nil
+	}
+
+	return writeMarkedFile(path, input, []byte(output), backups)
+}
+
+// writeMarkedFile atomically replaces the file with output after making a
+// backup as long as the file still contains input
+func writeMarkedFile(path string, input, output []byte, backups int) error {
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
+	if !bytes.Equal(current, input) {
+		return fmt.Errorf("%s was modified by another process, please try again", path)
+	}
 
-	if !endOfFile {
-		line, err = r.ReadString('\n')
-		// consume our entries and the suffix
-		for err == nil && line != fmt.Sprintf("%s\n", CONFIG_SUFFIX) {
-			line, err = r.ReadString('\n')
+	if backups > 0 {
+		backup, err := utils.BackupFile(path, backups)
+		if err != nil {
+			return fmt.Errorf("Unable to backup %s: %s", path, err.Error())
+		}
+		if backup != "" {
+			log.Infof("Saved backup of %s to %s", path, backup)
 		}
+	}
+	return utils.WriteFileAtomic(path, output, 0600)
+}
 
-		// if not EOF or other error, read & write the config until EOF
-		if err == nil {
-			// read until error
-			line, err = r.ReadString('\n')
-			for err == nil {
-				if _, err = w.WriteString(line); err != nil {
-					return err
-				}
-				line, err = r.ReadString('\n')
+// restoreConfig replaces the config file with the selected backup
+func restoreConfig(ctx *RunContext) error {
+	path := awsConfigFile()
+	backups, err := utils.ListBackups(path)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("No backups of %s found", path)
+	}
+
+	backup := backups[0]
+	if ctx.Cli.Config.Backup != "" {
+		backup = ""
+		for _, b := range backups {
+			if b == ctx.Cli.Config.Backup || filepath.Base(b) == ctx.Cli.Config.Backup {
+				backup = b
+				break
 			}
-			if err != io.EOF {
-				return err
+		}
+		if backup == "" {
+			names := []string{}
+			for _, b := range backups {
+				names = append(names, filepath.Base(b))
 			}
+			return fmt.Errorf("Invalid backup '%s'.  Valid options:\n%s",
+				ctx.Cli.Config.Backup, strings.Join(names, "\n"))
 		}
 	}
-	w.Flush()
-	output.Close()
-	input.Close()
 
-	diff, err := generateDiff(configFile, tempFileName)
+	output, err := os.ReadFile(backup)
 	if err != nil {
 		return err
 	}
+	input, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 
-	if len(diff) == 0 {
-		// do nothing if there is no diff
-		log.Infof("No changes to made to %s", configFile)
+	if bytes.Equal(input, output) {
+		log.Infof("%s is the same as %s", path, backup)
 		return nil
 	}
 
 	if ctx.Cli.Config.Diff {
-		fmt.Printf("%s", diff)
+		fmt.Printf("%s", generateDiff(path, string(input), string(output)))
 		return nil
 	}
 
-	// copy file into place
-	input, err = os.Open(tempFileName) // output/tempFile is now the input!
-	if err != nil {
+	if err = writeMarkedFile(path, input, output, ctx.Settings.ConfigBackups); err != nil {
 		return err
 	}
-	output, err = os.OpenFile(configFile, os.O_RDWR|os.O_CREATE, 0600) // ~/.aws/config is now the output
-	if err != nil {
-		return err
+	fmt.Printf("Restored %s from %s\n", path, backup)
+	return nil
+}
+
+// replaceMarkedBlock returns the contents with the block replaced.  If the
+// contents do not have the block, it is appended.
+func replaceMarkedBlock(contents string, block markedBlock) string {
+	return replaceLines(contents, block.Prefix, block.Suffix, block.String())
+}
+
+// replaceLines returns the contents with the lines from prefix to suffix
+// replaced with replacement.  If prefix is not found, replacement is appended.
+func replaceLines(contents, prefix, suffix, replacement string) string {
+	var before, after strings.Builder
+	inBlock, found := false, false
+
+	lines := strings.SplitAfter(contents, "\n")
+	for _, line := range lines {
+		switch {
+		case !found && strings.TrimRight(line, "\r\n") == prefix:
+			inBlock, found = true, true
+		case inBlock:
+			if strings.TrimRight(line, "\r\n") == suffix {
+				inBlock = false
+			}
+		case found:
+			after.WriteString(line)
+		default:
+			before.WriteString(line)
+		}
+	}
+
+	ret := before.String()
+	if len(ret) > 0 && !strings.HasSuffix(ret, "\n") {
+		ret += "\n"
 	}
-	_, err = io.Copy(output, input)
-	return err
+	return ret + replacement + after.String()
+}
+
+// readMarkedBlock returns the lines between the prefix and suffix in the file
+func readMarkedBlock(path, prefix, suffix string) ([]string, error) {
+	input, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return []string{}, err
+	}
+
+	ret := []string{}
+	inBlock := false
+	for _, line := range strings.Split(string(input), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == prefix:
+			inBlock = true
+		case line == suffix && inBlock:
+			return ret, nil
+		case inBlock:
+			ret = append(ret, line)
+		}
+	}
+	return ret, nil
+}
+
+// markedBlockExists returns if the file contains the prefix line
+func markedBlockExists(path, prefix string) (bool, error) {
+	input, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(input), "\n") {
+		if strings.TrimRight(line, "\r") == prefix {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // awsConfigFile returns the path the the users ~/.aws/config
@@ -224,19 +583,10 @@ func awsConfigFile() string {
 	return path
 }
 
-// generateDiff generates a diff between two files.  Takes two file names as inputs
-func generateDiff(aFile, bFile string) (string, error) {
-	// open the files fresh for the diff
-	aBytes, err := ioutil.ReadFile(aFile)
-	if err != nil {
-		return "", err
-	}
-	bBytes, err := ioutil.ReadFile(bFile)
-	if err != nil {
-		return "", err
-	}
-	edits := myers.ComputeEdits(span.URIFromPath(aFile), string(aBytes), string(bBytes))
-	diff := fmt.Sprintf("%s", gotextdiff.ToUnified(aFile, bFile, string(aBytes), edits))
+// generateDiff generates a unified diff of the changes to the file
+func generateDiff(path, a, b string) string {
+	edits := myers.ComputeEdits(span.URIFromPath(path), a, b)
+	diff := fmt.Sprintf("%s", gotextdiff.ToUnified(path, path+".new", a, edits))
 	log.Debugf("diff:\n%s", diff)
-	return diff, nil
+	return diff
 }
@@ -0,0 +1,276 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/sso"
+)
+
+func TestSsoSessionName(t *testing.T) {
+	assert.Equal(t, "Default", ssoSessionName("Default"))
+	assert.Equal(t, "My-Org", ssoSessionName("My Org"))
+	assert.Equal(t, "a-b_c.d-e", ssoSessionName("a/b_c.d[e"))
+}
+
+// testProfiles returns the profiles for every role in testSettings()
+func testProfiles(t *testing.T) (*RunContext, ProfileMap) {
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+	ctx.Cli.Config.Open = "open"
+	profiles, err := configProfiles(ctx, "/usr/local/bin/aws-sso", sso.ProfilesFilter{})
+	assert.NoError(t, err)
+	return ctx, profiles
+}
+
+func TestConfigProfiles(t *testing.T) {
+	ctx, profiles := testProfiles(t)
+	assert.Len(t, profiles["Default"], 5)
+	assert.Len(t, profiles["My Org"], 1)
+
+	admin := profiles["Default"]["arn:aws:iam::000000000001:role/Admin"]
+	assert.Equal(t, "one:Admin", admin.Profile)
+	assert.Equal(t, "Default", admin.Sso)
+	assert.Equal(t, "Default", admin.Role.SSO)
+	assert.Equal(t, map[string]interface{}{"region": "eu-west-1", "output": "json"},
+		profiles["Default"]["arn:aws:iam::000000000001:role/ReadOnly"].ConfigVariables)
+
+	filter := sso.ProfilesFilter{SSO: []string{"My Org"}}
+	profiles, err := configProfiles(ctx, "aws-sso", filter)
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Contains(t, profiles["My Org"], "arn:aws:iam::000000000004:role/Admin")
+
+	// every role has the same profile name
+	ctx.Settings.ProfileFormat = "{{ .RoleName }}"
+	_, err = configProfiles(ctx, "aws-sso", sso.ProfilesFilter{})
+	assert.Contains(t, err.Error(), "Duplicate profile name")
+}
+
+func TestSsoSessionConfig(t *testing.T) {
+	ctx, profiles := testProfiles(t)
+
+	// config.yaml takes priority over the cache
+	data := ssoSessionConfig(ctx, profiles)
+	assert.Equal(t, CONFIG_MODE_SSO_SESSION, data.Mode)
+	assert.Equal(t, map[string]SSOSession{
+		"Default": {
+			StartUrl:  "https://default.awsapps.com/start",
+			SSORegion: "us-west-2",
+		},
+		"My-Org": {
+			StartUrl:  "https://myorg.awsapps.com/start",
+			SSORegion: "eu-central-1",
+		},
+	}, data.Sessions)
+
+	p := data.Profiles["Default"]
+	admin := p["arn:aws:iam::000000000001:role/Admin"]
+	assert.Equal(t, "Default", admin.SsoSession)
+	assert.Equal(t, "000000000001", admin.AccountId)
+	assert.Equal(t, "Admin", admin.RoleName)
+	assert.Equal(t, "us-east-1", admin.Region)
+	assert.Equal(t, "", admin.SourceProfile)
+
+	// region in ConfigVariables wins
+	assert.Equal(t, "", p["arn:aws:iam::000000000001:role/ReadOnly"].Region)
+
+	chained := p["arn:aws:iam::000000000002:role/Chained"]
+	assert.Equal(t, "one:Admin", chained.SourceProfile)
+	assert.Equal(t, "", chained.SsoSession)
+
+	orphan := p["arn:aws:iam::000000000003:role/Orphan"]
+	assert.Equal(t, "", orphan.SourceProfile)
+	assert.Equal(t, "", orphan.SsoSession)
+
+	assert.Equal(t, "My-Org", data.Profiles["My Org"]["arn:aws:iam::000000000004:role/Admin"].SsoSession)
+
+	// fall back to the cache
+	delete(ctx.Settings.SSO, "Default")
+	data = ssoSessionConfig(ctx, profiles)
+	assert.Equal(t, SSOSession{
+		StartUrl:  "https://cache.awsapps.com/start",
+		SSORegion: "us-east-1",
+	}, data.Sessions["Default"])
+}
+
+func TestConfigTemplates(t *testing.T) {
+	ctx, profiles := testProfiles(t)
+
+	tests := []struct {
+		golden string
+		format string
+		data   ConfigTemplateData
+	}{
+		{
+			golden: "credential_process.golden",
+			format: CONFIG_TEMPLATE,
+			data: ConfigTemplateData{
+				Mode:     CONFIG_MODE_PROCESS,
+				Sessions: map[string]SSOSession{},
+				Profiles: profiles,
+			},
+		},
+		{
+			golden: "sso_session.golden",
+			format: SSO_SESSION_TEMPLATE,
+			data:   ssoSessionConfig(ctx, profiles),
+		},
+	}
+
+	for _, test := range tests {
+		templ, err := template.New("profile").Funcs(sso.TemplateFuncMap()).Parse(test.format)
+		assert.NoError(t, err)
+		body, err := configBody(templ, test.data)
+		assert.NoError(t, err, test.golden)
+		assertGolden(t, test.golden, body)
+	}
+
+	// no profiles means no block
+	templ := template.Must(template.New("profile").Parse(SSO_SESSION_TEMPLATE))
+	body, err := configBody(templ, ConfigTemplateData{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", body)
+}
+
+func TestReplaceLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		contents    string
+		replacement string
+		expected    string
+	}{
+		{
+			name:        "empty file",
+			contents:    "",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "BEGIN\nnew\nEND\n",
+		},
+		{
+			name:        "append",
+			contents:    "[default]\nregion = us-east-1\n",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "[default]\nregion = us-east-1\nBEGIN\nnew\nEND\n",
+		},
+		{
+			name:        "replace",
+			contents:    "before\nBEGIN\nold\nold\nEND\nafter\n",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "before\nBEGIN\nnew\nEND\nafter\n",
+		},
+		{
+			name:        "remove",
+			contents:    "before\nBEGIN\nold\nEND\nafter\n",
+			replacement: "",
+			expected:    "before\nafter\n",
+		},
+		{
+			name:        "no trailing newline",
+			contents:    "[default]\nregion = us-east-1",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "[default]\nregion = us-east-1\nBEGIN\nnew\nEND\n",
+		},
+		{
+			name:        "windows line endings",
+			contents:    "before\r\nBEGIN\r\nold\r\nEND\r\nafter\r\n",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "before\r\nBEGIN\nnew\nEND\nafter\r\n",
+		},
+		{
+			name:        "missing suffix",
+			contents:    "before\nBEGIN\nold\n",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "before\nBEGIN\nnew\nEND\n",
+		},
+		{
+			name:        "only the first block",
+			contents:    "BEGIN\nold\nEND\nBEGIN\nother\nEND\n",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "BEGIN\nnew\nEND\nBEGIN\nother\nEND\n",
+		},
+		{
+			name:        "prefix must match the whole line",
+			contents:    "# BEGIN\nBEGIN_OTHER\n",
+			replacement: "BEGIN\nnew\nEND\n",
+			expected:    "# BEGIN\nBEGIN_OTHER\nBEGIN\nnew\nEND\n",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, replaceLines(test.contents, "BEGIN", "END", test.replacement), test.name)
+	}
+}
+
+func TestConfigFilter(t *testing.T) {
+	settings := testSettings(t)
+	settings.ConfigFilter = sso.ProfilesFilter{
+		SSO: []string{"Default"},
+	}
+
+	// set by eval & exec, so ignored
+	t.Setenv("AWS_SSO", "My Org")
+	ctx := testRunContext(t, settings, "config", "--open", "open")
+	assert.Equal(t, "My Org", ctx.Cli.SSO)
+	filter, err := configFilter(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Default"}, filter.SSO)
+
+	ctx = testRunContext(t, settings, "config", "--open", "open", "--sso", "My Org", "--history-only")
+	filter, err = configFilter(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"My Org"}, filter.SSO)
+	assert.True(t, filter.HistoryOnly)
+
+	ctx = testRunContext(t, settings, "-S", "Invalid", "config", "--open", "open")
+	_, err = configFilter(ctx)
+	assert.Contains(t, err.Error(), "'Invalid' is not a valid AWS SSO Instance")
+}
+
+func TestUpdateMarkedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	block := markedBlock{Prefix: "BEGIN", Suffix: "END", Body: "a much longer body\nthan the next one"}
+	update := func(contents string) string {
+		return replaceMarkedBlock(contents, block)
+	}
+
+	// last line has no trailing newline
+	assert.NoError(t, os.WriteFile(path, []byte("[default]\nregion = us-east-1"), 0600))
+	assert.NoError(t, updateMarkedFile(path, false, 0, update))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "[default]\nregion = us-east-1\nBEGIN\na much longer body\nthan the next one\nEND\n", string(data))
+
+	// shorter output is not left with the end of the old file
+	block.Body = "short"
+	assert.NoError(t, updateMarkedFile(path, false, 0, update))
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "[default]\nregion = us-east-1\nBEGIN\nshort\nEND\n", string(data))
+
+	// empty body removes the block
+	block.Body = ""
+	assert.NoError(t, updateMarkedFile(path, false, 0, update))
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "[default]\nregion = us-east-1\n", string(data))
+}
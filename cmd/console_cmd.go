@@ -32,7 +32,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
-	"github.com/c-bata/go-prompt"
 	"github.com/synfinatic/aws-sso-cli/sso"
 	"github.com/synfinatic/aws-sso-cli/storage"
 	"github.com/synfinatic/aws-sso-cli/utils"
@@ -42,14 +41,21 @@ const AWS_FEDERATED_URL = "https://signin.aws.amazon.com/federation"
 
 type ConsoleCmd struct {
 	// Console actually should honor the --region flag
-	Region   string `kong:"help='AWS Region',env='AWS_DEFAULT_REGION',predictor='region'"`
-	Duration int32  `kong:"short='d',help='AWS Session duration in minutes (default 60)'"` // default stored in DEFAULT_CONFIG
-	Prompt   bool   `kong:"short='P',help='Force interactive prompt to select role'"`
-
-	Arn       string `kong:"short='a',help='ARN of role to assume',env='AWS_SSO_ROLE_ARN',predictor='arn'"`
-	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of role to assume',env='AWS_SSO_ACCOUNT_ID',predictor='accountId'"`
-	Role      string `kong:"short='R',help='Name of AWS Role to assume',env='AWS_SSO_ROLE_NAME',predictor='role'"`
-	Profile   string `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile'"`
+	Region     string `kong:"help='AWS Region',env='AWS_DEFAULT_REGION',predictor='region'"`
+	Duration   int32  `kong:"short='d',help='AWS Session duration in minutes (default 60)'"` // default stored in DEFAULT_CONFIG
+	Prompt     bool   `kong:"short='P',help='Force interactive prompt to select role'"`
+	SelectMode string `kong:"help='Interactive role selection mode [tags|fuzzy] (default: tags)'"`
+	Service    string `kong:"help='Open the AWS Console for the service',xor='dest',predictor='service'"`
+	Url        string `kong:"help='Open the AWS Console path, URL or resource ARN',xor='dest'"`
+	Session    string `kong:"help='Isolate the role in a browser session [none|firefox-container|chromium-profile]'"`
+	Logout     bool   `kong:"help='Sign out of the current AWS Console session first'"`
+
+	Arn       string            `kong:"short='a',help='ARN of role to assume',env='AWS_SSO_ROLE_ARN',predictor='arn'"`
+	AccountId int64             `kong:"name='account',short='A',help='AWS AccountID of role to assume',env='AWS_SSO_ACCOUNT_ID',predictor='accountId'"`
+	Role      string            `kong:"short='R',help='Name of AWS Role to assume',env='AWS_SSO_ROLE_NAME',predictor='role'"`
+	Profile   string            `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile'"`
+	Tag       map[string]string `kong:"help='Select the role with the tag Key=Value (repeatable)',predictor='tag'"`
+	First     bool              `kong:"help='Use the first role if --tag matches multiple roles'"`
 
 	AccessKeyId     string `kong:"env='AWS_ACCESS_KEY_ID',hidden"`
 	SecretAccessKey string `kong:"env='AWS_SECRET_ACCESS_KEY',hidden"`
@@ -58,11 +64,21 @@ type ConsoleCmd struct {
 }
 
 func (cc *ConsoleCmd) Run(ctx *RunContext) error {
+	if err := sso.ValidBrowserSessionMode(ctx.Cli.Console.Session); err != nil {
+		return err
+	}
+
 	duration := ctx.Settings.ConsoleDuration
 	if ctx.Cli.Console.Duration > 0 {
 		duration = ctx.Cli.Console.Duration
 	}
 
+	if err := checkFirstFlag(ctx.Cli.Console.First, ctx.Cli.Console.Tag); err != nil {
+		return err
+	}
+
+	// --tag is checked before --arn, --account & --role since those may come
+	// from the environment variables set by eval & exec
 	if ctx.Cli.Console.Prompt {
 		return consolePrompt(ctx)
 	} else if ctx.Cli.Console.Profile != "" {
@@ -74,6 +90,17 @@ func (cc *ConsoleCmd) Run(ctx *RunContext) error {
 		}
 
 		return openConsole(ctx, awssso, rFlat.AccountId, rFlat.RoleName)
+	} else if len(ctx.Cli.Console.Tag) > 0 {
+		arn, err := selectRoleByTags(ctx, ctx.Cli.Console.Tag, ctx.Cli.Console.First)
+		if err != nil {
+			return err
+		}
+		accountid, role, err := utils.ParseRoleARN(arn)
+		if err != nil {
+			return err
+		}
+		awssso := doAuth(ctx)
+		return openConsole(ctx, awssso, accountid, role)
 	} else if ctx.Cli.Console.Arn != "" {
 		awssso := doAuth(ctx)
 
@@ -96,11 +123,11 @@ func (cc *ConsoleCmd) Run(ctx *RunContext) error {
 	return consolePrompt(ctx)
 }
 
-func stsSession(ctx *RunContext) (*sts.Client, error) {
+func stsSession(ctx *RunContext, accessKeyId, secretAccessKey, sessionToken string) (*sts.Client, error) {
 	cfgCreds := credentials.NewStaticCredentialsProvider(
-		ctx.Cli.Console.AccessKeyId,
-		ctx.Cli.Console.SecretAccessKey,
-		ctx.Cli.Console.SessionToken,
+		accessKeyId,
+		secretAccessKey,
+		sessionToken,
 	)
 
 	ssoRegion := ctx.Settings.SSO[ctx.Cli.SSO].SSORegion
@@ -115,23 +142,32 @@ func stsSession(ctx *RunContext) (*sts.Client, error) {
 	return sts.NewFromConfig(cfg), nil
 }
 
-func consoleViaEnvVars(ctx *RunContext, duration int32) error {
-	// ask AWS STS for who we are so we can look it up in our cache
+// callerIdentity asks AWS STS who we are and returns the AccountID and Role name
+func callerIdentity(stsHandle *sts.Client) (int64, string, error) {
+	input := sts.GetCallerIdentityInput{}
+	output, err := stsHandle.GetCallerIdentity(context.TODO(), &input)
+	if err != nil {
+		return 0, "", fmt.Errorf("Unable to call sts get-caller-identity: %s", err.Error())
+	}
 
-	stsHandle, err := stsSession(ctx)
+	accountid, role, err := utils.ParseAssumedRoleARN(aws.ToString(output.Arn))
 	if err != nil {
-		return err
+		return 0, "", fmt.Errorf("Unable to parse ARN: %s", aws.ToString(output.Arn))
 	}
+	return accountid, role, nil
+}
 
-	input := sts.GetCallerIdentityInput{}
-	output, err := stsHandle.GetCallerIdentity(context.TODO(), &input)
+func consoleViaEnvVars(ctx *RunContext, duration int32) error {
+	// ask AWS STS for who we are so we can look it up in our cache
+	stsHandle, err := stsSession(ctx, ctx.Cli.Console.AccessKeyId,
+		ctx.Cli.Console.SecretAccessKey, ctx.Cli.Console.SessionToken)
 	if err != nil {
-		return fmt.Errorf("Unable to call sts get-caller-identity: %s", err.Error())
+		return err
 	}
 
-	accountid, role, err := utils.ParseRoleARN(aws.ToString(output.Arn))
+	accountid, role, err := callerIdentity(stsHandle)
 	if err != nil {
-		return fmt.Errorf("Unable to parse ARN: %s", aws.ToString(output.Arn))
+		return err
 	}
 
 	// now we know who we are, get our configured default region
@@ -140,12 +176,17 @@ func consoleViaEnvVars(ctx *RunContext, duration int32) error {
 		region = ctx.Cli.Console.Region
 	}
 
+	dest, err := consoleDestination(ctx, accountid, role, region)
+	if err != nil {
+		return err
+	}
+
 	creds := storage.RoleCredentials{
 		AccessKeyId:     ctx.Cli.Console.AccessKeyId,
 		SecretAccessKey: ctx.Cli.Console.SecretAccessKey,
 		SessionToken:    ctx.Cli.Console.SessionToken,
 	}
-	return openConsoleAccessKey(ctx, &creds, duration, region)
+	return openConsoleAccessKey(ctx, &creds, duration, region, dest, consoleRole(ctx, accountid, role))
 }
 
 func consoleViaSDK(ctx *RunContext, duration int32) error {
@@ -163,10 +204,16 @@ func consoleViaSDK(ctx *RunContext, duration int32) error {
 		region = "us-east-1" // need a region for a valid url!
 	}
 
+	dest, err := consoleDestination(ctx, 0, "", region)
+	if err != nil {
+		return err
+	}
+
 	// have to use the Go SDK to load our creds because apparently the profile
 	// is based on static API creds
 
-	stsHandle, err := stsSession(ctx)
+	stsHandle, err := stsSession(ctx, ctx.Cli.Console.AccessKeyId,
+		ctx.Cli.Console.SecretAccessKey, ctx.Cli.Console.SessionToken)
 	if err != nil {
 		return err
 	}
@@ -190,31 +237,22 @@ func consoleViaSDK(ctx *RunContext, duration int32) error {
 		SessionToken:    aws.ToString(token.Credentials.SessionToken),
 	}
 
-	return openConsoleAccessKey(ctx, &creds, duration, region)
+	// static profiles have no role so the browser session is based on the profile
+	profile := &sso.AWSRoleFlat{
+		Profile: ctx.Cli.Console.AwsProfile,
+	}
+	return openConsoleAccessKey(ctx, &creds, duration, region, dest, profile)
 }
 
 func consolePrompt(ctx *RunContext) error {
 	// use completer to figure out the role
-	fmt.Printf("Please use `exit` or `Ctrl-D` to quit.\n")
-
 	sso, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
 	if err != nil {
 		return err
 	}
 	sso.Refresh(ctx.Settings)
 
-	c := NewTagsCompleter(ctx, sso, openConsole)
-	opts := ctx.Settings.DefaultOptions(c.ExitChecker)
-	opts = append(opts, ctx.Settings.GetColorOptions()...)
-
-	p := prompt.New(
-		c.Executor,
-		c.Complete,
-		opts...,
-	)
-
-	p.Run()
-	return nil
+	return selectRolePrompt(ctx, sso, ctx.Cli.Console.SelectMode, openConsole, false)
 }
 
 // haveAWSEnvVars returns true if we have all the AWS environment variables we need for a role
@@ -251,14 +289,63 @@ func openConsole(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role stri
 		log.WithError(err).Warnf("Unable to update cache")
 	}
 
+	dest, err := consoleDestination(ctx, accountid, role, region)
+	if err != nil {
+		return err
+	}
+
 	creds := GetRoleCredentials(ctx, awssso, accountid, role)
 
-	return openConsoleAccessKey(ctx, creds, duration, region)
+	return openConsoleAccessKey(ctx, creds, duration, region, dest, consoleRole(ctx, accountid, role))
+}
+
+// consoleRole returns the role from the cache or a minimal role if it is unknown
+func consoleRole(ctx *RunContext, accountid int64, role string) *sso.AWSRoleFlat {
+	if rFlat, err := ctx.Settings.Cache.GetSSO().Roles.GetRole(accountid, role); err == nil {
+		return rFlat
+	}
+	return &sso.AWSRoleFlat{
+		AccountId: accountid,
+		RoleName:  role,
+		Arn:       utils.MakeRoleARN(accountid, role),
+	}
 }
 
-// openConsoleAccessKey opens the Frederated Console access URL
-func openConsoleAccessKey(ctx *RunContext, creds *storage.RoleCredentials, duration int32, region string) error {
+// consoleDestination returns the AWS Console URL to land on.  The --service
+// and --url flags override the ConsoleUrl configured for the role.
+func consoleDestination(ctx *RunContext, accountid int64, role, region string) (string, error) {
+	if ctx.Cli.Console.Service != "" {
+		return utils.ConsoleServiceUrl(ctx.Cli.Console.Service, region, ctx.Settings.ConsoleServices)
+	}
+
+	dest := ctx.Cli.Console.Url
+	if dest == "" && accountid > 0 {
+		ssoName, err := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+		if err != nil {
+			return "", err
+		}
+		dest = ctx.Settings.GetConsoleUrl(ssoName, accountid, role)
+	}
+	return utils.ConsoleDestinationUrl(dest, region, ctx.Settings.ConsoleServices)
+}
+
+// openConsoleAccessKey opens the Frederated Console access URL for the destination
+// in the browser session of the role
+func openConsoleAccessKey(ctx *RunContext, creds *storage.RoleCredentials, duration int32,
+	region, dest string, rFlat *sso.AWSRoleFlat) error {
+	session, err := ctx.Settings.GetBrowserSession(ctx.Cli.Console.Session, rFlat)
+	if err != nil {
+		return err
+	}
+
+	ssoName, err := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+	if err != nil {
+		return err
+	}
+
+	partition := utils.RegionPartition(region)
 	signin := SigninTokenUrlParams{
+		Federation:      partition.FederationUrl(),
 		SessionDuration: duration * 60,
 		Session: SessionUrlParams{
 			AccessKeyId:     creds.AccessKeyId,
@@ -289,14 +376,18 @@ func openConsoleAccessKey(ctx *RunContext, creds *storage.RoleCredentials, durat
 	}
 
 	login := LoginUrlParams{
-		Issuer:      "https://github.com/synfinatic/aws-sso-cli",
-		Destination: fmt.Sprintf("https://console.aws.amazon.com/console/home?region=%s", region),
+		Federation:  partition.FederationUrl(),
+		Issuer:      ctx.Settings.GetConsoleIssuer(ssoName),
+		Destination: dest,
 		SigninToken: loginResponse.SigninToken,
 	}
+	if ctx.Cli.Console.Logout || ctx.Settings.GetConsoleLogout(ssoName, rFlat.AccountId, rFlat.RoleName) {
+		login.Logout = partition.LogoutUrl()
+	}
 	url := login.GetUrl()
 
 	urlOpener := utils.NewHandleUrl(ctx.Settings.UrlAction, ctx.Settings.Browser, ctx.Settings.UrlExecCommand)
-	return urlOpener.Open(url,
+	return urlOpener.OpenSession(url, session,
 		"Please open the following URL in your browser:\n\n", "\n\n")
 }
 
@@ -304,14 +395,23 @@ type LoginResponse struct {
 	SigninToken string `json:"SigninToken"`
 }
 
+// federationUrl returns the federation endpoint or the default one
+func federationUrl(federation string) string {
+	if federation == "" {
+		return AWS_FEDERATED_URL
+	}
+	return federation
+}
+
 type SigninTokenUrlParams struct {
+	Federation      string // defaults to AWS_FEDERATED_URL
 	SessionDuration int32
 	Session         SessionUrlParams // URL encoded SessionUrlParams
 }
 
 func (stup *SigninTokenUrlParams) GetUrl() string {
 	return fmt.Sprintf("%s?Action=getSigninToken&SessionDuration=%d&Session=%s",
-		AWS_FEDERATED_URL, stup.SessionDuration, stup.Session.Encode())
+		federationUrl(stup.Federation), stup.SessionDuration, stup.Session.Encode())
 }
 
 type SessionUrlParams struct {
@@ -326,13 +426,22 @@ func (sup *SessionUrlParams) Encode() string {
 }
 
 type LoginUrlParams struct {
+	Federation  string // defaults to AWS_FEDERATED_URL
+	Logout      string // logout endpoint to sign out of any existing session first
 	Issuer      string
 	Destination string
 	SigninToken string
 }
 
+// GetUrl returns the login URL, which redirects through the Logout endpoint
+// when set
 func (lup *LoginUrlParams) GetUrl() string {
-	return fmt.Sprintf("%s?Action=login&Issuer=%s&Destination=%s&SigninToken=%s",
-		AWS_FEDERATED_URL, lup.Issuer, lup.Destination,
-		lup.SigninToken)
+	login := fmt.Sprintf("%s?Action=login&Issuer=%s&Destination=%s&SigninToken=%s",
+		federationUrl(lup.Federation), url.QueryEscape(lup.Issuer),
+		url.QueryEscape(lup.Destination), url.QueryEscape(lup.SigninToken))
+
+	if lup.Logout == "" {
+		return login
+	}
+	return fmt.Sprintf("%s?Action=logout&redirect_uri=%s", lup.Logout, url.QueryEscape(login))
 }
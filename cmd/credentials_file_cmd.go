@@ -0,0 +1,269 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/server"
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	AWS_CREDENTIALS_FILE  = "~/.aws/credentials"
+	CREDENTIALS_EXPIRES   = "# Expires: "
+	CREDENTIALS_WATCH_INT = time.Minute
+)
+
+type CredentialsFileCmd struct {
+	Arn     []string          `kong:"short='a',help='ARN of role to write (repeatable)',predictor='arn'"`
+	Profile []string          `kong:"short='p',help='Name of AWS Profile to write (repeatable)',predictor='profile'"`
+	Tag     map[string]string `kong:"help='Write all roles with the tag Key=Value',predictor='tag'"`
+	Clean   bool              `kong:"help='Only remove expired credentials from the file'"`
+	Watch   bool              `kong:"help='Keep running and refresh the credentials before they expire'"`
+	Diff    bool              `kong:"help='Print a diff of changes to the credentials file instead of modifying it'"`
+}
+
+// credentialsSection is a [profile] in the credentials file
+type credentialsSection struct {
+	Name    string
+	Expires int64 // Unix Epoch
+	Lines   []string
+}
+
+func (cc *CredentialsFileCmd) Run(ctx *RunContext) error {
+	args := ctx.Cli.CredentialsFile
+	path := awsCredentialsFile()
+
+	if args.Clean {
+		if len(args.Arn) > 0 || len(args.Profile) > 0 || len(args.Tag) > 0 || args.Watch {
+			return fmt.Errorf("--clean can not be used with other flags")
+		}
+		return writeCredentialsFile(path, map[string]credentialsSection{}, args.Diff)
+	}
+
+	arns, err := credentialsFileArns(ctx)
+	if err != nil {
+		return err
+	}
+
+	getCreds := serverCredentials(ctx)
+	sections := map[string]credentialsSection{}
+	update := func() error {
+		for _, arn := range arns {
+			if s, ok := sections[arn]; ok && !server.NeedsRotation(storage.RoleCredentials{Expiration: s.Expires * 1000}) {
+				continue
+			}
+			creds, err := getCreds(arn)
+			if err != nil {
+				return err
+			}
+			rFlat, err := ctx.Settings.Cache.GetRole(arn)
+			if err != nil {
+				return err
+			}
+			name, err := rFlat.ProfileName(ctx.Settings)
+			if err != nil {
+				return err
+			}
+			sections[arn] = newCredentialsSection(name, creds)
+		}
+
+		named := map[string]credentialsSection{}
+		for _, s := range sections {
+			named[s.Name] = s
+		}
+		return writeCredentialsFile(path, named, args.Diff)
+	}
+
+	if err = update(); err != nil || !args.Watch {
+		return err
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(CREDENTIALS_WATCH_INT)
+	defer ticker.Stop()
+
+	log.Infof("Refreshing credentials in %s before they expire", path)
+	for {
+		select {
+		case <-sigChan:
+			return nil
+		case <-ticker.C:
+			if err = update(); err != nil {
+				log.WithError(err).Errorf("Unable to refresh credentials")
+			}
+		}
+	}
+}
+
+// credentialsFileArns returns the ARNs of the roles selected by the user
+func credentialsFileArns(ctx *RunContext) ([]string, error) {
+	args := ctx.Cli.CredentialsFile
+	arns := map[string]bool{}
+
+	for _, arn := range args.Arn {
+		if _, _, err := utils.ParseRoleARN(arn); err != nil {
+			return []string{}, err
+		}
+		arns[arn] = true
+	}
+
+	for _, profile := range args.Profile {
+		arn, err := serverResolve(ctx)(profile)
+		if err != nil {
+			return []string{}, err
+		}
+		arns[arn] = true
+	}
+
+	if len(args.Tag) > 0 {
+		matches := matchingRoleArns(ctx, args.Tag)
+		if len(matches) == 0 {
+			return []string{}, fmt.Errorf("No roles match the provided --tag")
+		}
+		for _, arn := range matches {
+			arns[arn] = true
+		}
+	}
+
+	if len(arns) == 0 {
+		return []string{}, fmt.Errorf("Please specify --arn, --profile, --tag or --clean")
+	}
+
+	ret := []string{}
+	for arn := range arns {
+		ret = append(ret, arn)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// awsCredentialsFile returns the path to the users ~/.aws/credentials
+func awsCredentialsFile() string {
+	path := os.Getenv(ENV_SHARED_CREDENTIALS_FILE)
+	if path == "" {
+		path = utils.GetHomePath(AWS_CREDENTIALS_FILE)
+	}
+	return path
+}
+
+// newCredentialsSection returns the credentials file entry for the creds
+func newCredentialsSection(name string, creds storage.RoleCredentials) credentialsSection {
+	return credentialsSection{
+		Name:    name,
+		Expires: creds.ExpireEpoch(),
+		Lines: []string{
+			fmt.Sprintf("aws_access_key_id = %s", creds.AccessKeyId),
+			fmt.Sprintf("aws_secret_access_key = %s", creds.SecretAccessKey),
+			fmt.Sprintf("aws_session_token = %s", creds.SessionToken),
+		},
+	}
+}
+
+// readCredentialsSections returns the sections we manage in the credentials file
+func readCredentialsSections(path string) (map[string]credentialsSection, error) {
+	lines, err := readMarkedBlock(path, CONFIG_PREFIX, CONFIG_SUFFIX)
+	if err != nil {
+		return map[string]credentialsSection{}, err
+	}
+
+	sections := map[string]credentialsSection{}
+	var current *credentialsSection
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			if current != nil {
+				sections[current.Name] = *current
+			}
+			current = &credentialsSection{Name: strings.Trim(line, "[]")}
+
+		case current == nil || line == "":
+			continue
+
+		case strings.HasPrefix(line, CREDENTIALS_EXPIRES):
+			t, err := time.Parse(time.RFC3339, strings.TrimPrefix(line, CREDENTIALS_EXPIRES))
+			if err != nil {
+				log.WithError(err).Warnf("Invalid expiration for [%s]", current.Name)
+				continue
+			}
+			current.Expires = t.Unix()
+
+		default:
+			current.Lines = append(current.Lines, line)
+		}
+	}
+	if current != nil {
+		sections[current.Name] = *current
+	}
+	return sections, nil
+}
+
+// writeCredentialsFile merges the sections with the unexpired sections already
+// in the file and writes them out
+func writeCredentialsFile(path string, sections map[string]credentialsSection, diff bool) error {
+	existing, err := readCredentialsSections(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for name, s := range existing {
+		if _, ok := sections[name]; !ok && s.Expires > now {
+			sections[name] = s
+		} else if !ok {
+			log.Infof("Removing expired credentials for [%s]", name)
+		}
+	}
+
+	names := []string{}
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		s := sections[name]
+		fmt.Fprintf(&b, "\n[%s]\n%s%s\n", s.Name, CREDENTIALS_EXPIRES,
+			time.Unix(s.Expires, 0).UTC().Format(time.RFC3339))
+		for _, line := range s.Lines {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	block := markedBlock{
+		Prefix: CONFIG_PREFIX,
+		Suffix: CONFIG_SUFFIX,
+		Body:   b.String(),
+	}
+	// no backups since this file contains secrets
+	return updateMarkedFile(path, diff, 0, func(contents string) string {
+		return replaceMarkedBlock(contents, block)
+	})
+}
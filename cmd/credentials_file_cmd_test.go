@@ -0,0 +1,120 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const TEST_CREDENTIALS_FILE = `[default]
+aws_access_key_id = AKIAUSER
+
+# BEGIN_AWS_SSO_CLI
+
+[one:Admin]
+# Expires: 2030-01-02T03:04:05Z
+aws_access_key_id = ASIAONE
+aws_secret_access_key = secret1
+
+[two:ReadOnly]
+# Expires: not-a-time
+aws_access_key_id = ASIATWO
+
+# END_AWS_SSO_CLI
+
+[other]
+aws_access_key_id = AKIAOTHER
+`
+
+func TestReadCredentialsSections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+
+	// missing file is empty
+	sections, err := readCredentialsSections(path)
+	assert.NoError(t, err)
+	assert.Empty(t, sections)
+
+	assert.NoError(t, os.WriteFile(path, []byte(TEST_CREDENTIALS_FILE), 0600))
+	sections, err = readCredentialsSections(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]credentialsSection{
+		"one:Admin": {
+			Name:    "one:Admin",
+			Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC).Unix(),
+			Lines: []string{
+				"aws_access_key_id = ASIAONE",
+				"aws_secret_access_key = secret1",
+			},
+		},
+		"two:ReadOnly": {
+			Name:  "two:ReadOnly",
+			Lines: []string{"aws_access_key_id = ASIATWO"},
+		},
+	}, sections)
+
+	_, err = readCredentialsSections(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestWriteCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte(TEST_CREDENTIALS_FILE), 0600))
+
+	// expired [two:ReadOnly] is removed and [three:Admin] is added
+	err := writeCredentialsFile(path, map[string]credentialsSection{
+		"three:Admin": {
+			Name:    "three:Admin",
+			Expires: time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+			Lines:   []string{"aws_access_key_id = ASIATHREE"},
+		},
+	}, false)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `[default]
+aws_access_key_id = AKIAUSER
+
+# BEGIN_AWS_SSO_CLI
+
+[one:Admin]
+# Expires: 2030-01-02T03:04:05Z
+aws_access_key_id = ASIAONE
+aws_secret_access_key = secret1
+
+[three:Admin]
+# Expires: 2031-01-01T00:00:00Z
+aws_access_key_id = ASIATHREE
+
+# END_AWS_SSO_CLI
+
+[other]
+aws_access_key_id = AKIAOTHER
+`, string(data))
+
+	// and can be read back
+	sections, err := readCredentialsSections(path)
+	assert.NoError(t, err)
+	assert.Len(t, sections, 2)
+}
@@ -0,0 +1,147 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/synfinatic/aws-sso-cli/server"
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+type EcsServerCmd struct {
+	Bind      string `kong:"help='Local address to listen on',default='localhost:4144'"`
+	Socket    string `kong:"help='Listen on this Unix socket instead of --bind'"`
+	AuthToken string `kong:"help='Authorization token required from clients (default: random)',env='AWS_SSO_ECS_TOKEN'"`
+
+	// Default role served via /
+	Arn       string `kong:"short='a',help='ARN of the default role',predictor='arn'"`
+	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of the default role',predictor='accountId'"`
+	Role      string `kong:"short='R',help='Name of the default AWS Role',predictor='role'"`
+	Profile   string `kong:"short='p',help='Name of the default AWS Profile',predictor='profile'"`
+}
+
+// Run executes the `ecs-server` command
+func (cc *EcsServerCmd) Run(ctx *RunContext) error {
+	args := ctx.Cli.EcsServer
+
+	doAuth(ctx)
+	defaultArn, err := roleArnFromArgs(ctx, args.Arn, args.AccountId, args.Role, args.Profile)
+	if err != nil {
+		return err
+	}
+
+	token := args.AuthToken
+	if token == "" {
+		if token, err = server.NewAuthToken(); err != nil {
+			return err
+		}
+	}
+
+	l, err := server.ListenLocal(args.Bind, utils.GetHomePath(args.Socket))
+	if err != nil {
+		return err
+	}
+
+	e := server.NewECSServer(token, defaultArn, serverResolve(ctx), serverCredentials(ctx))
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		if err := e.Close(); err != nil {
+			log.WithError(err).Errorf("Unable to stop server")
+		}
+	}()
+
+	if args.Socket == "" {
+		fmt.Printf("%s=http://%s/; export %s;\n", server.ENV_CONTAINER_FULL_URI, l.Addr().String(), server.ENV_CONTAINER_FULL_URI)
+	}
+	if args.AuthToken == "" {
+		fmt.Printf("%s=%s; export %s;\n", server.ENV_CONTAINER_AUTH_TOKEN, token, server.ENV_CONTAINER_AUTH_TOKEN)
+	}
+	log.Infof("Serving ECS credentials on %s", l.Addr().String())
+	return e.Serve(l)
+}
+
+// roleArnFromArgs returns the role ARN selected via --arn, --account/--role or
+// --profile.  Returns an empty string if none of them were provided.
+func roleArnFromArgs(ctx *RunContext, arn string, accountId int64, role, profile string) (string, error) {
+	if profile != "" {
+		return serverResolve(ctx)(profile)
+	} else if arn != "" {
+		if _, _, err := utils.ParseRoleARN(arn); err != nil {
+			return "", err
+		}
+		return arn, nil
+	} else if accountId != 0 || role != "" {
+		if accountId == 0 || role == "" {
+			return "", fmt.Errorf("Please specify both --account and --role")
+		}
+		return utils.MakeRoleARN(accountId, role), nil
+	}
+	return "", nil
+}
+
+// serverResolve returns a ResolveFunc which looks up profiles in our cache
+func serverResolve(ctx *RunContext) server.ResolveFunc {
+	return func(profile string) (string, error) {
+		cache := ctx.Settings.Cache.GetSSO()
+		rFlat, err := cache.Roles.GetRoleByProfile(profile, ctx.Settings)
+		if err != nil {
+			return "", err
+		}
+		return rFlat.Arn, nil
+	}
+}
+
+// serverCredentials returns a CredentialsFunc which is safe to use from
+// multiple goroutines.  Credentials which are about to expire are refreshed
+// via AWS SSO so clients always get a full server.ROTATE_WINDOW of use.
+// We authenticate with AWS SSO before serving so that requests never
+// prompt the user.
+func serverCredentials(ctx *RunContext) server.CredentialsFunc {
+	var lock sync.Mutex
+	awssso := doAuth(ctx)
+	refresh := ctx.Cli.STSRefresh
+
+	return func(arn string) (storage.RoleCredentials, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		accountId, role, err := utils.ParseRoleARN(arn)
+		if err != nil {
+			return storage.RoleCredentials{}, err
+		}
+
+		creds, err := fetchRoleCredentials(ctx, awssso, accountId, role, refresh)
+		if err == nil && !refresh && server.NeedsRotation(*creds) {
+			creds, err = fetchRoleCredentials(ctx, awssso, accountId, role, true)
+		}
+		if err != nil {
+			return storage.RoleCredentials{}, err
+		}
+		return *creds, nil
+	}
+}
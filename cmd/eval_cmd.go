@@ -21,33 +21,33 @@ package main
 import (
 	"fmt"
 	"os"
-	"runtime"
-	"strings"
 
 	// log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/aws-sso-cli/sso"
 	"github.com/synfinatic/aws-sso-cli/utils"
 )
 
 type EvalCmd struct {
 	// AWS Params
-	Arn       string `kong:"short='a',help='ARN of role to assume',predictor='arn'"`
-	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of role to assume',predictor='accountId'"`
-	Role      string `kong:"short='R',help='Name of AWS Role to assume',predictor='role'"`
-	Profile   string `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile'"`
-
-	Clear    bool   `kong:"short='c',help='Generate \"unset XXXX\" commands to clear environment'"`
-	NoRegion bool   `kong:"short='n',help='Do not set/clear AWS_DEFAULT_REGION from config.yaml'"`
-	Refresh  bool   `kong:"short='r',help='Refresh current IAM credentials'"`
-	EnvArn   string `kong:"hidden,env='AWS_SSO_ROLE_ARN'"` // used for refresh
+	Arn       string            `kong:"short='a',help='ARN of role to assume',predictor='arn'"`
+	AccountId int64             `kong:"name='account',short='A',help='AWS AccountID of role to assume',predictor='accountId'"`
+	Role      string            `kong:"short='R',help='Name of AWS Role to assume',predictor='role'"`
+	Profile   string            `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile'"`
+	Tag       map[string]string `kong:"help='Select the role with the tag Key=Value (repeatable)',predictor='tag'"`
+	First     bool              `kong:"help='Use the first role if --tag matches multiple roles'"`
+
+	Clear      bool   `kong:"short='c',help='Generate \"unset XXXX\" commands to clear environment'"`
+	NoRegion   bool   `kong:"short='n',help='Do not set/clear AWS_DEFAULT_REGION from config.yaml'"`
+	Refresh    bool   `kong:"short='r',help='Refresh current IAM credentials'"`
+	Prompt     bool   `kong:"short='P',help='Interactively select the role'"`
+	SelectMode string `kong:"help='Interactive role selection mode [tags|fuzzy] (default: tags)'"`
+	Format     string `kong:"short='f',enum='bash,auto,fish,powershell,cmd,nushell,dotenv,json',default='bash',help='Output format [bash|auto|fish|powershell|cmd|nushell|dotenv|json]'"`
+	EnvArn     string `kong:"hidden,env='AWS_SSO_ROLE_ARN'"` // used for refresh
 }
 
 func (cc *EvalCmd) Run(ctx *RunContext) error {
 	var err error
 
-	if runtime.GOOS == "windows" && !strings.HasSuffix(os.Getenv("SHELL"), "/bash") {
-		return fmt.Errorf("eval is not supported on Windows unless running under bash")
-	}
-
 	var role string
 	var accountid int64
 
@@ -55,9 +55,13 @@ func (cc *EvalCmd) Run(ctx *RunContext) error {
 		return unsetEnvVars(ctx)
 	}
 
+	if err = checkFirstFlag(ctx.Cli.Eval.First, ctx.Cli.Eval.Tag); err != nil {
+		return err
+	}
+
 	// refreshing?
 	if ctx.Cli.Eval.Refresh {
-		if ctx.Cli.Eval.EnvArn != "" {
+		if ctx.Cli.Eval.EnvArn == "" {
 			return fmt.Errorf("Unable to determine current IAM role")
 		}
 		accountid, role, err = utils.ParseRoleARN(ctx.Cli.Eval.EnvArn)
@@ -82,28 +86,66 @@ func (cc *EvalCmd) Run(ctx *RunContext) error {
 		// if CLI args are speecified, use that
 		role = ctx.Cli.Eval.Role
 		accountid = ctx.Cli.Eval.AccountId
+	} else if len(ctx.Cli.Eval.Tag) > 0 {
+		arn, err := selectRoleByTags(ctx, ctx.Cli.Eval.Tag, ctx.Cli.Eval.First)
+		if err != nil {
+			return err
+		}
+		accountid, role, err = utils.ParseRoleARN(arn)
+		if err != nil {
+			return err
+		}
+	} else if ctx.Cli.Eval.Prompt || ctx.Cli.Eval.SelectMode != "" {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("Interactive role selection requires a terminal")
+		}
+		// prompt on stderr since stdout is eval'd by the shell
+		sso, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
+		if err != nil {
+			return err
+		}
+		sso.Refresh(ctx.Settings)
+		return selectRolePrompt(ctx, sso, ctx.Cli.Eval.SelectMode, evalCmd, true)
 	} else {
-		return fmt.Errorf("Please specify --refresh, --clear, --arn, or --account and --role")
+		return fmt.Errorf("Please specify --refresh, --clear, --arn, --tag, --prompt or --account and --role")
 	}
-	region := ctx.Settings.GetDefaultRegion(accountid, role, ctx.Cli.Eval.NoRegion)
 
 	awssso := doAuth(ctx)
+	return evalCmd(ctx, awssso, accountid, role)
+}
 
-	for k, v := range execShellEnvs(ctx, awssso, accountid, role, region) {
-		if len(v) == 0 {
-			fmt.Printf("unset %s\n", k)
-		} else {
-			fmt.Printf("export %s=\"%s\"\n", k, v)
-		}
+// evalCmd prints the commands to set the environment variables for the role
+func evalCmd(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string) error {
+	region := ctx.Settings.GetDefaultRegion(accountid, role, ctx.Cli.Eval.NoRegion)
+
+	out, err := utils.ShellExport(evalFormat(ctx), execShellEnvs(ctx, awssso, accountid, role, region))
+	if err != nil {
+		return err
 	}
+	fmt.Print(out)
 	return nil
 }
 
+// isTerminal returns if the file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// evalFormat returns the selected --format, detecting the shell if necessary
+func evalFormat(ctx *RunContext) string {
+	if ctx.Cli.Eval.Format == utils.SHELL_AUTO {
+		return utils.DetectShell()
+	}
+	return ctx.Cli.Eval.Format
+}
+
 func unsetEnvVars(ctx *RunContext) error {
 	envs := []string{
 		"AWS_ACCESS_KEY_ID",
 		"AWS_SECRET_ACCESS_KEY",
 		"AWS_SESSION_TOKEN",
+		"AWS_CREDENTIAL_EXPIRATION",
 		"AWS_SSO_ACCOUNT_ID",
 		"AWS_SSO_ROLE_NAME",
 		"AWS_SSO_ROLE_ARN",
@@ -127,8 +169,10 @@ func unsetEnvVars(ctx *RunContext) error {
 		envs = append(envs, env)
 	}
 
-	for _, e := range envs {
-		fmt.Printf("unset %s\n", e)
+	out, err := utils.ShellUnset(evalFormat(ctx), envs)
+	if err != nil {
+		return err
 	}
+	fmt.Print(out)
 	return nil
 }
@@ -24,21 +24,32 @@ import (
 	"os/exec"
 	"runtime"
 
-	"github.com/c-bata/go-prompt"
+	"github.com/synfinatic/aws-sso-cli/server"
 	"github.com/synfinatic/aws-sso-cli/sso"
 	"github.com/synfinatic/aws-sso-cli/utils"
 )
 
 type ExecCmd struct {
 	// AWS Params
-	Arn       string `kong:"short='a',help='ARN of role to assume',env='AWS_SSO_ROLE_ARN',predictor='arn'"`
-	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of role to assume',env='AWS_SSO_ACCOUNT_ID',predictor='accountId'"`
-	Role      string `kong:"short='R',help='Name of AWS Role to assume',env='AWS_SSO_ROLE_NAME',predictor='role'"`
-	Profile   string `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile'"`
-	NoRegion  bool   `kong:"short='n',help='Do not set AWS_DEFAULT_REGION from config.yaml'"`
+	Arn        string `kong:"short='a',help='ARN of role to assume',env='AWS_SSO_ROLE_ARN',predictor='arn'"`
+	AccountId  int64  `kong:"name='account',short='A',help='AWS AccountID of role to assume',env='AWS_SSO_ACCOUNT_ID',predictor='accountId'"`
+	Role       string `kong:"short='R',help='Name of AWS Role to assume',env='AWS_SSO_ROLE_NAME',predictor='role'"`
+	Profile    string `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile'"`
+	NoRegion   bool   `kong:"short='n',help='Do not set AWS_DEFAULT_REGION from config.yaml'"`
+	Ecs        bool   `kong:"help='Provide auto-refreshing credentials via a local ECS credentials endpoint',xor='refresh'"`
+	CredsFile  bool   `kong:"name='credentials-file',help='Provide auto-refreshing credentials via a private AWS_SHARED_CREDENTIALS_FILE',xor='refresh'"`
+	SelectMode string `kong:"help='Interactive role selection mode [tags|fuzzy] (default: tags)'"`
+
+	Tag   map[string]string `kong:"help='Select the role with the tag Key=Value or with --parallel, every matching role (repeatable)',predictor='tag'"`
+	First bool              `kong:"help='Use the first role if --tag matches multiple roles (not with --parallel)'"`
+
+	// Fan-out Params
+	Region    []string `kong:"help='Comma separated list of regions to run in (requires --parallel)',predictor='region'"`
+	Parallel  int      `kong:"help='Run for all roles matching --tag with up to N runs at once'"`
+	OutputDir string   `kong:"help='Save the output of each run to a file in this directory instead of stdout/stderr',type='path'"`
 
 	// Exec Params
-	Cmd  string   `kong:"arg,optional,name='command',help='Command to execute',env='SHELL'"`
+	Cmd  string   `kong:"arg,optional,name='command',help='Command to execute (default: $SHELL)'"`
 	Args []string `kong:"arg,optional,passthrough,name='args',help='Associated arguments for the command'"`
 }
 
@@ -48,12 +59,27 @@ func (cc *ExecCmd) Run(ctx *RunContext) error {
 		log.WithError(err).Fatalf("Unable to continue")
 	}
 
-	if runtime.GOOS == "windows" && ctx.Cli.Exec.Cmd == "" {
-		// Windows doesn't set $SHELL, so default to CommandPrompt
-		ctx.Cli.Exec.Cmd = "cmd.exe"
+	if ctx.Cli.Exec.Parallel > 0 {
+		return execFanOut(ctx)
+	}
+
+	if ctx.Cli.Exec.Cmd == "" {
+		if runtime.GOOS == "windows" {
+			// Windows doesn't set $SHELL, so default to CommandPrompt
+			ctx.Cli.Exec.Cmd = "cmd.exe"
+		} else {
+			ctx.Cli.Exec.Cmd = os.Getenv("SHELL")
+		}
 	}
 
-	// Did user specify the ARN or account/role?
+	if len(ctx.Cli.Exec.Region) > 0 || ctx.Cli.Exec.OutputDir != "" {
+		return fmt.Errorf("--region and --output-dir require --parallel")
+	} else if err = checkFirstFlag(ctx.Cli.Exec.First, ctx.Cli.Exec.Tag); err != nil {
+		return err
+	}
+
+	// --tag is checked before --arn, --account & --role since those may come
+	// from the environment variables set by eval
 	if ctx.Cli.Exec.Profile != "" {
 		awssso := doAuth(ctx)
 		cache := ctx.Settings.Cache.GetSSO()
@@ -63,6 +89,18 @@ func (cc *ExecCmd) Run(ctx *RunContext) error {
 		}
 
 		return execCmd(ctx, awssso, rFlat.AccountId, rFlat.RoleName)
+	} else if len(ctx.Cli.Exec.Tag) > 0 {
+		arn, err := selectRoleByTags(ctx, ctx.Cli.Exec.Tag, ctx.Cli.Exec.First)
+		if err != nil {
+			return err
+		}
+		accountid, role, err := utils.ParseRoleARN(arn)
+		if err != nil {
+			return err
+		}
+		awssso := doAuth(ctx)
+
+		return execCmd(ctx, awssso, accountid, role)
 	} else if ctx.Cli.Exec.Arn != "" {
 		awssso := doAuth(ctx)
 
@@ -95,24 +133,12 @@ func (cc *ExecCmd) Run(ctx *RunContext) error {
 	}
 
 	sso.Refresh(ctx.Settings)
-	fmt.Printf("Please use `exit` or `Ctrl-D` to quit.\n")
-
-	c := NewTagsCompleter(ctx, sso, execCmd)
-	opts := ctx.Settings.DefaultOptions(c.ExitChecker)
-	opts = append(opts, ctx.Settings.GetColorOptions()...)
-
-	p := prompt.New(
-		c.Executor,
-		c.Complete,
-		opts...,
-	)
-	p.Run()
-	return nil
+	return selectRolePrompt(ctx, sso, ctx.Cli.Exec.SelectMode, execCmd, false)
 }
 
 // Executes Cmd+Args in the context of the AWS Role creds
 func execCmd(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string) error {
-	region := ctx.Settings.GetDefaultRegion(ctx.Cli.Exec.AccountId, ctx.Cli.Exec.Role, ctx.Cli.Exec.NoRegion)
+	region := ctx.Settings.GetDefaultRegion(accountid, role, ctx.Cli.Exec.NoRegion)
 
 	ctx.Settings.Cache.AddHistory(utils.MakeRoleARN(accountid, role))
 	if err := ctx.Settings.Cache.Save(false); err != nil {
@@ -128,12 +154,66 @@ func execCmd(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string)
 
 	// add the variables we need for AWS to the executor without polluting our
 	// own process
-	for k, v := range execShellEnvs(ctx, awssso, accountid, role, region) {
+	shellVars := execShellEnvs(ctx, awssso, accountid, role, region)
+	var refreshVars map[string]string
+	if ctx.Cli.Exec.Ecs {
+		e, ecsVars, err := startExecEcsServer(ctx, utils.MakeRoleARN(accountid, role))
+		if err != nil {
+			return err
+		}
+		defer e.Close()
+		refreshVars = ecsVars
+	} else if ctx.Cli.Exec.CredsFile {
+		c, err := startCredentialsFile(serverCredentials(ctx), utils.MakeRoleARN(accountid, role))
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		refreshVars = c.Env()
+	}
+
+	if refreshVars != nil {
+		// the child gets refreshed creds from us instead
+		for _, k := range staticCredentialVars {
+			delete(shellVars, k)
+		}
+		for k, v := range refreshVars {
+			shellVars[k] = v
+		}
+	}
+
+	for k, v := range shellVars {
 		log.Debugf("Setting %s = %s", k, v)
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
 	// just do it!
-	return cmd.Run()
+	return superviseCmd(cmd)
+}
+
+// startExecEcsServer starts an ECS credentials endpoint for the role on a random
+// local port and returns the env vars needed to use it
+func startExecEcsServer(ctx *RunContext, arn string) (*server.ECSServer, map[string]string, error) {
+	token, err := server.NewAuthToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := server.ListenLocal("127.0.0.1:0", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := server.NewECSServer(token, arn, serverResolve(ctx), serverCredentials(ctx))
+	go func() {
+		if err := e.Serve(l); err != nil {
+			log.WithError(err).Errorf("ECS credentials endpoint failed")
+		}
+	}()
+
+	return e, map[string]string{
+		server.ENV_CONTAINER_FULL_URI:   fmt.Sprintf("http://%s/", l.Addr().String()),
+		server.ENV_CONTAINER_AUTH_TOKEN: token,
+	}, nil
 }
 
 func execShellEnvs(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role, region string) map[string]string {
@@ -146,6 +226,7 @@ func execShellEnvs(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role, r
 		"AWS_ACCESS_KEY_ID":          creds.AccessKeyId,
 		"AWS_SECRET_ACCESS_KEY":      creds.SecretAccessKey,
 		"AWS_SESSION_TOKEN":          creds.SessionToken,
+		"AWS_CREDENTIAL_EXPIRATION":  creds.ExpireISO8601(),
 		"AWS_SSO_ACCOUNT_ID":         creds.AccountIdStr(),
 		"AWS_SSO_ROLE_NAME":          creds.RoleName,
 		"AWS_SSO_SESSION_EXPIRATION": creds.ExpireString(),
@@ -0,0 +1,298 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/sso"
+	"github.com/synfinatic/aws-sso-cli/utils"
+	"github.com/synfinatic/gotable"
+)
+
+// ExecRun is a single execution of the command for a role & region
+type ExecRun struct {
+	Profile  string `header:"Profile"`
+	Region   string `header:"Region"`
+	ExitCode int    `header:"ExitCode"`
+	Duration string `header:"Duration"`
+	Output   string `header:"Output"`
+	Error    string `header:"Error"`
+	arn      string
+	env      map[string]string
+}
+
+// GetHeader is required for GenerateTable()
+func (r ExecRun) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(r)
+	return gotable.GetHeaderTag(v, fieldName)
+}
+
+// name returns the profile/region label of this run
+func (r *ExecRun) name() string {
+	if r.Region == "" {
+		return r.Profile
+	}
+	return fmt.Sprintf("%s/%s", r.Profile, r.Region)
+}
+
+// execFanOut runs the command for every role matching the --tag flags in every
+// --region, with up to --parallel concurrent runs
+func execFanOut(ctx *RunContext) error {
+	args := ctx.Cli.Exec
+	if len(args.Tag) == 0 {
+		return fmt.Errorf("--parallel requires at least one --tag")
+	}
+	if args.Ecs {
+		return fmt.Errorf("--ecs is not supported with --parallel")
+	}
+	if args.CredsFile {
+		return fmt.Errorf("--credentials-file is not supported with --parallel")
+	}
+	if args.First {
+		return fmt.Errorf("--first is not supported with --parallel since every matching role is used")
+	}
+
+	if args.Cmd == "" {
+		return fmt.Errorf("--parallel requires a command to run")
+	}
+
+	awssso := doAuth(ctx)
+	roles := []*sso.AWSRoleFlat{}
+	for _, arn := range matchingRoleArns(ctx, args.Tag) {
+		rFlat, err := ctx.Settings.Cache.GetRole(arn)
+		if err != nil {
+			return err
+		}
+		roles = append(roles, rFlat)
+	}
+	if len(roles) == 0 {
+		return fmt.Errorf("No roles match the provided --tag")
+	}
+
+	// get all the creds up front since our SecureStore is not thread safe
+	runs := execFanOutRuns(ctx, roles)
+	credsErrs := map[string]error{}
+	for _, run := range runs {
+		accountId, role, _ := utils.ParseRoleARN(run.arn)
+		credsErr, ok := credsErrs[run.arn]
+		if !ok {
+			_, credsErr = getRoleCredentials(ctx, awssso, accountId, role)
+			credsErrs[run.arn] = credsErr
+		}
+
+		if credsErr != nil {
+			run.ExitCode = -1
+			run.Error = credsErr.Error()
+		} else {
+			run.env = execShellEnvs(ctx, awssso, accountId, role, run.Region)
+		}
+	}
+
+	if args.OutputDir != "" {
+		if err := os.MkdirAll(utils.GetHomePath(args.OutputDir), 0755); err != nil {
+			return err
+		}
+	}
+
+	var outLock sync.Mutex
+	execFanOutParallel(runs, args.Parallel, func(run *ExecRun) {
+		execFanOutRun(ctx, run, &outLock)
+	})
+
+	ts := []gotable.TableStruct{}
+	failed := 0
+	for _, run := range runs {
+		if run.ExitCode != 0 {
+			failed++
+		}
+		ts = append(ts, *run)
+	}
+
+	fields := []string{"Profile", "Region", "ExitCode", "Duration"}
+	if args.OutputDir != "" {
+		fields = append(fields, "Output")
+	}
+	if failed > 0 {
+		fields = append(fields, "Error")
+	}
+	fmt.Printf("\n")
+	if err := gotable.GenerateTable(ts, fields); err != nil {
+		log.WithError(err).Errorf("Unable to generate report")
+	}
+	fmt.Printf("\n")
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d runs failed", failed, len(runs))
+	}
+	return nil
+}
+
+// execFanOutRuns returns a run for every role in each --region, or the
+// DefaultRegion of the role if no --region is given
+func execFanOutRuns(ctx *RunContext, roles []*sso.AWSRoleFlat) []*ExecRun {
+	args := ctx.Cli.Exec
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Arn < roles[j].Arn })
+
+	runs := []*ExecRun{}
+	for _, rFlat := range roles {
+		profile, err := rFlat.ProfileName(ctx.Settings)
+		if err != nil {
+			profile = rFlat.Arn
+		}
+
+		regions := args.Region
+		if len(regions) == 0 {
+			regions = []string{ctx.Settings.GetDefaultRegion(rFlat.AccountId, rFlat.RoleName, args.NoRegion)}
+		}
+
+		for _, region := range regions {
+			runs = append(runs, &ExecRun{
+				Profile: profile,
+				Region:  region,
+				arn:     rFlat.Arn,
+			})
+		}
+	}
+	return runs
+}
+
+// execFanOutParallel calls fn for each run which has credentials with up to
+// parallel calls at once
+func execFanOutParallel(runs []*ExecRun, parallel int, fn func(*ExecRun)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for _, run := range runs {
+		if run.env == nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(run *ExecRun) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(run)
+		}(run)
+	}
+	wg.Wait()
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// execFanOutRun executes the command for a single run
+func execFanOutRun(ctx *RunContext, run *ExecRun, outLock *sync.Mutex) {
+	args := ctx.Cli.Exec
+
+	cmd := exec.Command(args.Cmd, args.Args...) // #nosec
+	cmd.Env = os.Environ()
+	for k, v := range run.env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	var stdout, stderr io.Writer
+	if args.OutputDir != "" {
+		run.Output = execRunLogFile(args.OutputDir, run)
+		f, err := os.Create(run.Output)
+		if err != nil {
+			run.ExitCode = -1
+			run.Error = err.Error()
+			return
+		}
+		defer f.Close()
+		stdout, stderr = f, f
+	} else {
+		prefix := fmt.Sprintf("[%s] ", run.name())
+		o := &prefixWriter{prefix: prefix, out: os.Stdout, lock: outLock}
+		e := &prefixWriter{prefix: prefix, out: os.Stderr, lock: outLock}
+		defer o.Flush()
+		defer e.Flush()
+		stdout, stderr = o, e
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	run.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		run.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		run.ExitCode = -1
+		run.Error = err.Error()
+	}
+}
+
+// execRunLogFile returns the path of the file in dir to save the output of the run
+func execRunLogFile(dir string, run *ExecRun) string {
+	name := unsafeFileChars.ReplaceAllString(run.name(), "_") + ".log"
+	return filepath.Join(utils.GetHomePath(dir), name)
+}
+
+// prefixWriter writes complete lines to out with the given prefix
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	lock   *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any partial line
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		_ = p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, err := fmt.Fprintf(p.out, "%s%s", p.prefix, line)
+	return err
+}
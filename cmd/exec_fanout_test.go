@@ -0,0 +1,160 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/sso"
+)
+
+func TestExecFanOutRuns(t *testing.T) {
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_SSO_DEFAULT_REGION", "")
+
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+	roles := []*sso.AWSRoleFlat{}
+	for _, arn := range []string{"arn:aws:iam::000000000002:role/Chained", "arn:aws:iam::000000000001:role/Admin"} {
+		rFlat, err := ctx.Settings.Cache.GetRole(arn)
+		assert.NoError(t, err)
+		roles = append(roles, rFlat)
+	}
+
+	// DefaultRegion of each role, sorted by ARN
+	runs := execFanOutRuns(ctx, roles)
+	assert.Equal(t, []*ExecRun{
+		{Profile: "one:Admin", Region: "us-east-1", arn: "arn:aws:iam::000000000001:role/Admin"},
+		{Profile: "two:Chained", Region: "eu-west-1", arn: "arn:aws:iam::000000000002:role/Chained"},
+	}, runs)
+
+	ctx.Cli.Exec.NoRegion = true
+	runs = execFanOutRuns(ctx, roles)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, "", runs[0].Region)
+	assert.Equal(t, "one:Admin", runs[0].name())
+
+	// every role in every region
+	ctx.Cli.Exec.Region = []string{"us-west-2", "ap-south-1"}
+	runs = execFanOutRuns(ctx, roles)
+	names := []string{}
+	for _, run := range runs {
+		names = append(names, run.name())
+	}
+	assert.Equal(t, []string{
+		"one:Admin/us-west-2",
+		"one:Admin/ap-south-1",
+		"two:Chained/us-west-2",
+		"two:Chained/ap-south-1",
+	}, names)
+}
+
+func TestExecFanOutParallel(t *testing.T) {
+	runs := []*ExecRun{}
+	for i := 0; i < 10; i++ {
+		runs = append(runs, &ExecRun{env: map[string]string{}})
+	}
+	// runs without credentials are skipped
+	runs = append(runs, &ExecRun{})
+
+	var running, max, count int32
+	execFanOutParallel(runs, 3, func(run *ExecRun) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&count, 1)
+		atomic.AddInt32(&running, -1)
+	})
+	assert.Equal(t, int32(10), count)
+	assert.LessOrEqual(t, max, int32(3))
+	assert.Greater(t, max, int32(1))
+
+	// one at a time
+	execFanOutParallel(runs, 1, func(run *ExecRun) {
+		assert.Equal(t, int32(1), atomic.AddInt32(&running, 1))
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+}
+
+func TestExecRunLogFile(t *testing.T) {
+	run := &ExecRun{Profile: "123456789012:Admin", Region: "us-east-1"}
+	assert.Equal(t, filepath.Join("/tmp/out", "123456789012_Admin_us-east-1.log"),
+		execRunLogFile("/tmp/out", run))
+
+	run = &ExecRun{Profile: "dev account/../Admin"}
+	assert.Equal(t, filepath.Join("/tmp/out", "dev_account_.._Admin.log"),
+		execRunLogFile("/tmp/out", run))
+}
+
+func TestPrefixWriter(t *testing.T) {
+	out := new(bytes.Buffer)
+	p := &prefixWriter{prefix: "[foo] ", out: out, lock: &sync.Mutex{}}
+
+	n, err := p.Write([]byte("hello "))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, "", out.String())
+
+	_, err = p.Write([]byte("world\nsecond\nthird"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[foo] hello world\n[foo] second\n", out.String())
+
+	p.Flush()
+	assert.Equal(t, "[foo] hello world\n[foo] second\n[foo] third\n", out.String())
+
+	// nothing left to flush
+	p.Flush()
+	assert.Equal(t, "[foo] hello world\n[foo] second\n[foo] third\n", out.String())
+}
+
+func TestExecFanOutFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(args *ExecCmd)
+		err   string
+	}{
+		{"no tag", func(args *ExecCmd) { args.Tag = map[string]string{} }, "requires at least one --tag"},
+		{"ecs", func(args *ExecCmd) { args.Ecs = true }, "--ecs is not supported with --parallel"},
+		{"credentials file", func(args *ExecCmd) { args.CredsFile = true }, "--credentials-file is not supported with --parallel"},
+		{"first", func(args *ExecCmd) { args.First = true }, "--first is not supported with --parallel"},
+		{"no command", func(args *ExecCmd) {}, "requires a command"},
+	}
+
+	for _, test := range tests {
+		ctx := &RunContext{Cli: &CLI{}}
+		ctx.Cli.Exec.Tag = map[string]string{"Env": "prod"}
+		ctx.Cli.Exec.Parallel = 2
+		test.setup(&ctx.Cli.Exec)
+		err := execFanOut(ctx)
+		if assert.Error(t, err, test.name) {
+			assert.Contains(t, err.Error(), test.err, test.name)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/server"
+	"github.com/synfinatic/aws-sso-cli/storage"
+)
+
+const (
+	ENV_SHARED_CREDENTIALS_FILE = "AWS_SHARED_CREDENTIALS_FILE"
+	CREDENTIALS_FILE_PROFILE    = "aws-sso"
+)
+
+// env vars which are replaced when the child gets refreshed creds from us
+var staticCredentialVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_SSO_SESSION_EXPIRATION",
+}
+
+// signals we pass on to the child
+var forwardSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// ExitCodeError is returned when the child did not exit cleanly so we can exit
+// with the same status
+type ExitCodeError struct {
+	Code int
+}
+
+func (e ExitCodeError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// superviseCmd runs the command, forwarding our signals to it and returns an
+// ExitCodeError if it exits with a non-zero status
+func superviseCmd(cmd *exec.Cmd) error {
+	// The terminal already sends ^C and ^\ to the child via the process group
+	// so only forward them when we are not interactive
+	interactive := false
+	if fi, err := os.Stdin.Stat(); err == nil {
+		interactive = fi.Mode()&os.ModeCharDevice != 0
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardSignals...)
+	defer signal.Stop(sigChan)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigChan:
+				if interactive && (sig == os.Interrupt || sig == syscall.SIGQUIT) {
+					continue
+				}
+				log.Debugf("Forwarding %s to %d", sig.String(), cmd.Process.Pid)
+				if err := cmd.Process.Signal(sig); err != nil {
+					log.WithError(err).Warnf("Unable to forward %s", sig.String())
+				}
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			// same as the shell
+			code = 128 + int(ws.Signal())
+		}
+		return ExitCodeError{Code: code}
+	}
+	return err
+}
+
+// CredentialsFile is a private AWS shared credentials file which is rewritten
+// with fresh credentials before the current ones expire
+type CredentialsFile struct {
+	Path  string
+	dir   string
+	arn   string
+	creds storage.RoleCredentials
+	get   server.CredentialsFunc
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// startCredentialsFile creates a new CredentialsFile for the role and starts
+// rotating it
+func startCredentialsFile(get server.CredentialsFunc, arn string) (*CredentialsFile, error) {
+	dir, err := os.MkdirTemp("", "aws-sso-exec-")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CredentialsFile{
+		Path: filepath.Join(dir, "credentials"),
+		dir:  dir,
+		arn:  arn,
+		get:  get,
+		stop: make(chan struct{}),
+	}
+	if err = c.rotate(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if !server.NeedsRotation(c.creds) {
+					continue
+				}
+				if err := c.rotate(); err != nil {
+					log.WithError(err).Errorf("Unable to rotate credentials for %s", arn)
+				}
+			}
+		}
+	}()
+	return c, nil
+}
+
+// rotate gets new credentials and atomically replaces the file
+func (c *CredentialsFile) rotate() error {
+	creds, err := c.get(c.arn)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.Path + ".tmp"
+	data := fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\naws_session_token = %s\n",
+		CREDENTIALS_FILE_PROFILE, creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken)
+	if err = os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, c.Path); err != nil {
+		return err
+	}
+	c.creds = creds
+	log.Debugf("Wrote credentials for %s to %s", c.arn, c.Path)
+	return nil
+}
+
+// Env returns the env vars the child needs to use our credentials file
+func (c *CredentialsFile) Env() map[string]string {
+	return map[string]string{
+		ENV_SHARED_CREDENTIALS_FILE: c.Path,
+		"AWS_PROFILE":               CREDENTIALS_FILE_PROFILE,
+	}
+}
+
+// Close stops rotating and deletes the credentials file
+func (c *CredentialsFile) Close() error {
+	close(c.stop)
+	c.wg.Wait()
+	return os.RemoveAll(c.dir)
+}
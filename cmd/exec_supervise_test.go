@@ -0,0 +1,186 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/storage"
+)
+
+// helperCmd returns a command which runs TestHelperProcess with the args
+func helperCmd(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...) // #nosec
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+	return cmd
+}
+
+// TestHelperProcess is not a real test, it is the child process run by helperCmd
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	args = args[1:]
+
+	switch args[0] {
+	case "exit":
+		code, _ := strconv.Atoi(args[1])
+		os.Exit(code)
+
+	case "sleep":
+		// killed by the forwarded signal
+		fmt.Println("ready")
+		time.Sleep(10 * time.Second)
+		os.Exit(0)
+
+	case "trap":
+		// exits 7 when it gets SIGHUP
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGHUP)
+		fmt.Println("ready")
+		select {
+		case <-sigChan:
+			os.Exit(7)
+		case <-time.After(10 * time.Second):
+			os.Exit(0)
+		}
+
+	case "cat":
+		data, err := os.ReadFile(os.Getenv(args[1]))
+		if err != nil {
+			os.Exit(2)
+		}
+		fmt.Print(string(data))
+		os.Exit(0)
+	}
+	os.Exit(255)
+}
+
+func TestSuperviseCmdExitCode(t *testing.T) {
+	assert.NoError(t, superviseCmd(helperCmd("exit", "0")))
+
+	err := superviseCmd(helperCmd("exit", "3"))
+	var exitErr ExitCodeError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 3, exitErr.Code)
+	assert.Equal(t, "command exited with status 3", err.Error())
+
+	err = superviseCmd(exec.Command(filepath.Join(t.TempDir(), "missing")))
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &exitErr))
+}
+
+// superviseSignal runs the helper and sends ourselves sig once it is ready
+func superviseSignal(t *testing.T, mode string, sig syscall.Signal) error {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+
+	cmd := helperCmd(mode)
+	cmd.Stdout = w
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		if line == "ready\n" {
+			_ = syscall.Kill(os.Getpid(), sig)
+		}
+	}()
+	err = superviseCmd(cmd)
+	w.Close()
+	return err
+}
+
+func TestSuperviseCmdSignals(t *testing.T) {
+	// child is killed by the forwarded signal & we exit like the shell would
+	err := superviseSignal(t, "sleep", syscall.SIGTERM)
+	var exitErr ExitCodeError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 128+int(syscall.SIGTERM), exitErr.Code)
+
+	// child handles the forwarded signal itself
+	err = superviseSignal(t, "trap", syscall.SIGHUP)
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 7, exitErr.Code)
+}
+
+func TestCredentialsFile(t *testing.T) {
+	calls := 0
+	get := func(arn string) (storage.RoleCredentials, error) {
+		calls++
+		return storage.RoleCredentials{
+			AccessKeyId:     fmt.Sprintf("key%d", calls),
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+		}, nil
+	}
+
+	c, err := startCredentialsFile(get, "arn:aws:iam::123456789012:role/Test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, map[string]string{
+		ENV_SHARED_CREDENTIALS_FILE: c.Path,
+		"AWS_PROFILE":               CREDENTIALS_FILE_PROFILE,
+	}, c.Env())
+
+	info, err := os.Stat(c.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the child sees our credentials
+	cmd := helperCmd("cat", ENV_SHARED_CREDENTIALS_FILE)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", ENV_SHARED_CREDENTIALS_FILE, c.Path))
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "[aws-sso]\naws_access_key_id = key1\naws_secret_access_key = secret\naws_session_token = token\n",
+		string(out))
+
+	// rotating replaces the file
+	assert.NoError(t, c.rotate())
+	data, err := os.ReadFile(c.Path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "aws_access_key_id = key2\n")
+	assert.NoFileExists(t, c.Path+".tmp")
+
+	// Close removes everything
+	assert.NoError(t, c.Close())
+	assert.NoDirExists(t, filepath.Dir(c.Path))
+
+	_, err = startCredentialsFile(func(arn string) (storage.RoleCredentials, error) {
+		return storage.RoleCredentials{}, fmt.Errorf("no creds")
+	}, "arn:aws:iam::123456789012:role/Test")
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/synfinatic/aws-sso-cli/server"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+type ImdsServerCmd struct {
+	Bind   string `kong:"help='Local address to listen on',default='127.0.0.1:1338'"`
+	Region string `kong:"help='Override the region reported by the instance metadata'"`
+
+	// Role served via the instance metadata
+	Arn       string `kong:"short='a',help='ARN of the role',predictor='arn'"`
+	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of the role',predictor='accountId'"`
+	Role      string `kong:"short='R',help='Name of the AWS Role',predictor='role'"`
+	Profile   string `kong:"short='p',help='Name of the AWS Profile',predictor='profile'"`
+}
+
+// Run executes the `imds-server` command
+func (cc *ImdsServerCmd) Run(ctx *RunContext) error {
+	args := ctx.Cli.ImdsServer
+
+	doAuth(ctx)
+	arn, err := roleArnFromArgs(ctx, args.Arn, args.AccountId, args.Role, args.Profile)
+	if err != nil {
+		return err
+	} else if arn == "" {
+		return fmt.Errorf("Please specify --arn, --account/--role or --profile")
+	}
+
+	accountId, role, _ := utils.ParseRoleARN(arn)
+	region := args.Region
+	if region == "" {
+		region = ctx.Settings.GetDefaultRegion(accountId, role, false)
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return fmt.Errorf("No DefaultRegion configured for %s, please specify --region", arn)
+	}
+
+	i, err := server.NewIMDSServer(arn, region, serverCredentials(ctx))
+	if err != nil {
+		return err
+	}
+
+	l, err := server.ListenLocal(args.Bind, "")
+	if err != nil {
+		return err
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		if err := i.Close(); err != nil {
+			log.WithError(err).Errorf("Unable to stop server")
+		}
+	}()
+
+	fmt.Printf("%s=http://%s/; export %s;\n", server.ENV_EC2_METADATA_ENDPOINT, l.Addr().String(), server.ENV_EC2_METADATA_ENDPOINT)
+	log.Infof("Serving instance metadata for %s on %s", arn, l.Addr().String())
+	return i.Serve(l)
+}
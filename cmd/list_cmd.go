@@ -19,10 +19,16 @@ package main
  */
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"sort"
+	"strings"
 
+	goyaml "github.com/goccy/go-yaml"
 	"github.com/synfinatic/aws-sso-cli/sso"
 	"github.com/synfinatic/aws-sso-cli/storage"
 	"github.com/synfinatic/aws-sso-cli/utils"
@@ -44,11 +50,26 @@ var allListFields = map[string]string{
 	"SSO":           "AWS SSO Instance Name",
 	"Via":           "Role Chain Via",
 	"Profile":       "AWS_SSO_PROFILE / AWS_PROFILE",
+	"Tags":          "Role Tags (not supported with --output table)",
 }
 
+const (
+	LIST_OUTPUT_TABLE = "table"
+	LIST_OUTPUT_JSON  = "json"
+	LIST_OUTPUT_YAML  = "yaml"
+	LIST_OUTPUT_CSV   = "csv"
+	LIST_OUTPUT_TSV   = "tsv"
+)
+
 type ListCmd struct {
 	ListFields bool     `kong:"optional,short='f',help='List available fields',xor='fields'"`
 	Fields     []string `kong:"optional,arg,help='Fields to display',env='AWS_SSO_FIELDS',predictor='fieldList',xor='fields'"`
+	Output     string   `kong:"short='o',enum='table,json,yaml,csv,tsv',default='table',help='Output format [table|json|yaml|csv|tsv]'"`
+	Filter     []string `kong:"help='Only list roles matching the tag query where fields may be used like tags (repeatable)',sep='none'"`
+	Sort       string   `kong:"help='Field or tag to sort by',predictor='fieldList'"`
+	Reverse    bool     `kong:"help='Reverse the sort order'"`
+	Expired    bool     `kong:"help='Only list roles with expired or no STS credentials',xor='expired'"`
+	Active     bool     `kong:"help='Only list roles with active STS credentials',xor='expired'"`
 }
 
 // what should this actually do?
@@ -77,9 +98,7 @@ func (cc *ListCmd) Run(ctx *RunContext) error {
 		fields = ctx.Cli.List.Fields
 	}
 
-	printRoles(ctx, fields)
-
-	return nil
+	return printRoles(ctx, fields, ctx.Cli.List)
 }
 
 // DefaultCmd has no args, and just prints the default fields and exists because
@@ -100,14 +119,92 @@ func (cc *DefaultCmd) Run(ctx *RunContext) error {
 		}
 	}
 
-	printRoles(ctx, ctx.Settings.ListFields)
+	return printRoles(ctx, ctx.Settings.ListFields, ListCmd{Output: LIST_OUTPUT_TABLE})
+}
+
+// Print all our roles which match the filters in the requested format
+func printRoles(ctx *RunContext, fields []string, args ListCmd) error {
+	roleType := reflect.TypeOf(sso.AWSRoleFlat{})
+	for _, field := range fields {
+		if _, ok := roleType.FieldByName(field); !ok {
+			return fmt.Errorf("Invalid field: %s", field)
+		}
+		if field == "Tags" && args.Output == LIST_OUTPUT_TABLE {
+			return fmt.Errorf("Tags is not supported with --output table")
+		}
+	}
+
+	queries := []*sso.TagQuery{}
+	for _, filter := range args.Filter {
+		q, err := sso.ParseTagQuery(filter)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, q)
+	}
+
+	tr := []gotable.TableStruct{}
+	for _, roleFlat := range listRoles(ctx) {
+		if (args.Expired && !roleFlat.IsExpired()) || (args.Active && roleFlat.IsExpired()) {
+			continue
+		}
+		values := roleValues(roleFlat)
+		match := true
+		for _, q := range queries {
+			if !q.Match(values) {
+				match = false
+				break
+			}
+		}
+		if match {
+			tr = append(tr, *roleFlat)
+		}
+	}
+
+	if args.Sort != "" {
+		sortRoles(tr, args.Sort, args.Reverse)
+	} else if args.Reverse {
+		for i, j := 0, len(tr)-1; i < j; i, j = i+1, j-1 {
+			tr[i], tr[j] = tr[j], tr[i]
+		}
+	}
+
+	if args.Output != LIST_OUTPUT_TABLE {
+		return printRolesData(os.Stdout, tr, fields, args.Output)
+	}
+
+	// Determine when our AWS SSO session expires
+	// list doesn't call doAuth() so we have to initialize our global *AwsSSO manually
+	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
+	if err != nil {
+		return err
+	}
+	AwsSSO = sso.NewAWSSSO(s, &ctx.Store)
+
+	expires := ""
+	ctr := storage.CreateTokenResponse{}
+	if err := ctx.Store.GetCreateTokenResponse(AwsSSO.StoreKey(), &ctr); err != nil {
+		log.Debugf("Unable to get SSO session expire time: %s", err.Error())
+	} else {
+		if exp, err := utils.TimeRemain(ctr.ExpiresAt, true); err != nil {
+			log.Errorf("Unable to determine time remain for %d: %s", ctr.ExpiresAt, err)
+		} else {
+			expires = fmt.Sprintf(" [Expires in: %s]", exp)
+		}
+	}
+	fmt.Printf("List of AWS roles for SSO Instance: %s%s\n\n", ctx.Settings.DefaultSSO, expires)
+
+	if err := gotable.GenerateTable(tr, fields); err != nil {
+		return fmt.Errorf("Unable to generate report: %s", err.Error())
+	}
+	fmt.Printf("\n")
 	return nil
 }
 
-// Print all our roles
-func printRoles(ctx *RunContext, fields []string) {
+// listRoles returns all of our roles in AccountId & RoleName order
+func listRoles(ctx *RunContext) []*sso.AWSRoleFlat {
 	roles := ctx.Settings.Cache.GetSSO().Roles
-	tr := []gotable.TableStruct{}
+	ret := []*sso.AWSRoleFlat{}
 	idx := 0
 
 	// print in AccountId order
@@ -139,35 +236,144 @@ func printRoles(ctx *RunContext, fields []string) {
 			}
 			roleFlat.Id = idx
 			idx += 1
-			tr = append(tr, *roleFlat)
+			ret = append(ret, roleFlat)
 		}
 	}
+	return ret
+}
 
-	// Determine when our AWS SSO session expires
-	// list doesn't call doAuth() so we have to initialize our global *AwsSSO manually
-	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
+// roleValues returns the tags & non-empty fields of the role as strings for
+// filtering.  Fields take precedence over tags of the same name.
+func roleValues(r *sso.AWSRoleFlat) map[string]string {
+	values := map[string]string{}
+	for k, v := range r.Tags {
+		values[k] = v
+	}
+
+	row, _, err := gotable.TableRow(*r)
 	if err != nil {
-		log.Fatalf("%s", err.Error())
+		log.WithError(err).Errorf("Unable to get values for %s", r.Arn)
+		return values
 	}
-	AwsSSO = sso.NewAWSSSO(s, &ctx.Store)
+	for field, v := range row {
+		if v != gotable.NOT_SUPPORTED && v != "" {
+			values[field] = v
+		}
+	}
+	values["AccountId"], _ = utils.AccountIdToString(r.AccountId)
+	return values
+}
 
-	expires := ""
-	ctr := storage.CreateTokenResponse{}
-	if err := ctx.Store.GetCreateTokenResponse(AwsSSO.StoreKey(), &ctr); err != nil {
-		log.Debugf("Unable to get SSO session expire time: %s", err.Error())
-	} else {
-		if exp, err := utils.TimeRemain(ctr.ExpiresAt, true); err != nil {
-			log.Errorf("Unable to determine time remain for %d: %s", ctr.ExpiresAt, err)
+// sortRoles sorts the roles by the field or tag.  Numeric fields are sorted
+// numerically.
+func sortRoles(roles []gotable.TableStruct, field string, reverse bool) {
+	less := func(a, b sso.AWSRoleFlat) bool {
+		va := reflect.ValueOf(a).FieldByName(field)
+		vb := reflect.ValueOf(b).FieldByName(field)
+		if va.IsValid() && va.Kind() != reflect.Map {
+			switch va.Kind() {
+			case reflect.Int, reflect.Int64:
+				return va.Int() < vb.Int()
+			default:
+				return va.String() < vb.String()
+			}
+		}
+		return a.Tags[field] < b.Tags[field]
+	}
+
+	sort.SliceStable(roles, func(i, j int) bool {
+		a, b := roles[i].(sso.AWSRoleFlat), roles[j].(sso.AWSRoleFlat)
+		if reverse {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+// printRolesData prints the fields of the roles along with their tags in the
+// given machine readable format
+func printRolesData(w io.Writer, roles []gotable.TableStruct, fields []string, output string) error {
+	hasTags := false
+	for _, field := range fields {
+		if field == "Tags" {
+			hasTags = true
+		}
+	}
+	if !hasTags {
+		fields = append(append([]string{}, fields...), "Tags")
+	}
+
+	switch output {
+	case LIST_OUTPUT_JSON, LIST_OUTPUT_YAML:
+		data := []map[string]interface{}{}
+		for _, r := range roles {
+			role := r.(sso.AWSRoleFlat)
+			v := reflect.ValueOf(role)
+			item := map[string]interface{}{}
+			for _, field := range fields {
+				item[field] = v.FieldByName(field).Interface()
+			}
+			if _, ok := item["AccountId"]; ok {
+				item["AccountId"], _ = utils.AccountIdToString(role.AccountId)
+			}
+			data = append(data, item)
+		}
+
+		var out []byte
+		var err error
+		if output == LIST_OUTPUT_JSON {
+			out, err = json.MarshalIndent(data, "", "  ")
+			out = append(out, '\n')
 		} else {
-			expires = fmt.Sprintf(" [Expires in: %s]", exp)
+			out, err = goyaml.Marshal(data)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s", out)
+
+	case LIST_OUTPUT_CSV, LIST_OUTPUT_TSV:
+		cw := csv.NewWriter(w)
+		if output == LIST_OUTPUT_TSV {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(fields); err != nil {
+			return err
 		}
+		for _, r := range roles {
+			role := r.(sso.AWSRoleFlat)
+			values := roleValues(&role)
+			row := []string{}
+			for _, field := range fields {
+				if field == "Tags" {
+					row = append(row, joinTags(role.Tags))
+				} else {
+					row = append(row, values[field])
+				}
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
 	}
-	fmt.Printf("List of AWS roles for SSO Instance: %s%s\n\n", ctx.Settings.DefaultSSO, expires)
+	return nil
+}
 
-	if err := gotable.GenerateTable(tr, fields); err != nil {
-		log.WithError(err).Fatalf("Unable to generate report")
+// joinTags returns the tags as a Key=Value list separated by semicolons
+func joinTags(tags map[string]string) string {
+	keys := []string{}
+	for k := range tags {
+		keys = append(keys, k)
 	}
-	fmt.Printf("\n")
+	sort.Strings(keys)
+
+	ret := []string{}
+	for _, k := range keys {
+		ret = append(ret, fmt.Sprintf("%s=%s", k, tags[k]))
+	}
+	return strings.Join(ret, ";")
 }
 
 // Code to --list-fields
@@ -0,0 +1,188 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/sso"
+	"github.com/synfinatic/gotable"
+)
+
+func TestListRoles(t *testing.T) {
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+	roles := listRoles(ctx)
+
+	// AccountId & RoleName order
+	arns := []string{}
+	for i, r := range roles {
+		assert.Equal(t, i, r.Id)
+		arns = append(arns, r.Arn)
+	}
+	assert.Equal(t, []string{
+		"arn:aws:iam::000000000001:role/Admin",
+		"arn:aws:iam::000000000001:role/ReadOnly",
+		"arn:aws:iam::000000000002:role/Chained",
+		"arn:aws:iam::000000000003:role/Orphan",
+		"arn:aws:iam::000000000010:role/ReadOnly",
+	}, arns)
+	assert.Equal(t, "one:Admin", roles[0].Profile)
+	assert.Equal(t, map[string]string{
+		"AccountAlias": "one",
+		"AccountID":    "000000000001",
+		"Email":        "",
+		"Env":          "dev",
+		"Team":         "a b",
+	}, roles[0].Tags)
+}
+
+func TestSortRoles(t *testing.T) {
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+
+	tests := []struct {
+		field    string
+		reverse  bool
+		expected []string
+	}{
+		// numeric, not 1, 10, 2 and equal values keep their order
+		{"AccountId", false, []string{"one:ReadOnly", "one:Admin", "two:Chained", "three:Orphan", "ten:ReadOnly"}},
+		{"AccountId", true, []string{"ten:ReadOnly", "three:Orphan", "two:Chained", "one:ReadOnly", "one:Admin"}},
+		{"RoleName", false, []string{"one:Admin", "two:Chained", "three:Orphan", "ten:ReadOnly", "one:ReadOnly"}},
+		{"RoleName", true, []string{"ten:ReadOnly", "one:ReadOnly", "three:Orphan", "two:Chained", "one:Admin"}},
+		// tag, missing sorts first
+		{"Env", false, []string{"three:Orphan", "ten:ReadOnly", "one:ReadOnly", "one:Admin", "two:Chained"}},
+		{"Missing", false, []string{"ten:ReadOnly", "three:Orphan", "two:Chained", "one:ReadOnly", "one:Admin"}},
+	}
+
+	for _, test := range tests {
+		// start in the reverse order of listRoles()
+		roles := []gotable.TableStruct{}
+		for _, r := range listRoles(ctx) {
+			roles = append([]gotable.TableStruct{*r}, roles...)
+		}
+		sortRoles(roles, test.field, test.reverse)
+
+		profiles := []string{}
+		for _, r := range roles {
+			profiles = append(profiles, r.(sso.AWSRoleFlat).Profile)
+		}
+		assert.Equal(t, test.expected, profiles, "%s reverse=%v", test.field, test.reverse)
+	}
+}
+
+func TestPrintRolesData(t *testing.T) {
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+	all := listRoles(ctx)
+	admin, readOnly := *all[0], *all[4]
+	admin.Tags = map[string]string{"Env": "dev", "Team": "a b"}
+	readOnly.Tags = map[string]string{"Env": "dev"}
+	roles := []gotable.TableStruct{admin, readOnly}
+	fields := []string{"AccountId", "RoleName"}
+
+	tests := map[string]string{
+		LIST_OUTPUT_JSON: `[
+  {
+    "AccountId": "000000000001",
+    "RoleName": "Admin",
+    "Tags": {
+      "Env": "dev",
+      "Team": "a b"
+    }
+  },
+  {
+    "AccountId": "000000000010",
+    "RoleName": "ReadOnly",
+    "Tags": {
+      "Env": "dev"
+    }
+  }
+]
+`,
+		LIST_OUTPUT_YAML: `- AccountId: "000000000001"
+  RoleName: Admin
+  Tags:
+    Env: dev
+    Team: a b
+- AccountId: "000000000010"
+  RoleName: ReadOnly
+  Tags:
+    Env: dev
+`,
+		LIST_OUTPUT_CSV: `AccountId,RoleName,Tags
+000000000001,Admin,Env=dev;Team=a b
+000000000010,ReadOnly,Env=dev
+`,
+		LIST_OUTPUT_TSV: "AccountId\tRoleName\tTags\n" +
+			"000000000001\tAdmin\tEnv=dev;Team=a b\n" +
+			"000000000010\tReadOnly\tEnv=dev\n",
+	}
+
+	for output, expected := range tests {
+		out := new(bytes.Buffer)
+		assert.NoError(t, printRolesData(out, roles, fields, output), output)
+		assert.Equal(t, expected, out.String(), output)
+	}
+
+	// the fields of the caller are not modified
+	out := new(bytes.Buffer)
+	fields = make([]string, 2, 3)
+	copy(fields, []string{"RoleName", "AccountId"})
+	assert.NoError(t, printRolesData(out, roles[1:], fields[:1], LIST_OUTPUT_CSV))
+	assert.Equal(t, "RoleName,Tags\nReadOnly,Env=dev\n", out.String())
+	assert.Equal(t, []string{"RoleName", "AccountId"}, fields)
+
+	// Tags are only added once
+	out.Reset()
+	assert.NoError(t, printRolesData(out, roles[1:], []string{"Tags", "RoleName"}, LIST_OUTPUT_CSV))
+	assert.Equal(t, "Tags,RoleName\nEnv=dev,ReadOnly\n", out.String())
+}
+
+func TestJoinTags(t *testing.T) {
+	assert.Equal(t, "", joinTags(map[string]string{}))
+	assert.Equal(t, "", joinTags(nil))
+	assert.Equal(t, "Env=prod", joinTags(map[string]string{"Env": "prod"}))
+	assert.Equal(t, "A=1;B=;Z=x y", joinTags(map[string]string{"Z": "x y", "B": "", "A": "1"}))
+}
+
+func TestRoleValuesQuery(t *testing.T) {
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+	role := listRoles(ctx)[0]
+	values := roleValues(role)
+	assert.Equal(t, "000000000001", values["AccountId"])
+	assert.Equal(t, "Admin", values["RoleName"])
+	assert.Equal(t, "a b", values["Team"])
+	_, ok := values["AccountName"]
+	assert.False(t, ok)
+
+	tests := map[string]bool{
+		"RoleName=Admin":                   true,
+		"AccountId=000000000001":           true,
+		"Team='a b' AND Env IN (dev,prod)": true,
+		"AccountName":                      false,
+		"NOT EmailAddress":                 true,
+		"RoleName=~'Read.*'":               false,
+	}
+	for query, match := range tests {
+		q, err := sso.ParseTagQuery(query)
+		assert.NoError(t, err, query)
+		assert.Equal(t, match, q.Match(values), query)
+	}
+}
@@ -33,3 +33,8 @@ func GetLogger() *logrus.Logger {
 	return log
 }
 */
+
+// this is configured by main(), but we have this here for unit tests
+func init() {
+	log = logrus.New()
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/posener/complete"
 	// "github.com/davecgh/go-spew/spew"
 	"github.com/sirupsen/logrus"
+	"github.com/synfinatic/aws-sso-cli/server"
 	"github.com/synfinatic/aws-sso-cli/sso"
 	"github.com/synfinatic/aws-sso-cli/storage"
 	"github.com/synfinatic/aws-sso-cli/utils"
@@ -51,6 +52,8 @@ const (
 	CONFIG_DIR          = "~/.aws-sso"
 	CONFIG_FILE         = CONFIG_DIR + "/config.yaml"
 	JSON_STORE_FILE     = CONFIG_DIR + "/store.json"
+	ENCRYPTED_STORE     = CONFIG_DIR + "/store.enc"
+	AGENT_SOCKET        = CONFIG_DIR + "/agent/agent.sock"
 	INSECURE_CACHE_FILE = CONFIG_DIR + "/cache.json"
 	DEFAULT_STORE       = "file"
 	COPYRIGHT_YEAR      = "2021-2022"
@@ -78,10 +81,13 @@ var DEFAULT_CONFIG map[string]interface{} = map[string]interface{}{
 	"HistoryMinutes":                            1440, // 24hrs
 	"ListFields":                                []string{"AccountId", "AccountAlias", "RoleName", "ExpiresStr"},
 	"ConsoleDuration":                           60,
+	"ConsoleIssuer":                             "https://github.com/synfinatic/aws-sso-cli",
 	"UrlAction":                                 "open",
 	"UrlExecCommand":                            "",
 	"LogLevel":                                  "warn",
 	"DefaultSSO":                                "Default",
+	"ConfigBackups":                             5,
+	"SelectMode":                                "tags",
 }
 
 type CLI struct {
@@ -95,18 +101,25 @@ type CLI struct {
 	STSRefresh bool   `kong:"help='Force refresh of STS Token Credentials'"`
 
 	// Commands
+	Agent              AgentCmd                     `kong:"cmd,help='Run the credential agent'"`
 	Cache              CacheCmd                     `kong:"cmd,help='Force reload of cached AWS SSO role info and config.yaml'"`
 	Config             ConfigCmd                    `kong:"cmd,help='Update ~/.aws/config with AWS SSO profiles from the cache'"`
 	Console            ConsoleCmd                   `kong:"cmd,help='Open AWS Console using specificed AWS Role/profile'"`
+	CredentialsFile    CredentialsFileCmd           `kong:"cmd,help='Write role credentials to ~/.aws/credentials'"`
 	Default            DefaultCmd                   `kong:"cmd,hidden,default='1'"` // list command without args
+	EcsServer          EcsServerCmd                 `kong:"cmd,help='Run a local ECS container credentials endpoint'"`
 	Eval               EvalCmd                      `kong:"cmd,help='Print AWS Environment vars for use with eval $(aws-sso eval ...)'"`
 	Exec               ExecCmd                      `kong:"cmd,help='Execute command using specified IAM Role'"`
 	Flush              FlushCmd                     `kong:"cmd,help='Flush AWS SSO/STS credentials from cache'"`
+	ImdsServer         ImdsServerCmd                `kong:"cmd,help='Run a local EC2 instance metadata (IMDSv2) endpoint'"`
 	List               ListCmd                      `kong:"cmd,help='List all accounts / role (default command)'"`
 	Process            ProcessCmd                   `kong:"cmd,help='Generate JSON for credential_process in ~/.aws/config'"`
+	Store              StoreCmd                     `kong:"cmd,help='Manage the SecureStore'"`
 	Tags               TagsCmd                      `kong:"cmd,help='List tags'"`
 	Time               TimeCmd                      `kong:"cmd,help='Print out much time before current STS Token expires'"`
+	Tui                TuiCmd                       `kong:"cmd,help='Interactively browse roles and run actions on them'"`
 	Version            VersionCmd                   `kong:"cmd,help='Print version and exit'"`
+	Whoami             WhoamiCmd                    `kong:"cmd,help='Print the AWS SSO role of the current credentials'"`
 	InstallCompletions kongplete.InstallCompletions `kong:"cmd,help='Install shell completions'"`
 	Setup              SetupCmd                     `kong:"cmd,hidden"` // need this so variables are visisble.
 }
@@ -118,6 +131,7 @@ func main() {
 	log = logrus.New()
 	ctx, override := parseArgs(&cli)
 	sso.SetLogger(log)
+	server.SetLogger(log)
 	storage.SetLogger(log)
 	utils.SetLogger(log)
 
@@ -149,50 +163,118 @@ func main() {
 	}
 
 	// Load the secure store data
-	switch run_ctx.Settings.SecureStore {
+	switch ctx.Command() {
+	case "agent lock", "agent unlock", "agent stop":
+		// these only talk to the agent
+
+	default:
+		agentSock := os.Getenv(storage.ENV_SSO_AGENT_SOCK)
+		if agentSock != "" && ctx.Command() != "agent start" {
+			if run_ctx.Store, err = storage.OpenAgentClient(agentSock); err != nil {
+				log.WithError(err).Fatalf("Unable to connect to the agent")
+			}
+		} else if run_ctx.Store, err = openSecureStore(run_ctx.Settings, run_ctx.Settings.SecureStore); err != nil {
+			log.WithError(err).Fatalf("Unable to open SecureStore %s", run_ctx.Settings.SecureStore)
+		}
+	}
+
+	err = ctx.Run(&run_ctx)
+	var exitErr ExitCodeError
+	if errors.As(err, &exitErr) {
+		// pass through the exit status of `exec`
+		os.Exit(exitErr.Code)
+	} else if err != nil {
+		log.Fatalf("Error running command: %s", err.Error())
+	}
+}
+
+// openSecureStore opens the named SecureStore backend using our settings
+func openSecureStore(s *sso.Settings, name string) (storage.SecureStorage, error) {
+	return openSecureStorePassword(s, name, "")
+}
+
+// openSecureStorePassword is just like openSecureStore(), but the encrypted
+// and file stores are unlocked with the given password instead of prompting
+func openSecureStorePassword(s *sso.Settings, name, password string) (storage.SecureStorage, error) {
+	switch name {
 	case "json":
 		sfile := utils.GetHomePath(JSON_STORE_FILE)
-		if run_ctx.Settings.JsonStore != "" {
-			sfile = utils.GetHomePath(run_ctx.Settings.JsonStore)
+		if s.JsonStore != "" {
+			sfile = utils.GetHomePath(s.JsonStore)
 		}
-		run_ctx.Store, err = storage.OpenJsonStore(sfile)
+		store, err := storage.OpenJsonStore(sfile)
 		if err != nil {
-			log.WithError(err).Fatalf("Unable to open JsonStore %s", sfile)
+			return nil, fmt.Errorf("Unable to open JsonStore %s: %s", sfile, err.Error())
 		}
 		log.Warnf("Using insecure json file for SecureStore: %s", sfile)
-	default:
-		cfg, err := storage.NewKeyringConfig(run_ctx.Settings.SecureStore, CONFIG_DIR)
-		if err != nil {
-			log.WithError(err).Fatalf("Unable to create SecureStore")
+		return store, nil
+
+	case "encrypted":
+		sfile := utils.GetHomePath(ENCRYPTED_STORE)
+		if s.EncryptedStore != "" {
+			sfile = utils.GetHomePath(s.EncryptedStore)
 		}
-		run_ctx.Store, err = storage.OpenKeyring(cfg)
-		if err != nil {
-			log.WithError(err).Fatalf("Unable to open SecureStore %s", run_ctx.Settings.SecureStore)
+		_, err := os.Stat(sfile)
+		source := &storage.PassphraseSource{
+			EnvVar:   storage.ENV_SSO_STORE_PASSWORD,
+			FdEnvVar: storage.ENV_SSO_STORE_PASSWORD_FD,
+			Command:  s.EncryptedStoreCmd,
+			Prompt:   "Store password",
+			Confirm:  errors.Is(err, os.ErrNotExist), // new store
 		}
-	}
+		passphrase := source.Func()
+		if password != "" {
+			passphrase = func() (string, error) {
+				return password, nil
+			}
+		}
+		return storage.OpenEncryptedStore(sfile, passphrase)
 
-	err = ctx.Run(&run_ctx)
-	if err != nil {
-		log.Fatalf("Error running command: %s", err.Error())
+	case "exec":
+		return storage.OpenExecStore(s.ExecStoreCommand)
+
+	default:
+		cfg, err := storage.NewKeyringConfigPassword(name, CONFIG_DIR, password)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create SecureStore: %s", err.Error())
+		}
+		return storage.OpenKeyring(cfg)
 	}
 }
 
-// parseArgs parses our CLI arguments
-func parseArgs(cli *CLI) (*kong.Context, sso.OverrideSettings) {
-	// need to pass in the variables for defaults
-	vars := kong.Vars{
+// kongVars returns the variables for the defaults in our CLI
+func kongVars() kong.Vars {
+	return kong.Vars{
+		"AGENT_SOCKET":    AGENT_SOCKET,
 		"CONFIG_DIR":      CONFIG_DIR,
 		"CONFIG_FILE":     CONFIG_FILE,
 		"DEFAULT_STORE":   DEFAULT_STORE,
 		"JSON_STORE_FILE": JSON_STORE_FILE,
 	}
+}
+
+// flagOnCli returns if the flag was passed on the command line rather than
+// being set via its environment variable or default
+func flagOnCli(ctx *RunContext, name string) bool {
+	if ctx.Kctx == nil {
+		return false
+	}
+	for _, p := range ctx.Kctx.Path {
+		if p.Flag != nil && !p.Resolved && p.Flag.Name == name {
+			return true
+		}
+	}
+	return false
+}
 
+// parseArgs parses our CLI arguments
+func parseArgs(cli *CLI) (*kong.Context, sso.OverrideSettings) {
 	parser := kong.Must(
 		cli,
 		kong.Name("aws-sso"),
 		kong.Description("Securely manage temporary AWS API Credentials issued via AWS SSO"),
 		kong.UsageOnError(),
-		vars,
+		kongVars(),
 	)
 
 	p := NewPredictor(utils.GetHomePath(INSECURE_CACHE_FILE), utils.GetHomePath(CONFIG_FILE))
@@ -206,7 +288,9 @@ func parseArgs(cli *CLI) (*kong.Context, sso.OverrideSettings) {
 				"profile":   p.ProfileComplete(),
 				"region":    p.RegionComplete(),
 				"role":      p.RoleComplete(),
+				"service":   p.ServiceComplete(),
 				"sso":       p.SsoComplete(),
+				"tag":       p.TagComplete(),
 			},
 		),
 	)
@@ -246,18 +330,33 @@ func (cc *VersionCmd) Run(ctx *RunContext) error {
 
 // Get our RoleCredentials from the secure store or from AWS SSO
 func GetRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string) *storage.RoleCredentials {
+	creds, err := getRoleCredentials(ctx, awssso, accountid, role)
+	if err != nil {
+		log.WithError(err).Fatalf("Unable to get role credentials for %s", utils.MakeRoleARN(accountid, role))
+	}
+	return creds
+}
+
+// getRoleCredentials is like GetRoleCredentials, but returns an error instead of exiting
+func getRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string) (*storage.RoleCredentials, error) {
+	return fetchRoleCredentials(ctx, awssso, accountid, role, ctx.Cli.STSRefresh)
+}
+
+// fetchRoleCredentials returns the role credentials from the SecureStore or
+// AWS SSO.  The SecureStore is skipped if refresh is set.
+func fetchRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string, refresh bool) (*storage.RoleCredentials, error) {
 	creds := storage.RoleCredentials{}
 
 	// First look for our creds in the secure store, if we're not forcing a refresh
 	arn := utils.MakeRoleARN(accountid, role)
 	log.Debugf("Getting role credentials for %s", arn)
-	if !ctx.Cli.STSRefresh {
+	if !refresh {
 		if roleFlat, err := ctx.Settings.Cache.GetRole(arn); err == nil {
 			if !roleFlat.IsExpired() {
 				if err := ctx.Store.GetRoleCredentials(arn, &creds); err == nil {
 					if !creds.Expired() {
 						log.Debugf("Retrieved role credentials from the SecureStore")
-						return &creds
+						return &creds, nil
 					}
 				}
 			}
@@ -272,7 +371,7 @@ func GetRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, ro
 	var err error
 	creds, err = awssso.GetRoleCredentials(accountid, role)
 	if err != nil {
-		log.WithError(err).Fatalf("Unable to get role credentials for %s", arn)
+		return nil, err
 	}
 
 	log.Debugf("Retrieved role credentials from AWS SSO")
@@ -286,7 +385,12 @@ func GetRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, ro
 	if err := ctx.Settings.Cache.SetRoleExpires(arn, creds.ExpireEpoch()); err != nil {
 		log.WithError(err).Warnf("Unable to update cache")
 	}
-	return &creds
+
+	// Opportunistically purge the expired RoleCredentials
+	if _, err := gcSecureStore(ctx, false); err != nil {
+		log.WithError(err).Warnf("Unable to delete expired secrets from the SecureStore")
+	}
+	return &creds, nil
 }
 
 var AwsSSO *sso.AWSSSO // global
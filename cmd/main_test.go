@@ -0,0 +1,71 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/sso"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden compares the output to the golden file in testdata
+func assertGolden(t *testing.T, name, output string) {
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.NoError(t, os.WriteFile(golden, []byte(output), 0644))
+	}
+	expected, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), output)
+}
+
+// testSettings loads testdata/config.yaml and a copy of testdata/cache.json
+// with the AWS SSO instances Default and "My Org"
+func testSettings(t *testing.T) *sso.Settings {
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	data, err := os.ReadFile(filepath.Join("testdata", "cache.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(cacheFile, data, 0600))
+
+	settings, err := sso.LoadSettings(filepath.Join("testdata", "config.yaml"), cacheFile,
+		DEFAULT_CONFIG, sso.OverrideSettings{})
+	assert.NoError(t, err)
+	return settings
+}
+
+// testRunContext parses the args like main() does
+func testRunContext(t *testing.T, settings *sso.Settings, args ...string) *RunContext {
+	cli := &CLI{}
+	parser, err := kong.New(cli, kong.Name("aws-sso"), kongVars())
+	assert.NoError(t, err)
+	kctx, err := parser.Parse(args)
+	assert.NoError(t, err)
+	return &RunContext{
+		Kctx:     kctx,
+		Cli:      cli,
+		Settings: settings,
+	}
+}
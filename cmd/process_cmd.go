@@ -30,10 +30,12 @@ import (
 
 type ProcessCmd struct {
 	// AWS Params
-	Arn       string `kong:"short='a',help='ARN of role to assume',xor='arn-1',xor='arn-2',predictor='arn'"`
-	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of role to assume',xor='arn-1',predictor='accountId'"`
-	Role      string `kong:"short='R',help='Name of AWS Role to assume',xor='arn-2',predictor='role'"`
-	Profile   string `kong:"short='p',help='Name of AWS Profile to assume',xor='arn-1',predictor='profile'"`
+	Arn       string            `kong:"short='a',help='ARN of role to assume',xor='arn-1',xor='arn-2',predictor='arn'"`
+	AccountId int64             `kong:"name='account',short='A',help='AWS AccountID of role to assume',xor='arn-1',predictor='accountId'"`
+	Role      string            `kong:"short='R',help='Name of AWS Role to assume',xor='arn-2',predictor='role'"`
+	Profile   string            `kong:"short='p',help='Name of AWS Profile to assume',xor='arn-1',predictor='profile'"`
+	Tag       map[string]string `kong:"help='Select the role with the tag Key=Value (repeatable)',xor='arn-1',xor='arn-2',predictor='tag'"`
+	First     bool              `kong:"help='Use the first role if --tag matches multiple roles'"`
 }
 
 func (cc *ProcessCmd) Run(ctx *RunContext) error {
@@ -43,12 +45,16 @@ func (cc *ProcessCmd) Run(ctx *RunContext) error {
 		return fmt.Errorf("Unsupported --url-action=print option")
 	}
 
+	if err = checkFirstFlag(ctx.Cli.Process.First, ctx.Cli.Process.Tag); err != nil {
+		return err
+	}
+
 	role := ctx.Cli.Process.Role
 	account := ctx.Cli.Process.AccountId
 
 	if ctx.Cli.Process.Profile != "" {
 		cache := ctx.Settings.Cache.GetSSO()
-		rFlat, err := cache.Roles.GetRoleByProfile(ctx.Cli.Eval.Profile, ctx.Settings)
+		rFlat, err := cache.Roles.GetRoleByProfile(ctx.Cli.Process.Profile, ctx.Settings)
 		if err != nil {
 			return err
 		}
@@ -60,10 +66,19 @@ func (cc *ProcessCmd) Run(ctx *RunContext) error {
 		if err != nil {
 			return err
 		}
+	} else if len(ctx.Cli.Process.Tag) > 0 {
+		arn, err := selectRoleByTags(ctx, ctx.Cli.Process.Tag, ctx.Cli.Process.First)
+		if err != nil {
+			return err
+		}
+		account, role, err = utils.ParseRoleARN(arn)
+		if err != nil {
+			return err
+		}
 	}
 
 	if role == "" || account == 0 {
-		return fmt.Errorf("Please specify --arn or --account and --role")
+		return fmt.Errorf("Please specify --arn, --tag or --account and --role")
 	}
 
 	awssso := doAuth(ctx)
@@ -19,9 +19,11 @@ package main
  */
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/c-bata/go-prompt"
@@ -32,6 +34,54 @@ import (
 
 type CompleterExec = func(*RunContext, *sso.AWSSSO, int64, string) error
 
+const (
+	SELECT_MODE_TAGS  = "tags"
+	SELECT_MODE_FUZZY = "fuzzy"
+)
+
+// RoleCompleter is an interactive go-prompt role selector
+type RoleCompleter interface {
+	Complete(prompt.Document) []prompt.Suggest
+	Executor(string)
+	ExitChecker(string, bool) bool
+}
+
+// selectRolePrompt lets the user interactively select a role using the given
+// select mode or the SelectMode setting and then calls exec.  If stderr is set
+// the prompt is written to stderr so stdout can be captured.
+func selectRolePrompt(ctx *RunContext, s *sso.SSOConfig, mode string, exec CompleterExec, stderr bool) error {
+	if mode == "" {
+		mode = ctx.Settings.SelectMode
+	}
+
+	var c RoleCompleter
+	switch mode {
+	case SELECT_MODE_TAGS, "":
+		c = NewTagsCompleter(ctx, s, exec)
+	case SELECT_MODE_FUZZY:
+		c = NewFuzzyCompleter(ctx, s, exec)
+	default:
+		return fmt.Errorf("Invalid SelectMode: %s", mode)
+	}
+
+	opts := ctx.Settings.DefaultOptions(c.ExitChecker)
+	opts = append(opts, ctx.Settings.GetColorOptions()...)
+	out := os.Stdout
+	if stderr {
+		out = os.Stderr
+		opts = append(opts, prompt.OptionWriter(prompt.NewStderrWriter()))
+	}
+	fmt.Fprintf(out, "Please use `exit` or `Ctrl-D` to quit.\n")
+
+	p := prompt.New(
+		c.Executor,
+		c.Complete,
+		opts...,
+	)
+	p.Run()
+	return nil
+}
+
 type TagsCompleter struct {
 	ctx      *RunContext
 	sso      *sso.SSOConfig
@@ -77,6 +127,9 @@ func (tc *TagsCompleter) Complete(d prompt.Document) []prompt.Suggest {
 var isRoleARN *regexp.Regexp = regexp.MustCompile(`^arn:aws:iam::\d+:role/[a-zA-Z0-9\+=,\.@_-]+$`)
 var NoSpaceAtEnd *regexp.Regexp = regexp.MustCompile(`\s+$`)
 
+// input containing any of these is treated as a tag query if it isn't a valid selection
+const TAG_QUERY_CHARS = "=!~()'\""
+
 func (tc *TagsCompleter) Executor(args string) {
 	args = NoSpaceAtEnd.ReplaceAllString(args, "")
 	if args == "exit" {
@@ -93,6 +146,17 @@ func (tc *TagsCompleter) Executor(args string) {
 		argsMap, _, _ := argsToMap(strings.Split(args, " "))
 
 		ssoRoles := tc.roleTags.GetMatchingRoles(argsMap)
+		if len(ssoRoles) != 1 && strings.ContainsAny(args, TAG_QUERY_CHARS) {
+			// Tag query matches the real tag values which may contain spaces
+			query, err := sso.ParseTagQuery(args)
+			if err != nil {
+				log.Fatalf("%s", err.Error())
+			}
+			ssoRoles = []string{}
+			for _, r := range tc.ctx.Settings.Cache.GetSSO().Roles.MatchingRolesQuery(query) {
+				ssoRoles = append(ssoRoles, r.Arn)
+			}
+		}
 		if len(ssoRoles) == 0 {
 			log.Fatalf("Invalid selection: No matching roles.")
 		} else if len(ssoRoles) > 1 {
@@ -101,17 +165,78 @@ func (tc *TagsCompleter) Executor(args string) {
 		roleArn = ssoRoles[0]
 	}
 
+	execSelectedRole(tc.ctx, tc.exec, roleArn)
+}
+
+// execSelectedRole runs exec for the role selected in the prompt and exits on error
+func execSelectedRole(ctx *RunContext, exec CompleterExec, roleArn string) {
 	aId, rName, err := utils.ParseRoleARN(roleArn)
 	if err != nil {
 		log.Fatalf("Unable to parse %s: %s", roleArn, err.Error())
 	}
-	awsSSO := doAuth(tc.ctx)
-	err = tc.exec(tc.ctx, awsSSO, aId, rName)
-	if err != nil {
+	awsSSO := doAuth(ctx)
+	err = exec(ctx, awsSSO, aId, rName)
+	var exitErr ExitCodeError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	} else if err != nil {
 		log.Fatalf("Unable to exec: %s", err.Error())
 	}
 }
 
+// checkFirstFlag returns an error if --first is used without --tag
+func checkFirstFlag(first bool, tags map[string]string) error {
+	if first && len(tags) == 0 {
+		return fmt.Errorf("--first requires --tag")
+	}
+	return nil
+}
+
+// matchingRoleArns returns the sorted ARNs of the roles matching all of the
+// --tag values.  This is used by every command with --tag so they select the
+// same roles.
+func matchingRoleArns(ctx *RunContext, tags map[string]string) []string {
+	arns := ctx.Settings.Cache.GetSSO().Roles.GetRoleTags().GetMatchingRoles(tags)
+	if len(arns) == 0 {
+		// allow the values with underscores shown by the prompt
+		arns = ctx.Settings.Cache.GetRoleTagsSelect().GetMatchingRoles(tags)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// selectRoleByTags returns the ARN of the role matching all of the tags using
+// the same matching as the interactive prompt.  Multiple matches are an error
+// unless first is set, in which case the first ARN in sorted order is used.
+func selectRoleByTags(ctx *RunContext, tags map[string]string, first bool) (string, error) {
+	arns := matchingRoleArns(ctx, tags)
+
+	switch {
+	case len(arns) == 0:
+		return "", fmt.Errorf("No roles match the provided --tag")
+
+	case len(arns) == 1:
+		return arns[0], nil
+
+	case first:
+		log.Infof("%d roles match the provided --tag, using %s", len(arns), arns[0])
+		return arns[0], nil
+	}
+
+	candidates := []string{}
+	for _, arn := range arns {
+		candidate := "  " + arn
+		if rFlat, err := ctx.Settings.Cache.GetRole(arn); err == nil {
+			if profile, err := rFlat.ProfileName(ctx.Settings); err == nil {
+				candidate = fmt.Sprintf("  %s (%s)", arn, profile)
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	return "", fmt.Errorf("%d roles match the provided --tag, please add more tags or use --first:\n%s",
+		len(arns), strings.Join(candidates, "\n"))
+}
+
 // completeExitChecker implements prompt.ExitChecker
 func (tc *TagsCompleter) ExitChecker(in string, breakline bool) bool {
 	return breakline // exit our Run() loop after user selects something
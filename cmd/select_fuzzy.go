@@ -0,0 +1,129 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/c-bata/go-prompt"
+	"github.com/synfinatic/aws-sso-cli/sso"
+)
+
+// FuzzyCompleter selects a role by fuzzy searching the account name, alias,
+// role, profile and tag values
+type FuzzyCompleter struct {
+	ctx      *RunContext
+	sso      *sso.SSOConfig
+	roleTags *sso.RoleTags
+	previews map[string]string // ARN => description
+	exec     CompleterExec
+}
+
+func NewFuzzyCompleter(ctx *RunContext, s *sso.SSOConfig, exec CompleterExec) *FuzzyCompleter {
+	roleTags := ctx.Settings.Cache.GetRoleTagsSelect()
+	previews := map[string]string{}
+
+	for arn, tags := range *roleTags {
+		rFlat, err := ctx.Settings.Cache.GetRole(arn)
+		if err != nil {
+			continue
+		}
+		profile, err := rFlat.ProfileName(ctx.Settings)
+		if err != nil {
+			log.Warnf(err.Error())
+		} else {
+			// make the profile searchable
+			tags["Profile"] = strings.ReplaceAll(profile, " ", "_")
+		}
+		previews[arn] = fuzzyPreview(rFlat, profile)
+	}
+
+	return &FuzzyCompleter{
+		ctx:      ctx,
+		sso:      s,
+		roleTags: roleTags,
+		previews: previews,
+		exec:     exec,
+	}
+}
+
+// fuzzyPreview returns the profile, expiry and tags of the role
+func fuzzyPreview(rFlat *sso.AWSRoleFlat, profile string) string {
+	fields := []string{profile}
+	if rFlat.Expires > 0 {
+		if expires, err := rFlat.ExpiresIn(); err == nil {
+			fields = append(fields, fmt.Sprintf("Expires: %s", expires))
+		}
+	}
+
+	tags := []string{}
+	for k, v := range rFlat.Tags {
+		if k != "History" && k != "AccountID" {
+			tags = append(tags, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	sort.Strings(tags)
+	fields = append(fields, strings.Join(tags, " "))
+	return strings.Join(fields, " | ")
+}
+
+// Complete returns the roles matching the input, best match first
+func (fc *FuzzyCompleter) Complete(d prompt.Document) []prompt.Suggest {
+	suggest := []prompt.Suggest{}
+	for _, m := range fc.roleTags.FuzzySearch(d.TextBeforeCursor()) {
+		suggest = append(suggest, prompt.Suggest{
+			Text:        m.Arn,
+			Description: fc.previews[m.Arn],
+		})
+	}
+	return suggest
+}
+
+func (fc *FuzzyCompleter) Executor(args string) {
+	args = strings.TrimSpace(args)
+	if args == "exit" {
+		os.Exit(1)
+	}
+
+	var roleArn string
+	words := strings.Fields(args)
+	if len(words) > 0 && isRoleARN.MatchString(words[len(words)-1]) {
+		// user selected a suggestion
+		roleArn = words[len(words)-1]
+	} else {
+		// use the best match if there is one
+		matches := fc.roleTags.FuzzySearch(args)
+		if len(matches) == 0 {
+			log.Fatalf("Invalid selection: No matching roles.")
+		} else if len(matches) > 1 && matches[0].Score == matches[1].Score {
+			log.Fatalf("Invalid selection: %d roles match equally well, please select one.", len(matches))
+		}
+		roleArn = matches[0].Arn
+	}
+
+	execSelectedRole(fc.ctx, fc.exec, roleArn)
+}
+
+// ExitChecker implements prompt.ExitChecker
+func (fc *FuzzyCompleter) ExitChecker(in string, breakline bool) bool {
+	return breakline // exit our Run() loop after user selects something
+}
@@ -0,0 +1,53 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchingRoleArns(t *testing.T) {
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t)}
+
+	tests := []struct {
+		name string
+		tags map[string]string
+		arns []string
+	}{
+		{"tag", map[string]string{"Env": "dev"}, []string{
+			"arn:aws:iam::000000000001:role/Admin",
+			"arn:aws:iam::000000000001:role/ReadOnly",
+			"arn:aws:iam::000000000010:role/ReadOnly",
+		}},
+		{"multiple tags", map[string]string{"Env": "dev", "Team": "a b"}, []string{
+			"arn:aws:iam::000000000001:role/Admin",
+		}},
+		{"prompt value", map[string]string{"Team": "a_b"}, []string{
+			"arn:aws:iam::000000000001:role/Admin",
+		}},
+		{"no match", map[string]string{"Env": "qa"}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.arns, matchingRoleArns(ctx, tc.tags))
+		})
+	}
+}
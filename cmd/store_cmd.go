@@ -0,0 +1,165 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+
+	"github.com/synfinatic/aws-sso-cli/sso"
+	"github.com/synfinatic/aws-sso-cli/storage"
+)
+
+// StoreCmd defines the Kong args for the store command and sub-commands
+type StoreCmd struct {
+	Gc      StoreGcCmd      `kong:"cmd,help='Delete expired secrets from the SecureStore'"`
+	Migrate StoreMigrateCmd `kong:"cmd,help='Copy secrets from one SecureStore backend to another'"`
+	Rekey   StoreRekeyCmd   `kong:"cmd,help='Change the password of the encrypted SecureStore'"`
+}
+
+type StoreGcCmd struct{} // takes no arguments
+
+// Run executes the `store gc` command
+func (cc *StoreGcCmd) Run(ctx *RunContext) error {
+	result, err := gcSecureStore(ctx, true)
+	if err != nil {
+		return err
+	}
+	log.Infof("Deleted %d RegisterClientData, %d CreateTokenResponse and %d RoleCredentials",
+		len(result.RegisterClientData), len(result.CreateTokenResponse), len(result.RoleCredentials))
+	return nil
+}
+
+// gcSecureStore deletes expired secrets from the SecureStore and resets the
+// Expires time of those roles in the cache.  Unless all is set, we only check
+// the RoleCredentials of the roles which the cache knows to have expired.
+func gcSecureStore(ctx *RunContext, all bool) (storage.GCResult, error) {
+	expired := ctx.Settings.Cache.ExpiredRoleArns()
+	keys := storage.StoreKeys{Roles: expired}
+	if all {
+		keys = secureStoreKeys(ctx.Settings, ctx.Store)
+	}
+
+	result, err := storage.GarbageCollect(ctx.Store, keys)
+	if err != nil {
+		return result, err
+	}
+
+	// expired roles no longer have creds in the SecureStore
+	return result, ctx.Settings.Cache.ResetRolesExpires(append(expired, result.RoleCredentials...))
+}
+
+type StoreMigrateCmd struct {
+	From         string `kong:"help='SecureStore to copy from (default: current SecureStore)'"`
+	To           string `kong:"required,help='SecureStore to copy to'"`
+	UpdateConfig bool   `kong:"help='Set SecureStore in the config file to the new backend'"`
+	Wipe         bool   `kong:"help='Delete the copied secrets from the old backend'"`
+}
+
+// Run executes the `store migrate` command
+func (cc *StoreMigrateCmd) Run(ctx *RunContext) error {
+	args := ctx.Cli.Store.Migrate
+	if args.From == "" {
+		args.From = ctx.Settings.SecureStore
+	}
+	if args.From == args.To {
+		return fmt.Errorf("--from and --to must be different SecureStores")
+	}
+
+	var err error
+	from := ctx.Store
+	if args.From != ctx.Settings.SecureStore || from == nil {
+		if from, err = openSecureStore(ctx.Settings, args.From); err != nil {
+			return fmt.Errorf("Unable to open SecureStore %s: %s", args.From, err.Error())
+		}
+	}
+	to, err := openSecureStore(ctx.Settings, args.To)
+	if err != nil {
+		return fmt.Errorf("Unable to open SecureStore %s: %s", args.To, err.Error())
+	}
+
+	result, err := storage.Migrate(from, to, secureStoreKeys(ctx.Settings, from))
+	if err != nil {
+		return err
+	}
+	log.Infof("Migrated %d RegisterClientData, %d CreateTokenResponse and %d RoleCredentials from %s to %s",
+		len(result.RegisterClientData), len(result.CreateTokenResponse),
+		len(result.RoleCredentials), args.From, args.To)
+	if len(result.ExpiredRoles) > 0 {
+		log.Infof("Skipped %d expired RoleCredentials", len(result.ExpiredRoles))
+	}
+
+	if args.UpdateConfig {
+		if err = sso.SetConfigValue(ctx.Cli.ConfigFile, "SecureStore", args.To); err != nil {
+			return fmt.Errorf("Unable to update %s: %s", ctx.Cli.ConfigFile, err.Error())
+		}
+		log.Infof("Updated SecureStore in %s", ctx.Cli.ConfigFile)
+	}
+
+	if args.Wipe {
+		if err = storage.Wipe(from, result); err != nil {
+			return err
+		}
+		log.Infof("Deleted migrated secrets from %s", args.From)
+	}
+	return nil
+}
+
+// secureStoreKeys returns the keys of all the items we may have stored in the
+// SecureStore based on our config and cache
+func secureStoreKeys(s *sso.Settings, store storage.SecureStorage) storage.StoreKeys {
+	keys := storage.StoreKeys{
+		SSO:   []string{},
+		Roles: []string{},
+	}
+
+	for name, ssoConfig := range s.SSO {
+		ssoConfig.Refresh(s) // only the default SSO instance is loaded
+		keys.SSO = append(keys.SSO, sso.NewAWSSSO(ssoConfig, &store).StoreKey())
+		if cache, ok := s.Cache.SSO[name]; ok && cache.Roles != nil {
+			for _, role := range cache.Roles.GetAllRoles() {
+				keys.Roles = append(keys.Roles, role.Arn)
+			}
+		}
+	}
+	return keys
+}
+
+type StoreRekeyCmd struct {
+	PasswordCommand []string `kong:"help='Command which prints the new password on stdout'"`
+}
+
+// Run executes the `store rekey` command
+func (cc *StoreRekeyCmd) Run(ctx *RunContext) error {
+	es, ok := ctx.Store.(*storage.EncryptedStore)
+	if !ok {
+		return fmt.Errorf("rekey is only supported with `SecureStore: encrypted`")
+	}
+
+	source := &storage.PassphraseSource{
+		EnvVar:  storage.ENV_SSO_STORE_NEW_PASSWORD,
+		Command: ctx.Cli.Store.Rekey.PasswordCommand,
+		Prompt:  "New store password",
+		Confirm: true,
+	}
+	if err := es.Rekey(source.Func()); err != nil {
+		return fmt.Errorf("Unable to rekey SecureStore: %s", err.Error())
+	}
+	log.Infof("SecureStore has been re-encrypted with the new password")
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/storage"
+)
+
+func TestGcSecureStore(t *testing.T) {
+	store, err := storage.OpenJsonStore(filepath.Join(t.TempDir(), "store.json"))
+	assert.NoError(t, err)
+	ctx := &RunContext{Cli: &CLI{}, Settings: testSettings(t), Store: store}
+
+	now := time.Now()
+	expired := "arn:aws:iam::000000000001:role/Admin"
+	valid := "arn:aws:iam::000000000001:role/ReadOnly"
+	unknown := "arn:aws:iam::000000000010:role/ReadOnly" // cache doesn't know it expired
+	assert.NoError(t, ctx.Settings.Cache.SetRoleExpires(expired, now.Add(-time.Minute).Unix()))
+	assert.NoError(t, ctx.Settings.Cache.SetRoleExpires(valid, now.Add(time.Hour).Unix()))
+	assert.NoError(t, store.SaveRoleCredentials(expired, storage.RoleCredentials{Expiration: now.Add(-time.Minute).UnixMilli()}))
+	assert.NoError(t, store.SaveRoleCredentials(valid, storage.RoleCredentials{Expiration: now.Add(time.Hour).UnixMilli()}))
+	assert.NoError(t, store.SaveRoleCredentials(unknown, storage.RoleCredentials{Expiration: now.Add(-time.Minute).UnixMilli()}))
+
+	ssoKeys := secureStoreKeys(ctx.Settings, store).SSO
+	assert.Len(t, ssoKeys, 2)
+	for _, key := range ssoKeys {
+		assert.NoError(t, store.SaveRegisterClientData(key, storage.RegisterClientData{ClientSecretExpiresAt: now.Add(-time.Minute).Unix()}))
+	}
+
+	// on save only the roles the cache knows to have expired are checked
+	result, err := gcSecureStore(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{expired}, result.RoleCredentials)
+	assert.Empty(t, result.RegisterClientData)
+	assert.Error(t, store.GetRoleCredentials(expired, &storage.RoleCredentials{}))
+	assert.NoError(t, store.GetRoleCredentials(valid, &storage.RoleCredentials{}))
+	assert.NoError(t, store.GetRoleCredentials(unknown, &storage.RoleCredentials{}))
+
+	rFlat, err := ctx.Settings.Cache.GetRole(expired)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rFlat.Expires)
+
+	// store gc checks everything
+	result, err = gcSecureStore(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{unknown}, result.RoleCredentials)
+	assert.ElementsMatch(t, ssoKeys, result.RegisterClientData)
+	assert.NoError(t, store.GetRoleCredentials(valid, &storage.RoleCredentials{}))
+}
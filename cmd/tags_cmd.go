@@ -28,6 +28,7 @@ import (
 type TagsCmd struct {
 	AccountId   int64  `kong:"name='account',short='A',help='Filter results based on AWS AccountID'"`
 	Role        string `kong:"short='R',help='Filter results based on AWS Role Name'"`
+	Query       string `kong:"short='q',help='Filter results with a tag query (Env=prod AND Team IN (a, b))'"`
 	ForceUpdate bool   `kong:"help='Force account/role cache update'"`
 }
 
@@ -69,6 +70,14 @@ func (cc *TagsCmd) Run(ctx *RunContext) error {
 			}
 		}
 	}
+	var query *sso.TagQuery
+	if ctx.Cli.Tags.Query != "" {
+		var err error
+		if query, err = sso.ParseTagQuery(ctx.Cli.Tags.Query); err != nil {
+			return err
+		}
+	}
+
 	roles := []*sso.AWSRoleFlat{}
 
 	// If user has specified an account (or account + role) then limit
@@ -93,6 +102,9 @@ func (cc *TagsCmd) Run(ctx *RunContext) error {
 	}
 
 	for _, fRole := range roles {
+		if query != nil && !query.Match(fRole.Tags) {
+			continue
+		}
 		fmt.Printf("%s\n", fRole.Arn)
 		keys := make([]string, 0, len(fRole.Tags))
 		for k := range fRole.Tags {
@@ -0,0 +1,692 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/synfinatic/aws-sso-cli/sso"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	TUI_HELP         = "↑↓ move  ←→ collapse/expand  / search  c console  x exec  e copy eval  f flush  p pin  q quit"
+	TUI_REFRESH      = time.Second
+	TUI_MIN_SIDE_COL = 80 // min terminal width to show the side panel
+)
+
+type TuiCmd struct {
+	Shell string `kong:"help='Shell to run for the exec action',env='SHELL'"`
+}
+
+type tuiNodeKind int
+
+const (
+	tuiSSONode tuiNodeKind = iota
+	tuiFavoritesNode
+	tuiAccountNode
+	tuiRoleNode
+)
+
+// tuiNode is a node in the SSO instance -> account -> role tree
+type tuiNode struct {
+	id       string // unique id to track the expanded state
+	kind     tuiNodeKind
+	ssoName  string
+	label    string
+	account  *sso.AWSAccount  // account nodes
+	role     *sso.AWSRoleFlat // role nodes
+	children []*tuiNode
+}
+
+// tuiRow is a visible row of the tree
+type tuiRow struct {
+	node  *tuiNode
+	depth int
+}
+
+type tuiModel struct {
+	ctx       *RunContext
+	term      *tuiTerm
+	activeSSO string // SSO instance used for actions
+	roots     []*tuiNode
+	expanded  map[string]bool
+	rows      []tuiRow
+	cursor    int
+	offset    int // first row shown
+	search    string
+	searching bool
+	status    string
+}
+
+func (cc *TuiCmd) Run(ctx *RunContext) error {
+	if len(ctx.Settings.Cache.SSO) == 0 {
+		return fmt.Errorf("No roles in the cache.  Please run `aws-sso cache` first")
+	}
+	if runtime.GOOS == "windows" && ctx.Cli.Tui.Shell == "" {
+		ctx.Cli.Tui.Shell = "cmd.exe"
+	}
+
+	activeSSO, err := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+	if err != nil {
+		return err
+	}
+
+	m := newTuiModel(ctx, activeSSO)
+	if m.term, err = openTuiTerm(); err != nil {
+		return err
+	}
+	defer func() { m.term.Close() }()
+
+	// only read a key when asked so nothing steals input while suspended
+	type keyEvent struct {
+		key tuiKey
+		err error
+	}
+	next := make(chan *tuiTerm)
+	keys := make(chan keyEvent)
+	go func() {
+		for term := range next {
+			key, err := term.ReadKey()
+			keys <- keyEvent{key, err}
+		}
+	}()
+	defer close(next)
+
+	ticker := time.NewTicker(TUI_REFRESH)
+	defer ticker.Stop()
+
+	next <- m.term
+	for {
+		m.draw()
+		select {
+		case ev := <-keys:
+			if ev.err != nil {
+				return ev.err
+			}
+			if quit := m.handleKey(ev.key); quit {
+				return nil
+			}
+			next <- m.term
+		case <-ticker.C:
+			// update the countdowns
+		}
+	}
+}
+
+// newTuiModel returns the model with the active SSO instance and its
+// favorites expanded
+func newTuiModel(ctx *RunContext, activeSSO string) *tuiModel {
+	m := &tuiModel{
+		ctx:       ctx,
+		activeSSO: activeSSO,
+		expanded: map[string]bool{
+			"sso:" + activeSSO: true,
+			"fav:" + activeSSO: true,
+		},
+	}
+	m.build()
+	return m
+}
+
+// build creates the tree from the cache
+func (m *tuiModel) build() {
+	cache := m.ctx.Settings.Cache
+	defer cache.SetSSO(m.activeSSO)
+
+	ssoNames := []string{}
+	for name := range cache.SSO {
+		ssoNames = append(ssoNames, name)
+	}
+	sort.Strings(ssoNames)
+
+	m.roots = []*tuiNode{}
+	for _, ssoName := range ssoNames {
+		cache.SetSSO(ssoName)
+		ssoCache := cache.GetSSO()
+		ssoNode := &tuiNode{
+			id:      "sso:" + ssoName,
+			kind:    tuiSSONode,
+			ssoName: ssoName,
+			label:   ssoName,
+		}
+
+		favorites := &tuiNode{
+			id:      "fav:" + ssoName,
+			kind:    tuiFavoritesNode,
+			ssoName: ssoName,
+			label:   "★ Favorites",
+		}
+		for _, arn := range ssoCache.Favorites {
+			if rFlat, err := cache.GetRole(arn); err == nil {
+				favorites.children = append(favorites.children, m.roleNode(ssoName, rFlat, "fav"))
+			}
+		}
+		if len(favorites.children) > 0 {
+			ssoNode.children = append(ssoNode.children, favorites)
+		}
+
+		accountIds := ssoCache.Roles.AccountIds()
+		sort.Slice(accountIds, func(i, j int) bool { return accountIds[i] < accountIds[j] })
+		for _, aId := range accountIds {
+			account := ssoCache.Roles.Accounts[aId]
+			idStr, _ := utils.AccountIdToString(aId)
+			label := idStr
+			if account.Alias != "" {
+				label += " " + account.Alias
+			}
+			if account.Name != "" && account.Name != account.Alias {
+				label += fmt.Sprintf(" (%s)", account.Name)
+			}
+			accountNode := &tuiNode{
+				id:      fmt.Sprintf("acct:%s:%s", ssoName, idStr),
+				kind:    tuiAccountNode,
+				ssoName: ssoName,
+				label:   label,
+				account: account,
+			}
+
+			roles := ssoCache.Roles.GetAccountRoles(aId)
+			roleNames := []string{}
+			for roleName := range roles {
+				roleNames = append(roleNames, roleName)
+			}
+			sort.Strings(roleNames)
+			for _, roleName := range roleNames {
+				accountNode.children = append(accountNode.children, m.roleNode(ssoName, roles[roleName], "role"))
+			}
+			ssoNode.children = append(ssoNode.children, accountNode)
+		}
+		m.roots = append(m.roots, ssoNode)
+	}
+	m.flatten()
+}
+
+func (m *tuiModel) roleNode(ssoName string, rFlat *sso.AWSRoleFlat, prefix string) *tuiNode {
+	return &tuiNode{
+		id:      fmt.Sprintf("%s:%s:%s", prefix, ssoName, rFlat.Arn),
+		kind:    tuiRoleNode,
+		ssoName: ssoName,
+		label:   rFlat.RoleName,
+		role:    rFlat,
+	}
+}
+
+// flatten updates the visible rows based on the expanded nodes and search
+func (m *tuiModel) flatten() {
+	var current *tuiNode
+	if m.cursor < len(m.rows) {
+		current = m.rows[m.cursor].node
+	}
+
+	matches := map[string]bool{} // role ARNs matching the search
+	if m.search != "" {
+		for _, root := range m.roots {
+			roleTags := m.ctx.Settings.Cache.SSO[root.ssoName].Roles.GetRoleTags()
+			for _, match := range roleTags.FuzzySearch(m.search) {
+				matches[root.ssoName+":"+match.Arn] = true
+			}
+		}
+	}
+
+	m.rows = []tuiRow{}
+	var walk func(nodes []*tuiNode, depth int) bool
+	walk = func(nodes []*tuiNode, depth int) bool {
+		found := false
+		for _, node := range nodes {
+			if m.search == "" {
+				m.rows = append(m.rows, tuiRow{node, depth})
+				if m.expanded[node.id] {
+					walk(node.children, depth+1)
+				}
+				continue
+			}
+
+			// when searching only show the matching roles and their parents
+			if node.kind == tuiRoleNode {
+				if matches[node.ssoName+":"+node.role.Arn] {
+					m.rows = append(m.rows, tuiRow{node, depth})
+					found = true
+				}
+				continue
+			}
+			i := len(m.rows)
+			m.rows = append(m.rows, tuiRow{node, depth})
+			if walk(node.children, depth+1) {
+				found = true
+			} else {
+				m.rows = m.rows[:i]
+			}
+		}
+		return found
+	}
+	walk(m.roots, 0)
+
+	// keep the cursor on the same node if possible
+	m.cursor = 0
+	for i, row := range m.rows {
+		if row.node == current || (current != nil && row.node.id == current.id) {
+			m.cursor = i
+			break
+		}
+	}
+}
+
+// selected returns the node under the cursor
+func (m *tuiModel) selected() *tuiNode {
+	if m.cursor < len(m.rows) {
+		return m.rows[m.cursor].node
+	}
+	return nil
+}
+
+// handleKey processes a key press and returns true to quit
+func (m *tuiModel) handleKey(key tuiKey) bool {
+	if m.searching {
+		switch {
+		case key == tuiKeyEnter:
+			m.searching = false
+		case key == tuiKeyEsc:
+			m.searching = false
+			m.search = ""
+		case key == tuiKeyBackspace:
+			if r := []rune(m.search); len(r) > 0 {
+				m.search = string(r[:len(r)-1])
+			}
+		case key == tuiKeyCtrlC:
+			return true
+		case key >= ' ':
+			m.search += string(rune(key))
+		default:
+			return false
+		}
+		m.flatten()
+		return false
+	}
+
+	m.status = ""
+	_, height := m.term.Size()
+	page := height - 2
+	node := m.selected()
+
+	switch key {
+	case 'q', tuiKeyCtrlC:
+		return true
+	case tuiKeyUp, 'k':
+		m.move(-1)
+	case tuiKeyDown, 'j':
+		m.move(1)
+	case tuiKeyPageUp:
+		m.move(-page)
+	case tuiKeyPageDown:
+		m.move(page)
+	case tuiKeyHome, 'g':
+		m.move(-len(m.rows))
+	case tuiKeyEnd, 'G':
+		m.move(len(m.rows))
+	case tuiKeyRight, 'l':
+		if node != nil && node.kind != tuiRoleNode {
+			m.expanded[node.id] = true
+			m.flatten()
+		}
+	case tuiKeyLeft, 'h':
+		m.collapse()
+	case tuiKeyEnter, ' ':
+		if node != nil && node.kind == tuiRoleNode {
+			m.console(node)
+		} else if node != nil {
+			m.expanded[node.id] = !m.expanded[node.id]
+			m.flatten()
+		}
+	case '/':
+		m.searching = true
+	case tuiKeyEsc:
+		m.search = ""
+		m.flatten()
+	case 'c':
+		m.console(node)
+	case 'x':
+		m.exec(node)
+	case 'e':
+		m.eval(node)
+	case 'f':
+		m.flush(node)
+	case 'p':
+		m.pin(node)
+	}
+	return false
+}
+
+func (m *tuiModel) move(delta int) {
+	m.cursor += delta
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// collapse closes the selected node or moves to the parent
+func (m *tuiModel) collapse() {
+	node := m.selected()
+	if node == nil {
+		return
+	}
+	if node.kind != tuiRoleNode && m.expanded[node.id] && m.search == "" {
+		m.expanded[node.id] = false
+		m.flatten()
+		return
+	}
+	depth := m.rows[m.cursor].depth
+	for i := m.cursor - 1; i >= 0; i-- {
+		if m.rows[i].depth < depth {
+			m.cursor = i
+			return
+		}
+	}
+}
+
+// useRole makes the SSO instance of the role active and returns false if
+// the node is not a role
+func (m *tuiModel) useRole(node *tuiNode) bool {
+	if node == nil || node.kind != tuiRoleNode {
+		m.status = "Please select a role"
+		return false
+	}
+	if node.ssoName != m.activeSSO {
+		m.activeSSO = node.ssoName
+		m.ctx.Cli.SSO = node.ssoName
+		m.ctx.Settings.Cache.SetSSO(node.ssoName)
+		AwsSSO = nil // authenticate with the new SSO instance
+	}
+	return true
+}
+
+// suspend restores the terminal so a command can use it, runs the action and
+// then redraws the tree
+func (m *tuiModel) suspend(action func() error) error {
+	m.term.Close()
+	err := action()
+
+	term, terr := openTuiTerm()
+	if terr != nil {
+		log.WithError(terr).Fatalf("Unable to restore the terminal")
+	}
+	m.term = term
+	m.build()
+	return err
+}
+
+func (m *tuiModel) console(node *tuiNode) {
+	if !m.useRole(node) {
+		return
+	}
+	r := node.role
+	err := m.suspend(func() error {
+		return openConsole(m.ctx, doAuth(m.ctx), r.AccountId, r.RoleName)
+	})
+	m.result(err, "Opened console for %s", r.Arn)
+}
+
+func (m *tuiModel) exec(node *tuiNode) {
+	if !m.useRole(node) {
+		return
+	}
+	if err := checkAwsEnvironment(); err != nil {
+		m.status = err.Error()
+		return
+	}
+	r := node.role
+	m.ctx.Cli.Exec.Cmd = m.ctx.Cli.Tui.Shell
+	m.ctx.Cli.Exec.Args = []string{}
+	err := m.suspend(func() error {
+		fmt.Printf("Running %s for %s.  Exit the shell to return.\n", m.ctx.Cli.Tui.Shell, r.Arn)
+		return execCmd(m.ctx, doAuth(m.ctx), r.AccountId, r.RoleName)
+	})
+
+	var exitErr ExitCodeError
+	if errors.As(err, &exitErr) {
+		err = nil // exit status of the shell doesn't matter
+	}
+	m.result(err, "Exited %s for %s", m.ctx.Cli.Tui.Shell, r.Arn)
+}
+
+func (m *tuiModel) eval(node *tuiNode) {
+	if !m.useRole(node) {
+		return
+	}
+	r := node.role
+	err := m.suspend(func() error {
+		region := m.ctx.Settings.GetDefaultRegion(r.AccountId, r.RoleName, false)
+		envs := execShellEnvs(m.ctx, doAuth(m.ctx), r.AccountId, r.RoleName, region)
+		out, err := utils.ShellExport(utils.DetectShell(), envs)
+		if err != nil {
+			return err
+		}
+		return clipboard.WriteAll(out)
+	})
+	m.result(err, "Copied eval commands for %s to the clipboard", r.Arn)
+}
+
+func (m *tuiModel) flush(node *tuiNode) {
+	if !m.useRole(node) {
+		return
+	}
+	r := node.role
+	err := m.ctx.Store.DeleteRoleCredentials(r.Arn)
+	if err == nil {
+		err = m.ctx.Settings.Cache.SetRoleExpires(r.Arn, 0)
+	}
+	m.build()
+	m.result(err, "Flushed STS credentials for %s", r.Arn)
+}
+
+func (m *tuiModel) pin(node *tuiNode) {
+	if node == nil || node.kind != tuiRoleNode {
+		m.status = "Please select a role"
+		return
+	}
+	ssoCache := m.ctx.Settings.Cache.SSO[node.ssoName]
+	msg := "Unpinned %s"
+	if ssoCache.ToggleFavorite(node.role.Arn) {
+		msg = "Pinned %s"
+	}
+	err := m.ctx.Settings.Cache.Save(false)
+	m.build()
+	m.result(err, msg, node.role.Arn)
+}
+
+func (m *tuiModel) result(err error, format string, args ...interface{}) {
+	if err != nil {
+		m.status = "Error: " + err.Error()
+	} else {
+		m.status = fmt.Sprintf(format, args...)
+	}
+}
+
+// draw renders the tree, side panel and status line
+func (m *tuiModel) draw() {
+	width, height := m.term.Size()
+	bodyHeight := height - 2
+	if bodyHeight < 1 {
+		bodyHeight = 1
+	}
+
+	treeWidth, sideWidth := width, 0
+	if width >= TUI_MIN_SIDE_COL {
+		treeWidth = width * 55 / 100
+		sideWidth = width - treeWidth - 1
+	}
+
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+bodyHeight {
+		m.offset = m.cursor - bodyHeight + 1
+	}
+
+	side := m.details(m.selected())
+	lines := []string{ANSI_REVERSE + tuiPad(" aws-sso tui  "+TUI_HELP, width)}
+	for i := 0; i < bodyHeight; i++ {
+		line := tuiPad("", treeWidth)
+		if idx := m.offset + i; idx < len(m.rows) {
+			line = m.rowString(m.rows[idx], treeWidth)
+			if idx == m.cursor {
+				line = ANSI_REVERSE + line + ANSI_RESET
+			}
+		}
+		if sideWidth > 0 {
+			text := ""
+			if i < len(side) {
+				text = side[i]
+			}
+			line += ANSI_DIM + "│" + ANSI_RESET + tuiPad(" "+text, sideWidth)
+		}
+		lines = append(lines, line)
+	}
+
+	status := m.status
+	if m.searching {
+		status = "Search: " + m.search + "█"
+	} else if m.search != "" && status == "" {
+		status = fmt.Sprintf("Search: %s  (%d rows, Esc to clear)", m.search, len(m.rows))
+	}
+	lines = append(lines, ANSI_BOLD+tuiPad(status, width))
+	m.term.Draw(lines)
+}
+
+// rowString returns the text for a row in the tree
+func (m *tuiModel) rowString(row tuiRow, width int) string {
+	node := row.node
+	indent := strings.Repeat("  ", row.depth)
+	switch node.kind {
+	case tuiRoleNode:
+		star := "  "
+		if m.ctx.Settings.Cache.SSO[node.ssoName].IsFavorite(node.role.Arn) {
+			star = "★ "
+		}
+		expires := tuiCountdown(m.roleExpires(node))
+		left := tuiPad(indent+star+node.label, width-len(expires)-1)
+		return left + " " + expires
+
+	default:
+		marker := "▸ "
+		if m.expanded[node.id] || m.search != "" {
+			marker = "▾ "
+		}
+		return tuiPad(fmt.Sprintf("%s%s%s", indent, marker, node.label), width)
+	}
+}
+
+// roleExpires returns the current expiration time of the role
+func (m *tuiModel) roleExpires(node *tuiNode) int64 {
+	roles := m.ctx.Settings.Cache.SSO[node.ssoName].Roles
+	if a, ok := roles.Accounts[node.role.AccountId]; ok {
+		if r, ok := a.Roles[node.role.RoleName]; ok {
+			return r.Expires
+		}
+	}
+	return 0
+}
+
+// tuiCountdown returns how long until the credentials expire
+func tuiCountdown(expires int64) string {
+	if expires == 0 {
+		return ""
+	}
+	d := time.Until(time.Unix(expires, 0)).Truncate(time.Second)
+	if d <= 0 {
+		return "Expired"
+	}
+	h, mins, secs := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%dh%02dm%02ds", h, mins, secs)
+	}
+	return fmt.Sprintf("%dm%02ds", mins, secs)
+}
+
+// details returns the lines for the side panel
+func (m *tuiModel) details(node *tuiNode) []string {
+	if node == nil {
+		return []string{}
+	}
+
+	lines := []string{}
+	switch node.kind {
+	case tuiSSONode, tuiFavoritesNode:
+		lines = append(lines, "AWS SSO: "+node.ssoName)
+		if s, ok := m.ctx.Settings.SSO[node.ssoName]; ok {
+			lines = append(lines, "StartUrl: "+s.StartUrl, "SSORegion: "+s.SSORegion)
+		}
+		ssoCache := m.ctx.Settings.Cache.SSO[node.ssoName]
+		lines = append(lines,
+			fmt.Sprintf("Accounts: %d", len(ssoCache.Roles.Accounts)),
+			fmt.Sprintf("Favorites: %d", len(ssoCache.Favorites)),
+		)
+		if ssoCache.LastUpdate > 0 {
+			lines = append(lines, "Updated: "+time.Unix(ssoCache.LastUpdate, 0).Format(time.RFC1123))
+		}
+
+	case tuiAccountNode:
+		a := node.account
+		lines = append(lines,
+			"Account: "+strings.SplitN(node.label, " ", 2)[0],
+			"Alias: "+a.Alias,
+			"Name: "+a.Name,
+			"Email: "+a.EmailAddress,
+			fmt.Sprintf("Roles: %d", len(a.Roles)),
+		)
+
+	case tuiRoleNode:
+		r := node.role
+		profile, err := r.ProfileName(m.ctx.Settings)
+		if err != nil {
+			profile = err.Error()
+		}
+		expires := tuiCountdown(m.roleExpires(node))
+		if expires == "" {
+			expires = "No credentials"
+		}
+		lines = append(lines,
+			"Arn: "+r.Arn,
+			"Profile: "+profile,
+			"Region: "+r.DefaultRegion,
+			"Expires: "+expires,
+		)
+		if r.Via != "" {
+			lines = append(lines, "Via: "+r.Via)
+		}
+		lines = append(lines, "", "Tags:")
+		keys := []string{}
+		for k := range r.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			lines = append(lines, fmt.Sprintf("  %s: %s", k, r.Tags[k]))
+		}
+	}
+	return lines
+}
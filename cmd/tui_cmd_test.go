@@ -0,0 +1,335 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tuiRowLabels returns the label of each visible row indented by depth
+func tuiRowLabels(m *tuiModel) []string {
+	ret := []string{}
+	for _, row := range m.rows {
+		ret = append(ret, strings.Repeat("  ", row.depth)+row.node.label)
+	}
+	return ret
+}
+
+func TestTuiBuild(t *testing.T) {
+	m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: testSettings(t)}, "Default")
+	assert.Equal(t, []string{
+		"Default",
+		"  ★ Favorites",
+		"    Chained",
+		"  000000000001 one",
+		"  000000000002 two (Production)",
+		"  000000000003 three",
+		"  000000000010 ten",
+		"My Org",
+	}, tuiRowLabels(m))
+
+	assert.Len(t, m.roots, 2)
+	assert.Equal(t, "fav:Default:arn:aws:iam::000000000002:role/Chained", m.rows[2].node.id)
+	assert.Equal(t, tuiRoleNode, m.rows[2].node.kind)
+	assert.Equal(t, tuiAccountNode, m.rows[3].node.kind)
+
+	// roles are sorted by name
+	account := m.rows[3].node
+	assert.Equal(t, "Admin", account.children[0].label)
+	assert.Equal(t, "ReadOnly", account.children[1].label)
+
+	// no favorites node without favorites
+	for _, node := range m.roots[1].children {
+		assert.NotEqual(t, tuiFavoritesNode, node.kind)
+	}
+}
+
+func TestTuiFlatten(t *testing.T) {
+	tests := []struct {
+		name     string
+		expanded []string
+		search   string
+		expected []string
+	}{
+		{
+			name:     "collapsed",
+			expected: []string{"Default", "My Org"},
+		},
+		{
+			name:     "expanded",
+			expanded: []string{"sso:Default", "acct:Default:000000000001", "sso:My Org"},
+			expected: []string{
+				"Default",
+				"  ★ Favorites",
+				"  000000000001 one",
+				"    Admin",
+				"    ReadOnly",
+				"  000000000002 two (Production)",
+				"  000000000003 three",
+				"  000000000010 ten",
+				"My Org",
+				"  000000000004 four",
+			},
+		},
+		{
+			name:   "search ignores expanded",
+			search: "Orphan",
+			expected: []string{
+				"Default",
+				"  000000000003 three",
+				"    Orphan",
+			},
+		},
+		{
+			name:   "search tags in every instance",
+			search: "prod",
+			expected: []string{
+				"Default",
+				"  ★ Favorites",
+				"    Chained",
+				"  000000000002 two (Production)",
+				"    Chained",
+				"My Org",
+				"  000000000004 four",
+				"    Admin",
+			},
+		},
+		{
+			name:   "search every account",
+			search: "ReadOnly",
+			expected: []string{
+				"Default",
+				"  000000000001 one",
+				"    ReadOnly",
+				"  000000000010 ten",
+				"    ReadOnly",
+			},
+		},
+		{
+			name:     "no match",
+			search:   "nothing",
+			expected: []string{},
+		},
+	}
+
+	settings := testSettings(t)
+	for _, test := range tests {
+		m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: settings}, "Default")
+		m.expanded = map[string]bool{}
+		for _, id := range test.expanded {
+			m.expanded[id] = true
+		}
+		m.search = test.search
+		m.flatten()
+		assert.Equal(t, test.expected, tuiRowLabels(m), test.name)
+	}
+}
+
+func TestTuiFlattenCursor(t *testing.T) {
+	m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: testSettings(t)}, "Default")
+	m.cursor = 3 // 000000000001 one
+	m.expanded["fav:Default"] = false
+	m.flatten()
+	assert.Equal(t, 2, m.cursor)
+	assert.Equal(t, "000000000001 one", m.selected().label)
+
+	// the selected row is hidden, so go back to the top
+	m.search = "Orphan"
+	m.flatten()
+	assert.Equal(t, 0, m.cursor)
+}
+
+func TestTuiMove(t *testing.T) {
+	tests := []struct {
+		cursor   int
+		delta    int
+		expected int
+	}{
+		{0, 1, 1},
+		{1, -1, 0},
+		{0, -1, 0},
+		{7, 1, 7},
+		{2, 100, 7},
+		{4, -100, 0},
+		{3, 0, 3},
+	}
+
+	m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: testSettings(t)}, "Default") // 8 rows
+	for _, test := range tests {
+		m.cursor = test.cursor
+		m.move(test.delta)
+		assert.Equal(t, test.expected, m.cursor, "cursor=%d delta=%d", test.cursor, test.delta)
+	}
+
+	m = &tuiModel{}
+	m.move(1)
+	assert.Equal(t, 0, m.cursor)
+}
+
+func TestTuiCollapse(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   int
+		search   string
+		cursorTo int
+		rows     int
+	}{
+		{"expanded node collapses", 1, "", 1, 7},
+		{"role moves to parent", 2, "", 1, 8},
+		{"collapsed node moves to parent", 3, "", 0, 8},
+		{"top level stays", 7, "", 7, 8},
+		{"search moves to parent", 1, "prod", 0, 8},
+	}
+
+	settings := testSettings(t)
+	for _, test := range tests {
+		m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: settings}, "Default")
+		m.search = test.search
+		m.flatten()
+		m.cursor = test.cursor
+		m.collapse()
+		assert.Equal(t, test.cursorTo, m.cursor, test.name)
+		assert.Len(t, m.rows, test.rows, test.name)
+	}
+
+	m := &tuiModel{}
+	m.collapse()
+	assert.Equal(t, 0, m.cursor)
+}
+
+func TestTuiHandleKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []tuiKey
+		quit      bool
+		cursor    int
+		rows      int
+		search    string
+		searching bool
+	}{
+		{name: "quit", keys: []tuiKey{'q'}, quit: true, rows: 8},
+		{name: "ctrl-c", keys: []tuiKey{tuiKeyCtrlC}, quit: true, rows: 8},
+		{name: "down", keys: []tuiKey{tuiKeyDown, 'j'}, cursor: 2, rows: 8},
+		{name: "up", keys: []tuiKey{tuiKeyEnd, tuiKeyUp, 'k'}, cursor: 5, rows: 8},
+		{name: "end", keys: []tuiKey{'G'}, cursor: 7, rows: 8},
+		{name: "home", keys: []tuiKey{tuiKeyPageDown, 'g'}, cursor: 0, rows: 8},
+		{name: "page down", keys: []tuiKey{tuiKeyPageDown}, cursor: 7, rows: 8},
+		{name: "page up", keys: []tuiKey{'G', tuiKeyPageUp}, cursor: 0, rows: 8},
+		{name: "expand", keys: []tuiKey{'G', tuiKeyRight}, cursor: 7, rows: 9},
+		{name: "expand role", keys: []tuiKey{'j', 'j', 'l'}, cursor: 2, rows: 8},
+		{name: "collapse", keys: []tuiKey{'j', tuiKeyLeft}, cursor: 1, rows: 7},
+		{name: "toggle", keys: []tuiKey{tuiKeyEnter}, cursor: 0, rows: 2},
+		{name: "toggle twice", keys: []tuiKey{' ', ' '}, cursor: 0, rows: 8},
+		{name: "start search", keys: []tuiKey{'/'}, rows: 8, searching: true},
+		{
+			name:      "type search",
+			keys:      []tuiKey{'/', 'O', 'r', 'p', 'x', tuiKeyBackspace},
+			rows:      3,
+			search:    "Orp",
+			searching: true,
+		},
+		{name: "finish search", keys: []tuiKey{'/', 'O', 'r', 'p', tuiKeyEnter}, rows: 3, search: "Orp"},
+		{name: "cancel search", keys: []tuiKey{'G', '/', 'O', 'r', 'p', tuiKeyEsc}, cursor: 0, rows: 8},
+		{name: "clear search", keys: []tuiKey{'j', 'j', '/', 'C', 'h', tuiKeyEnter, tuiKeyEsc}, cursor: 2, rows: 8},
+		{name: "quit search", keys: []tuiKey{'/', tuiKeyCtrlC}, quit: true, rows: 8, searching: true},
+		{name: "search 'q'", keys: []tuiKey{'/', 'q'}, rows: 0, search: "q", searching: true},
+		{name: "search ignores arrows", keys: []tuiKey{'/', tuiKeyDown}, rows: 8, searching: true},
+		{name: "unknown", keys: []tuiKey{tuiKeyUnknown, 'z'}, rows: 8},
+	}
+
+	settings := testSettings(t)
+	for _, test := range tests {
+		m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: settings}, "Default")
+		quit := false
+		for _, key := range test.keys {
+			quit = m.handleKey(key)
+		}
+		assert.Equal(t, test.quit, quit, test.name)
+		assert.Equal(t, test.cursor, m.cursor, test.name)
+		assert.Len(t, m.rows, test.rows, test.name)
+		assert.Equal(t, test.search, m.search, test.name)
+		assert.Equal(t, test.searching, m.searching, test.name)
+	}
+
+	// actions need a role
+	m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: settings}, "Default")
+	m.handleKey('x')
+	assert.Equal(t, "Please select a role", m.status)
+	m.handleKey('j')
+	assert.Empty(t, m.status)
+}
+
+func TestTuiCountdown(t *testing.T) {
+	now := time.Now().Unix() + 1 // round up
+	tests := []struct {
+		expires  int64
+		expected string
+	}{
+		{0, ""},
+		{now - 60, "Expired"},
+		{now - 1, "Expired"},
+		{now + 5, "0m05s"},
+		{now + 90, "1m30s"},
+		{now + 3600, "1h00m00s"},
+		{now + 27*3600 + 61, "27h01m01s"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, tuiCountdown(test.expires), test.expected)
+	}
+}
+
+func TestTuiRowString(t *testing.T) {
+	settings := testSettings(t)
+	expires := time.Now().Unix() + 3601
+	assert.NoError(t, settings.Cache.SetRoleExpires("arn:aws:iam::000000000001:role/Admin", expires))
+	m := newTuiModel(&RunContext{Cli: &CLI{}, Settings: settings}, "Default")
+	m.expanded["acct:Default:000000000001"] = true
+	m.flatten()
+
+	tests := []struct {
+		name     string
+		row      int
+		width    int
+		expected string
+	}{
+		{"instance", 0, 20, "▾ Default           "},
+		{"favorites", 1, 20, "  ▾ ★ Favorites     "},
+		{"favorite role", 2, 20, "    ★ Chained       "},
+		{"account", 3, 24, "  ▾ 000000000001 one    "},
+		{"role with credentials", 4, 24, "      Admin     1h00m00s"},
+		{"role", 5, 15, "      ReadOnly "},
+		{"collapsed", 6, 20, "  ▸ 000000000002 tw…"},
+		{"truncated", 9, 5, "▸ My…"},
+		{"no width", 9, 0, ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, m.rowString(m.rows[test.row], test.width), test.name)
+	}
+
+	// search always shows the parents as expanded
+	m.search = "Orphan"
+	m.flatten()
+	assert.Equal(t, "▾ Default", m.rowString(m.rows[0], 9))
+}
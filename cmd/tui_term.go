@@ -0,0 +1,198 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/mattn/go-tty"
+)
+
+// ANSI escape sequences
+const (
+	ANSI_ALT_SCREEN   = "\x1b[?1049h\x1b[?25l" // and hide cursor
+	ANSI_MAIN_SCREEN  = "\x1b[?25h\x1b[?1049l" // and show cursor
+	ANSI_CLEAR_SCREEN = "\x1b[2J"
+	ANSI_HOME         = "\x1b[H"
+	ANSI_CLEAR_LINE   = "\x1b[K"
+	ANSI_RESET        = "\x1b[0m"
+	ANSI_BOLD         = "\x1b[1m"
+	ANSI_DIM          = "\x1b[2m"
+	ANSI_REVERSE      = "\x1b[7m"
+)
+
+// tuiKey is a key press.  Special keys are negative.
+type tuiKey rune
+
+const (
+	tuiKeyUp tuiKey = -1 - iota
+	tuiKeyDown
+	tuiKeyLeft
+	tuiKeyRight
+	tuiKeyPageUp
+	tuiKeyPageDown
+	tuiKeyHome
+	tuiKeyEnd
+	tuiKeyEsc
+	tuiKeyUnknown
+)
+
+const (
+	tuiKeyCtrlC     tuiKey = 0x03
+	tuiKeyBackspace tuiKey = 0x7f
+	tuiKeyEnter     tuiKey = '\r'
+)
+
+// tuiTerm is the terminal in raw mode on the alternate screen
+type tuiTerm struct {
+	tty     *tty.TTY
+	restore func() error
+}
+
+// openTuiTerm switches the terminal to raw mode and the alternate screen
+func openTuiTerm() (*tuiTerm, error) {
+	t, err := tty.Open()
+	if err != nil {
+		return nil, err
+	}
+	restore, err := t.Raw()
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	term := &tuiTerm{
+		tty:     t,
+		restore: restore,
+	}
+	term.write(ANSI_ALT_SCREEN + ANSI_CLEAR_SCREEN)
+	return term, nil
+}
+
+// Close restores the terminal
+func (t *tuiTerm) Close() {
+	t.write(ANSI_MAIN_SCREEN)
+	if err := t.restore(); err != nil {
+		log.WithError(err).Errorf("Unable to restore terminal")
+	}
+	t.tty.Close()
+}
+
+func (t *tuiTerm) write(s string) {
+	if _, err := t.tty.Output().WriteString(s); err != nil {
+		log.WithError(err).Debugf("Unable to write to terminal")
+	}
+}
+
+// Size returns the width and height of the terminal or 80x24 if unknown
+func (t *tuiTerm) Size() (int, int) {
+	if t == nil {
+		return 80, 24
+	}
+	w, h, err := t.tty.Size()
+	if err != nil || w <= 0 || h <= 0 {
+		return 80, 24
+	}
+	return w, h
+}
+
+// Draw replaces the screen with the lines
+func (t *tuiTerm) Draw(lines []string) {
+	var b strings.Builder
+	b.WriteString(ANSI_HOME)
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line + ANSI_RESET + ANSI_CLEAR_LINE)
+	}
+	t.write(b.String())
+}
+
+// ReadKey reads a key press, decoding ANSI escape sequences
+func (t *tuiTerm) ReadKey() (tuiKey, error) {
+	r, err := t.tty.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case r == 0x08:
+		return tuiKeyBackspace, nil
+	case r == '\n':
+		return tuiKeyEnter, nil
+	case r != 0x1b:
+		return tuiKey(r), nil
+	case !t.tty.Buffered():
+		return tuiKeyEsc, nil
+	}
+
+	if r, err = t.tty.ReadRune(); err != nil {
+		return 0, err
+	} else if r != '[' && r != 'O' {
+		return tuiKeyUnknown, nil
+	}
+
+	// CSI parameters until the final byte
+	params := ""
+	for {
+		if r, err = t.tty.ReadRune(); err != nil {
+			return 0, err
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		params += string(r)
+	}
+
+	switch r {
+	case 'A':
+		return tuiKeyUp, nil
+	case 'B':
+		return tuiKeyDown, nil
+	case 'C':
+		return tuiKeyRight, nil
+	case 'D':
+		return tuiKeyLeft, nil
+	case 'H':
+		return tuiKeyHome, nil
+	case 'F':
+		return tuiKeyEnd, nil
+	case '~':
+		switch params {
+		case "1", "7":
+			return tuiKeyHome, nil
+		case "4", "8":
+			return tuiKeyEnd, nil
+		case "5":
+			return tuiKeyPageUp, nil
+		case "6":
+			return tuiKeyPageDown, nil
+		}
+	}
+	return tuiKeyUnknown, nil
+}
+
+// tuiPad truncates or pads the string to exactly width cells
+func tuiPad(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	s = runewidth.Truncate(s, width, "…")
+	return runewidth.FillRight(s, width)
+}
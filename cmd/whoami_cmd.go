@@ -0,0 +1,274 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/synfinatic/aws-sso-cli/sso"
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+	"github.com/synfinatic/gotable"
+)
+
+const (
+	WHOAMI_SOURCE_ENV     = "AWS_SSO_ROLE_ARN"
+	WHOAMI_SOURCE_PROFILE = "AWS_PROFILE"
+	WHOAMI_SOURCE_KEYS    = "AWS_ACCESS_KEY_ID"
+)
+
+type WhoamiCmd struct {
+	Offline bool   `kong:"help='Only use environment variables and the local cache'"`
+	Output  string `kong:"short='o',enum='table,json',default='table',help='Output format [table|json]'"`
+
+	RoleArn         string `kong:"env='AWS_SSO_ROLE_ARN',hidden"`
+	Expiration      string `kong:"env='AWS_SSO_SESSION_EXPIRATION',hidden"`
+	AccessKeyId     string `kong:"env='AWS_ACCESS_KEY_ID',hidden"`
+	SecretAccessKey string `kong:"env='AWS_SECRET_ACCESS_KEY',hidden"`
+	SessionToken    string `kong:"env='AWS_SESSION_TOKEN',hidden"`
+	AwsProfile      string `kong:"env='AWS_PROFILE',hidden"`
+}
+
+// Whoami is the role associated with the current credentials
+type Whoami struct {
+	sso.AWSRoleFlat
+	Source    string `json:"Source"` // env var used to find the role
+	ExpiresIn string `json:"ExpiresIn,omitempty"`
+	Cached    bool   `json:"Cached"` // is the role in the aws-sso cache?
+}
+
+func (cc *WhoamiCmd) Run(ctx *RunContext) error {
+	w, err := whoami(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Cli.Whoami.Output == "json" {
+		out, err := json.MarshalIndent(w, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	printWhoami(w)
+	return nil
+}
+
+// whoami figures out which role the current credentials belong to
+func whoami(ctx *RunContext) (*Whoami, error) {
+	args := ctx.Cli.Whoami
+	w := &Whoami{}
+
+	switch {
+	case args.RoleArn != "":
+		// set by `exec` and `eval`
+		accountId, role, err := utils.ParseRoleARN(args.RoleArn)
+		if err != nil {
+			return w, err
+		}
+		w.Source = WHOAMI_SOURCE_ENV
+		w.lookup(ctx, accountId, role)
+		if args.Expiration != "" {
+			if w.Expires, err = utils.ParseTimeString(args.Expiration); err != nil {
+				return w, err
+			}
+		}
+
+	case args.AccessKeyId != "":
+		w.Source = WHOAMI_SOURCE_KEYS
+		if creds, ok := storeCredentialsByKey(ctx, args.AccessKeyId); ok {
+			w.lookup(ctx, creds.AccountId, creds.RoleName)
+			w.Expires = creds.ExpireEpoch()
+			break
+		} else if args.Offline {
+			return w, fmt.Errorf("Unable to find %s in the SecureStore", args.AccessKeyId)
+		}
+
+		stsHandle, err := stsSession(ctx, args.AccessKeyId, args.SecretAccessKey, args.SessionToken)
+		if err != nil {
+			return w, err
+		}
+		accountId, role, err := callerIdentity(stsHandle)
+		if err != nil {
+			return w, err
+		}
+		w.lookup(ctx, accountId, role)
+
+	case args.AwsProfile != "":
+		w.Source = WHOAMI_SOURCE_PROFILE
+		rFlat, err := findCachedRole(ctx, func(r *sso.Roles) (*sso.AWSRoleFlat, error) {
+			return r.GetRoleByProfile(args.AwsProfile, ctx.Settings)
+		})
+		if err == nil {
+			w.AWSRoleFlat = *rFlat
+			w.Cached = true
+			break
+		} else if args.Offline {
+			return w, err
+		}
+
+		// not one of ours, so ask STS
+		cfg, err := config.LoadDefaultConfig(context.TODO(),
+			config.WithSharedConfigProfile(args.AwsProfile))
+		if err != nil {
+			return w, err
+		}
+		accountId, role, err := callerIdentity(sts.NewFromConfig(cfg))
+		if err != nil {
+			return w, err
+		}
+		w.lookup(ctx, accountId, role)
+
+	default:
+		return w, fmt.Errorf("No AWS credentials found in the environment")
+	}
+
+	if w.Expires > 0 {
+		w.ExpiresIn, _ = utils.TimeRemain(w.Expires, false)
+	}
+	return w, nil
+}
+
+// lookup populates our AWSRoleFlat from the cache
+func (w *Whoami) lookup(ctx *RunContext, accountId int64, role string) {
+	rFlat, err := findCachedRole(ctx, func(r *sso.Roles) (*sso.AWSRoleFlat, error) {
+		return r.GetRole(accountId, role)
+	})
+	if err != nil {
+		log.Warnf("%s is not an AWS SSO role in the cache", utils.MakeRoleARN(accountId, role))
+		w.AccountId = accountId
+		w.RoleName = role
+		w.Arn = utils.MakeRoleARN(accountId, role)
+		return
+	}
+
+	w.AWSRoleFlat = *rFlat
+	w.Cached = true
+	if w.Profile, err = rFlat.ProfileName(ctx.Settings); err != nil {
+		log.WithError(err).Warnf("Unable to generate Profile for %s", rFlat.Arn)
+	}
+}
+
+// findCachedRole searches the roles of every SSO instance in the cache,
+// starting with the currently selected instance
+func findCachedRole(ctx *RunContext, match func(*sso.Roles) (*sso.AWSRoleFlat, error)) (*sso.AWSRoleFlat, error) {
+	selected := ctx.Settings.Cache.GetSSO()
+	rFlat, err := match(selected.Roles)
+	if err == nil {
+		return rFlat, nil
+	}
+
+	names := []string{}
+	for name, c := range ctx.Settings.Cache.SSO {
+		if c != selected && c.Roles != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if r, e := match(ctx.Settings.Cache.SSO[name].Roles); e == nil {
+			r.SSO = name
+			return r, nil
+		}
+	}
+	return rFlat, err
+}
+
+// storeCredentialsByKey returns the unexpired RoleCredentials in the SecureStore
+// with the given AccessKeyId
+func storeCredentialsByKey(ctx *RunContext, accessKeyId string) (storage.RoleCredentials, bool) {
+	for _, c := range ctx.Settings.Cache.SSO {
+		if c.Roles == nil {
+			continue
+		}
+		for _, rFlat := range c.Roles.GetAllRoles() {
+			if rFlat.IsExpired() {
+				continue
+			}
+			creds := storage.RoleCredentials{}
+			if err := ctx.Store.GetRoleCredentials(rFlat.Arn, &creds); err != nil {
+				continue
+			}
+			if creds.AccessKeyId == accessKeyId && !creds.Expired() {
+				return creds, true
+			}
+		}
+	}
+	return storage.RoleCredentials{}, false
+}
+
+// WhoamiField is a row in the whoami table
+type WhoamiField struct {
+	Field string `header:"Field"`
+	Value string `header:"Value"`
+}
+
+// GetHeader is required for GenerateTable()
+func (wf WhoamiField) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(wf)
+	return gotable.GetHeaderTag(v, fieldName)
+}
+
+// printWhoami generates a table of the non-empty fields of the role
+func printWhoami(w *Whoami) {
+	accountId, _ := utils.AccountIdToString(w.AccountId)
+	rows := []WhoamiField{
+		{"Source", w.Source},
+		{"SSO", w.SSO},
+		{"AccountId", accountId},
+		{"AccountName", w.AccountName},
+		{"AccountAlias", w.AccountAlias},
+		{"RoleName", w.RoleName},
+		{"Arn", w.Arn},
+		{"Profile", w.Profile},
+		{"DefaultRegion", w.DefaultRegion},
+		{"Expires", w.ExpiresIn},
+	}
+
+	keys := []string{}
+	for k := range w.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rows = append(rows, WhoamiField{"Tag:" + k, w.Tags[k]})
+	}
+
+	ts := []gotable.TableStruct{}
+	for _, row := range rows {
+		if row.Value != "" {
+			ts = append(ts, row)
+		}
+	}
+
+	fields := []string{"Field", "Value"}
+	if err := gotable.GenerateTable(ts, fields); err != nil {
+		log.WithError(err).Errorf("Unable to generate report")
+	}
+	fmt.Printf("\n")
+}
@@ -0,0 +1,54 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Reference helper for `SecureStore: exec`.  Reads a single JSON request on
+ * stdin, writes a single JSON response on stdout and stores everything in
+ * the (cleartext!) JSON file passed as the first argument.  Real helpers
+ * should talk to a vault agent or similar instead.
+ *
+ * ExecStoreCommand:
+ *     - /path/to/exec-store-helper
+ *     - /path/to/helper-store.json
+ */
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/synfinatic/aws-sso-cli/storage"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <store file>\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	helper, err := storage.NewExecStoreHelper(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open %s: %s\n", os.Args[1], err.Error())
+		os.Exit(1)
+	}
+
+	if err = helper.Handle(os.Stdin, os.Stdout); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	ENV_CONTAINER_FULL_URI   = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+	ENV_CONTAINER_AUTH_TOKEN = "AWS_CONTAINER_AUTHORIZATION_TOKEN" // #nosec
+
+	ECS_PROFILE_PREFIX = "/profile/"
+	ECS_ARN_PREFIX     = "/arn/"
+)
+
+// CredentialsFunc returns valid RoleCredentials for the role ARN, refreshing
+// them via AWS SSO if necessary
+type CredentialsFunc func(arn string) (storage.RoleCredentials, error)
+
+// ResolveFunc returns the role ARN for the given AWS profile name
+type ResolveFunc func(profile string) (string, error)
+
+// ECSCredentials is the JSON format used by the ECS container credentials provider
+type ECSCredentials struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"` // RFC3339
+	RoleArn         string `json:"RoleArn"`
+}
+
+// NewECSCredentials converts our RoleCredentials into the ECS format
+func NewECSCredentials(creds storage.RoleCredentials) ECSCredentials {
+	return ECSCredentials{
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.ExpireISO8601(),
+		RoleArn:         creds.RoleArn(),
+	}
+}
+
+// ECSServer serves role credentials like the ECS container credentials endpoint.
+// Roles are selected via /profile/<profile> or /arn/<arn>, while / returns the
+// default role (if any).
+type ECSServer struct {
+	authToken  string
+	defaultArn string
+	resolve    ResolveFunc
+	creds      CredentialsFunc
+	server     *http.Server
+}
+
+// NewECSServer creates a new ECSServer.  Every request must provide the authToken
+// in the Authorization header.
+func NewECSServer(authToken, defaultArn string, resolve ResolveFunc, creds CredentialsFunc) *ECSServer {
+	e := &ECSServer{
+		authToken:  authToken,
+		defaultArn: defaultArn,
+		resolve:    resolve,
+		creds:      creds,
+	}
+	e.server = &http.Server{Handler: e}
+	return e
+}
+
+// Serve handles requests on the listener until Close() is called
+func (e *ECSServer) Serve(l net.Listener) error {
+	err := e.server.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server
+func (e *ECSServer) Close() error {
+	return e.server.Close()
+}
+
+// ServeHTTP implements http.Handler
+func (e *ECSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(e.authToken)) != 1 {
+		log.Warnf("Rejecting request for %s with invalid Authorization", r.URL.Path)
+		http.Error(w, "Invalid Authorization", http.StatusUnauthorized)
+		return
+	}
+
+	arn, err := e.roleArn(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	creds, err := e.creds(arn)
+	if err != nil {
+		log.WithError(err).Errorf("Unable to get credentials for %s", arn)
+		http.Error(w, fmt.Sprintf("Unable to get credentials for %s", arn), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(NewECSCredentials(creds)); err != nil {
+		log.WithError(err).Errorf("Unable to write response")
+	}
+}
+
+// roleArn returns the role ARN for the URL path
+func (e *ECSServer) roleArn(path string) (string, error) {
+	switch {
+	case path == "/" || path == "":
+		if e.defaultArn == "" {
+			return "", fmt.Errorf("No default role configured")
+		}
+		return e.defaultArn, nil
+
+	case strings.HasPrefix(path, ECS_PROFILE_PREFIX):
+		return e.resolve(strings.TrimPrefix(path, ECS_PROFILE_PREFIX))
+
+	case strings.HasPrefix(path, ECS_ARN_PREFIX):
+		arn := strings.TrimPrefix(path, ECS_ARN_PREFIX)
+		if _, _, err := utils.ParseRoleARN(arn); err != nil {
+			return "", err
+		}
+		return arn, nil
+	}
+	return "", fmt.Errorf("Invalid path: %s", path)
+}
+
+// NewAuthToken returns a random token for use with the Authorization header
+func NewAuthToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ListenLocal listens on the Unix socket if provided, otherwise on the TCP
+// address which must be a loopback address.  Link-local addresses such as
+// 169.254.169.254 are refused since they are reachable by other hosts and
+// containers on the local network.
+func ListenLocal(addr, socket string) (net.Listener, error) {
+	if socket != "" {
+		if err := utils.EnsureDirExists(socket); err != nil {
+			return nil, err
+		}
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		return l, os.Chmod(socket, 0600)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("Refusing to listen on non-local address: %s", addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,181 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	TEST_AUTH_TOKEN = "not-a-real-token"
+	TEST_ROLE_ARN   = "arn:aws:iam::000012345678:role/MyRole"
+	TEST_OTHER_ARN  = "arn:aws:iam::000012345678:role/OtherRole"
+)
+
+func testResolve(profile string) (string, error) {
+	if profile == "myprofile" {
+		return TEST_OTHER_ARN, nil
+	}
+	return "", fmt.Errorf("Unknown profile: %s", profile)
+}
+
+func testCreds(arn string) (storage.RoleCredentials, error) {
+	accountId, role, err := utils.ParseRoleARN(arn)
+	if err != nil {
+		return storage.RoleCredentials{}, err
+	}
+	if role == "Broken" {
+		return storage.RoleCredentials{}, fmt.Errorf("unable to get creds")
+	}
+	return storage.RoleCredentials{
+		RoleName:        role,
+		AccountId:       accountId,
+		AccessKeyId:     "AKIA" + role,
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}, nil
+}
+
+func ecsRequest(e *ECSServer, method, path, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestECSServer(t *testing.T) {
+	e := NewECSServer(TEST_AUTH_TOKEN, TEST_ROLE_ARN, testResolve, testCreds)
+
+	w := ecsRequest(e, http.MethodGet, "/", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	creds := ECSCredentials{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &creds))
+	assert.Equal(t, "AKIAMyRole", creds.AccessKeyId)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+	assert.Equal(t, "token", creds.Token)
+	assert.Equal(t, TEST_ROLE_ARN, creds.RoleArn)
+	_, err := time.Parse(time.RFC3339, creds.Expiration)
+	assert.NoError(t, err)
+
+	w = ecsRequest(e, http.MethodGet, "/profile/myprofile", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &creds))
+	assert.Equal(t, TEST_OTHER_ARN, creds.RoleArn)
+
+	w = ecsRequest(e, http.MethodGet, "/arn/"+TEST_OTHER_ARN, TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &creds))
+	assert.Equal(t, "AKIAOtherRole", creds.AccessKeyId)
+
+	// errors
+	w = ecsRequest(e, http.MethodGet, "/", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = ecsRequest(e, http.MethodGet, "/", "wrong-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = ecsRequest(e, http.MethodPost, "/", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = ecsRequest(e, http.MethodGet, "/profile/missing", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = ecsRequest(e, http.MethodGet, "/arn/not-an-arn", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = ecsRequest(e, http.MethodGet, "/something", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = ecsRequest(e, http.MethodGet, "/arn/arn:aws:iam::000012345678:role/Broken", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// no default role
+	e = NewECSServer(TEST_AUTH_TOKEN, "", testResolve, testCreds)
+	w = ecsRequest(e, http.MethodGet, "/", TEST_AUTH_TOKEN)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestECSServerListen(t *testing.T) {
+	l, err := ListenLocal("127.0.0.1:0", "")
+	assert.NoError(t, err)
+
+	e := NewECSServer(TEST_AUTH_TOKEN, TEST_ROLE_ARN, testResolve, testCreds)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Serve(l)
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/", l.Addr().String()), nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", TEST_AUTH_TOKEN)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.NoError(t, e.Close())
+	assert.NoError(t, <-done)
+}
+
+func TestListenLocal(t *testing.T) {
+	_, err := ListenLocal("0.0.0.0:0", "")
+	assert.Error(t, err)
+	_, err = ListenLocal("192.0.2.1:0", "")
+	assert.Error(t, err)
+	_, err = ListenLocal("169.254.169.254:0", "")
+	assert.Error(t, err)
+	_, err = ListenLocal("no-port", "")
+	assert.Error(t, err)
+
+	l, err := ListenLocal("localhost:0", "")
+	assert.NoError(t, err)
+	l.Close()
+
+	d, err := os.MkdirTemp("", "test-ecs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	socket := path.Join(d, "ecs", "ecs.sock")
+	l, err = ListenLocal("", socket)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestNewAuthToken(t *testing.T) {
+	a, err := NewAuthToken()
+	assert.NoError(t, err)
+	b, err := NewAuthToken()
+	assert.NoError(t, err)
+	assert.Len(t, a, 64)
+	assert.NotEqual(t, a, b)
+}
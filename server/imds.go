@@ -0,0 +1,334 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/storage"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	ENV_EC2_METADATA_ENDPOINT = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+
+	IMDS_TOKEN_PATH       = "/latest/api/token"
+	IMDS_TOKEN_HEADER     = "X-aws-ec2-metadata-token"             // #nosec
+	IMDS_TOKEN_TTL_HEADER = "X-aws-ec2-metadata-token-ttl-seconds" // #nosec
+	IMDS_MAX_TOKEN_TTL    = 21600
+	IMDS_CREDS_PATH       = "/latest/meta-data/iam/security-credentials/"
+	IMDS_INFO_PATH        = "/latest/meta-data/iam/info"
+	IMDS_REGION_PATH      = "/latest/meta-data/placement/region"
+	IMDS_AZ_PATH          = "/latest/meta-data/placement/availability-zone"
+	IMDS_INSTANCE_ID_PATH = "/latest/meta-data/instance-id"
+	IMDS_IDENTITY_PATH    = "/latest/dynamic/instance-identity/document"
+
+	IMDS_INSTANCE_ID   = "i-00000000000000000"
+	IMDS_INSTANCE_TYPE = "t3.micro"
+	IMDS_IMAGE_ID      = "ami-00000000000000000"
+
+	// rotate creds this long before they expire so clients never see
+	// creds which are about to expire
+	ROTATE_WINDOW = 15 * time.Minute
+)
+
+// IMDSCredentials is the format of the IMDS security-credentials endpoint
+type IMDSCredentials struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// IMDSInfo is the format of the IMDS iam/info endpoint
+type IMDSInfo struct {
+	Code               string `json:"Code"`
+	LastUpdated        string `json:"LastUpdated"`
+	InstanceProfileArn string `json:"InstanceProfileArn"`
+	InstanceProfileId  string `json:"InstanceProfileId"`
+}
+
+// IMDSIdentity is the format of the instance-identity document
+type IMDSIdentity struct {
+	AccountId        string `json:"accountId"`
+	Architecture     string `json:"architecture"`
+	AvailabilityZone string `json:"availabilityZone"`
+	ImageId          string `json:"imageId"`
+	InstanceId       string `json:"instanceId"`
+	InstanceType     string `json:"instanceType"`
+	PendingTime      string `json:"pendingTime"`
+	PrivateIp        string `json:"privateIp"`
+	Region           string `json:"region"`
+	Version          string `json:"version"`
+}
+
+// IMDSServer emulates the IMDSv2 endpoints of the EC2 instance metadata service
+// needed by the AWS SDKs to get credentials for a single role
+type IMDSServer struct {
+	arn         string
+	accountId   int64
+	roleName    string
+	region      string
+	started     time.Time
+	getCreds    CredentialsFunc
+	creds       storage.RoleCredentials
+	lastUpdated time.Time
+	tokens      map[string]time.Time // token => expires
+	mutex       sync.Mutex
+	server      *http.Server
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewIMDSServer creates a new IMDSServer for the role in the given region
+func NewIMDSServer(arn, region string, creds CredentialsFunc) (*IMDSServer, error) {
+	accountId, roleName, err := utils.ParseRoleARN(arn)
+	if err != nil {
+		return nil, err
+	}
+	i := &IMDSServer{
+		arn:       arn,
+		accountId: accountId,
+		roleName:  roleName,
+		region:    region,
+		started:   time.Now(),
+		getCreds:  creds,
+		tokens:    map[string]time.Time{},
+		stop:      make(chan struct{}),
+	}
+	i.server = &http.Server{Handler: i}
+	return i, nil
+}
+
+// Serve handles requests on the listener and rotates the credentials in the
+// background until Close() is called
+func (i *IMDSServer) Serve(l net.Listener) error {
+	go i.rotate()
+	err := i.server.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server and may be called more than once
+func (i *IMDSServer) Close() error {
+	i.stopOnce.Do(func() { close(i.stop) })
+	return i.server.Close()
+}
+
+// rotate refreshes our credentials before they expire
+func (i *IMDSServer) rotate() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.stop:
+			return
+		case <-ticker.C:
+			if _, _, err := i.credentials(); err != nil {
+				log.WithError(err).Errorf("Unable to rotate credentials for %s", i.arn)
+			}
+		}
+	}
+}
+
+// credentials returns our current credentials, rotating them if necessary
+func (i *IMDSServer) credentials() (storage.RoleCredentials, time.Time, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if NeedsRotation(i.creds) {
+		creds, err := i.getCreds(i.arn)
+		if err != nil {
+			return creds, i.lastUpdated, err
+		}
+		i.creds = creds
+		i.lastUpdated = time.Now()
+		log.Debugf("Rotated credentials for %s", i.arn)
+	}
+	return i.creds, i.lastUpdated, nil
+}
+
+// NeedsRotation returns if the creds expire within the ROTATE_WINDOW
+func NeedsRotation(creds storage.RoleCredentials) bool {
+	return time.UnixMilli(creds.Expiration).Before(time.Now().Add(ROTATE_WINDOW))
+}
+
+// newToken creates a new session token valid for ttl seconds
+func (i *IMDSServer) newToken(ttl int) (string, error) {
+	token, err := NewAuthToken()
+	if err != nil {
+		return "", err
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	for t, expires := range i.tokens {
+		if expires.Before(now) {
+			delete(i.tokens, t)
+		}
+	}
+	i.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	return token, nil
+}
+
+// validToken returns if the token exists and has not expired
+func (i *IMDSServer) validToken(token string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	expires, ok := i.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+// ServeHTTP implements http.Handler
+func (i *IMDSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// like the real IMDS, refuse anything which was proxied
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path == IMDS_TOKEN_PATH {
+		i.serveToken(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// IMDSv2 only
+	if !i.validToken(r.Header.Get(IMDS_TOKEN_HEADER)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case IMDS_CREDS_PATH:
+		writeText(w, i.roleName)
+
+	case IMDS_CREDS_PATH + i.roleName:
+		creds, updated, err := i.credentials()
+		if err != nil {
+			log.WithError(err).Errorf("Unable to get credentials for %s", i.arn)
+			http.Error(w, "Unable to get credentials", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, IMDSCredentials{
+			Code:            "Success",
+			LastUpdated:     updated.UTC().Format(time.RFC3339),
+			Type:            "AWS-HMAC",
+			AccessKeyId:     creds.AccessKeyId,
+			SecretAccessKey: creds.SecretAccessKey,
+			Token:           creds.SessionToken,
+			Expiration:      time.UnixMilli(creds.Expiration).UTC().Format(time.RFC3339),
+		})
+
+	case IMDS_INFO_PATH:
+		writeJSON(w, IMDSInfo{
+			Code:               "Success",
+			LastUpdated:        i.started.UTC().Format(time.RFC3339),
+			InstanceProfileArn: strings.Replace(i.arn, ":role/", ":instance-profile/", 1),
+			InstanceProfileId:  "AIPAAWSSSOCLIEMULATED",
+		})
+
+	case IMDS_REGION_PATH:
+		writeText(w, i.region)
+
+	case IMDS_AZ_PATH:
+		writeText(w, i.availabilityZone())
+
+	case IMDS_INSTANCE_ID_PATH:
+		writeText(w, IMDS_INSTANCE_ID)
+
+	case IMDS_IDENTITY_PATH:
+		accountId, _ := utils.AccountIdToString(i.accountId)
+		writeJSON(w, IMDSIdentity{
+			AccountId:        accountId,
+			Architecture:     "x86_64",
+			AvailabilityZone: i.availabilityZone(),
+			ImageId:          IMDS_IMAGE_ID,
+			InstanceId:       IMDS_INSTANCE_ID,
+			InstanceType:     IMDS_INSTANCE_TYPE,
+			PendingTime:      i.started.UTC().Format(time.RFC3339),
+			PrivateIp:        "127.0.0.1",
+			Region:           i.region,
+			Version:          "2017-09-30",
+		})
+
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// serveToken implements the IMDSv2 session token handshake
+func (i *IMDSServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Header.Get(IMDS_TOKEN_TTL_HEADER))
+	if err != nil || ttl < 1 || ttl > IMDS_MAX_TOKEN_TTL {
+		http.Error(w, "Invalid token TTL", http.StatusBadRequest)
+		return
+	}
+
+	token, err := i.newToken(ttl)
+	if err != nil {
+		http.Error(w, "Unable to create token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(IMDS_TOKEN_TTL_HEADER, strconv.Itoa(ttl))
+	writeText(w, token)
+}
+
+func (i *IMDSServer) availabilityZone() string {
+	return fmt.Sprintf("%sa", i.region)
+}
+
+func writeText(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "text/plain")
+	if _, err := w.Write([]byte(text)); err != nil {
+		log.WithError(err).Errorf("Unable to write response")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Errorf("Unable to write response")
+	}
+}
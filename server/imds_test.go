@@ -0,0 +1,219 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/storage"
+)
+
+func imdsRequest(i *IMDSServer, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	i.ServeHTTP(w, r)
+	return w
+}
+
+func imdsToken(t *testing.T, i *IMDSServer, ttl string) string {
+	w := imdsRequest(i, http.MethodPut, IMDS_TOKEN_PATH, map[string]string{IMDS_TOKEN_TTL_HEADER: ttl})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ttl, w.Header().Get(IMDS_TOKEN_TTL_HEADER))
+	return w.Body.String()
+}
+
+func TestIMDSServer(t *testing.T) {
+	i, err := NewIMDSServer(TEST_ROLE_ARN, "us-west-2", testCreds)
+	assert.NoError(t, err)
+
+	token := imdsToken(t, i, "21600")
+	auth := map[string]string{IMDS_TOKEN_HEADER: token}
+
+	w := imdsRequest(i, http.MethodGet, IMDS_CREDS_PATH, auth)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MyRole", w.Body.String())
+
+	w = imdsRequest(i, http.MethodGet, IMDS_CREDS_PATH+"MyRole", auth)
+	assert.Equal(t, http.StatusOK, w.Code)
+	creds := IMDSCredentials{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &creds))
+	assert.Equal(t, "Success", creds.Code)
+	assert.Equal(t, "AWS-HMAC", creds.Type)
+	assert.Equal(t, "AKIAMyRole", creds.AccessKeyId)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+	assert.Equal(t, "token", creds.Token)
+	_, err = time.Parse(time.RFC3339, creds.Expiration)
+	assert.NoError(t, err)
+
+	w = imdsRequest(i, http.MethodGet, IMDS_INFO_PATH, auth)
+	assert.Equal(t, http.StatusOK, w.Code)
+	info := IMDSInfo{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, "arn:aws:iam::000012345678:instance-profile/MyRole", info.InstanceProfileArn)
+
+	w = imdsRequest(i, http.MethodGet, IMDS_REGION_PATH, auth)
+	assert.Equal(t, "us-west-2", w.Body.String())
+	w = imdsRequest(i, http.MethodGet, IMDS_AZ_PATH, auth)
+	assert.Equal(t, "us-west-2a", w.Body.String())
+	w = imdsRequest(i, http.MethodGet, IMDS_INSTANCE_ID_PATH, auth)
+	assert.Equal(t, IMDS_INSTANCE_ID, w.Body.String())
+
+	w = imdsRequest(i, http.MethodGet, IMDS_IDENTITY_PATH, auth)
+	assert.Equal(t, http.StatusOK, w.Code)
+	doc := IMDSIdentity{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "000012345678", doc.AccountId)
+	assert.Equal(t, "us-west-2", doc.Region)
+	assert.Equal(t, "us-west-2a", doc.AvailabilityZone)
+
+	// errors
+	w = imdsRequest(i, http.MethodGet, IMDS_CREDS_PATH+"MyRole", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = imdsRequest(i, http.MethodGet, IMDS_CREDS_PATH+"MyRole", map[string]string{IMDS_TOKEN_HEADER: "invalid"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = imdsRequest(i, http.MethodGet, IMDS_CREDS_PATH+"OtherRole", auth)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = imdsRequest(i, http.MethodPost, IMDS_CREDS_PATH, auth)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = imdsRequest(i, http.MethodGet, IMDS_TOKEN_PATH, auth)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = imdsRequest(i, http.MethodPut, IMDS_TOKEN_PATH, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = imdsRequest(i, http.MethodPut, IMDS_TOKEN_PATH, map[string]string{IMDS_TOKEN_TTL_HEADER: "21601"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = imdsRequest(i, http.MethodPut, IMDS_TOKEN_PATH, map[string]string{
+		IMDS_TOKEN_TTL_HEADER: "60",
+		"X-Forwarded-For":     "192.0.2.1",
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	_, err = NewIMDSServer("not-an-arn", "us-west-2", testCreds)
+	assert.Error(t, err)
+
+	i, err = NewIMDSServer("arn:aws:iam::000012345678:role/Broken", "us-west-2", testCreds)
+	assert.NoError(t, err)
+	token = imdsToken(t, i, "60")
+	w = imdsRequest(i, http.MethodGet, IMDS_CREDS_PATH+"Broken", map[string]string{IMDS_TOKEN_HEADER: token})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestIMDSTokenExpires(t *testing.T) {
+	i, err := NewIMDSServer(TEST_ROLE_ARN, "us-west-2", testCreds)
+	assert.NoError(t, err)
+
+	token := imdsToken(t, i, "60")
+	assert.True(t, i.validToken(token))
+
+	i.mutex.Lock()
+	i.tokens[token] = time.Now().Add(-time.Second)
+	i.mutex.Unlock()
+	assert.False(t, i.validToken(token))
+
+	// expired tokens are purged when creating new ones
+	imdsToken(t, i, "60")
+	i.mutex.Lock()
+	_, ok := i.tokens[token]
+	i.mutex.Unlock()
+	assert.False(t, ok)
+}
+
+func TestIMDSRotation(t *testing.T) {
+	calls := 0
+	expires := time.Now().Add(ROTATE_WINDOW / 2)
+	creds := func(arn string) (storage.RoleCredentials, error) {
+		calls++
+		return storage.RoleCredentials{
+			AccessKeyId: fmt.Sprintf("AKIA%d", calls),
+			Expiration:  expires.UnixMilli(),
+		}, nil
+	}
+
+	i, err := NewIMDSServer(TEST_ROLE_ARN, "us-west-2", creds)
+	assert.NoError(t, err)
+
+	// creds which expire within the ROTATE_WINDOW are always rotated
+	c, _, err := i.credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA1", c.AccessKeyId)
+	c, _, err = i.credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA2", c.AccessKeyId)
+
+	// otherwise they are re-used
+	expires = time.Now().Add(time.Hour)
+	c, _, err = i.credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA3", c.AccessKeyId)
+	c, _, err = i.credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA3", c.AccessKeyId)
+
+	assert.True(t, NeedsRotation(storage.RoleCredentials{}))
+	assert.False(t, NeedsRotation(storage.RoleCredentials{Expiration: expires.UnixMilli()}))
+}
+
+func TestIMDSServerListen(t *testing.T) {
+	l, err := ListenLocal("127.0.0.1:0", "")
+	assert.NoError(t, err)
+
+	i, err := NewIMDSServer(TEST_ROLE_ARN, "us-west-2", testCreds)
+	assert.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- i.Serve(l)
+	}()
+
+	url := fmt.Sprintf("http://%s", l.Addr().String())
+	req, err := http.NewRequest(http.MethodPut, url+IMDS_TOKEN_PATH, nil)
+	assert.NoError(t, err)
+	req.Header.Set(IMDS_TOKEN_TTL_HEADER, "60")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	token, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	req, err = http.NewRequest(http.MethodGet, url+IMDS_CREDS_PATH, nil)
+	assert.NoError(t, err)
+	req.Header.Set(IMDS_TOKEN_HEADER, string(token))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "MyRole", strings.TrimSpace(string(body)))
+
+	assert.NoError(t, i.Close())
+	assert.NoError(t, <-done)
+
+	// e.g. a deferred Close() after the signal handler
+	assert.NotPanics(t, func() { _ = i.Close() })
+}
@@ -0,0 +1,134 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	DEFAULT_BROWSER_SESSION_NAME  = "{{ .Profile }}"
+	DEFAULT_BROWSER_SESSION_COLOR = `{{ index .Tags "Color" }}`
+	DEFAULT_BROWSER_SESSION_ICON  = `{{ index .Tags "Icon" }}`
+	DEFAULT_BROWSER_SESSION_DIR   = "~/.aws-sso/chromium/{{ .SafeName }}"
+)
+
+// BrowserSession configures the isolated browser session the AWS Console
+// is opened in for each role.  Name, Color, Icon and UserDataDir are templates.
+type BrowserSession struct {
+	Mode        string `koanf:"Mode" yaml:"Mode,omitempty"`               // none, firefox-container or chromium-profile
+	Name        string `koanf:"Name" yaml:"Name,omitempty"`               // Firefox container name
+	Color       string `koanf:"Color" yaml:"Color,omitempty"`             // Firefox container color
+	Icon        string `koanf:"Icon" yaml:"Icon,omitempty"`               // Firefox container icon
+	UserDataDir string `koanf:"UserDataDir" yaml:"UserDataDir,omitempty"` // Chromium --user-data-dir
+}
+
+// browserSessionVars are the variables available to the BrowserSession templates
+type browserSessionVars struct {
+	*AWSRoleFlat
+	Profile  string // ProfileName of the role
+	Name     string // the rendered Name
+	SafeName string // Name with only characters which are safe for a path
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ValidBrowserSessionMode returns an error if the mode is not supported
+func ValidBrowserSessionMode(mode string) error {
+	switch mode {
+	case "", utils.BROWSER_SESSION_NONE, utils.BROWSER_SESSION_FIREFOX, utils.BROWSER_SESSION_CHROMIUM:
+		return nil
+	}
+	return fmt.Errorf("Invalid browser session mode: %s.  Must be one of: %s, %s, %s",
+		mode, utils.BROWSER_SESSION_NONE, utils.BROWSER_SESSION_FIREFOX, utils.BROWSER_SESSION_CHROMIUM)
+}
+
+// GetBrowserSession renders the BrowserSession templates for the role.  The
+// mode overrides the configured Mode when set.
+func (s *Settings) GetBrowserSession(mode string, r *AWSRoleFlat) (*utils.BrowserSession, error) {
+	if mode == "" {
+		mode = s.BrowserSession.Mode
+	}
+	if err := ValidBrowserSessionMode(mode); err != nil {
+		return nil, err
+	}
+
+	session := &utils.BrowserSession{Mode: mode}
+	if mode == "" || mode == utils.BROWSER_SESSION_NONE {
+		return session, nil
+	}
+
+	profile, err := r.ProfileName(s)
+	if err != nil {
+		return nil, err
+	}
+	vars := browserSessionVars{
+		AWSRoleFlat: r,
+		Profile:     profile,
+	}
+
+	if vars.Name, err = renderBrowserSession("Name", s.BrowserSession.Name, DEFAULT_BROWSER_SESSION_NAME, vars); err != nil {
+		return nil, err
+	}
+	if vars.Name == "" {
+		vars.Name = r.Arn
+	}
+	vars.SafeName = strings.Trim(unsafeNameChars.ReplaceAllString(vars.Name, "_"), "_")
+	session.Name = vars.Name
+
+	switch mode {
+	case utils.BROWSER_SESSION_FIREFOX:
+		if session.Color, err = renderBrowserSession("Color", s.BrowserSession.Color, DEFAULT_BROWSER_SESSION_COLOR, vars); err != nil {
+			return nil, err
+		}
+		if session.Icon, err = renderBrowserSession("Icon", s.BrowserSession.Icon, DEFAULT_BROWSER_SESSION_ICON, vars); err != nil {
+			return nil, err
+		}
+	case utils.BROWSER_SESSION_CHROMIUM:
+		if session.UserDataDir, err = renderBrowserSession("UserDataDir", s.BrowserSession.UserDataDir, DEFAULT_BROWSER_SESSION_DIR, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// renderBrowserSession renders the BrowserSession template or the default
+func renderBrowserSession(name, format, defaultFormat string, vars browserSessionVars) (string, error) {
+	if format == "" {
+		format = defaultFormat
+	}
+
+	templ, err := template.New(name).Funcs(TemplateFuncMap()).Parse(format)
+	if err != nil {
+		return "", fmt.Errorf("Invalid BrowserSession %s: %s", name, err.Error())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := templ.Execute(buf, vars); err != nil {
+		return "", fmt.Errorf("Unable to render BrowserSession %s: %s", name, err.Error())
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
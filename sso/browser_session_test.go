@@ -0,0 +1,86 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+func TestGetBrowserSession(t *testing.T) {
+	s := &Settings{}
+	r := &AWSRoleFlat{
+		AccountId:    123456,
+		AccountAlias: "dev",
+		Arn:          "arn:aws:iam::000000123456:role/Admin",
+		RoleName:     "Admin",
+		Tags: map[string]string{
+			"Color": "red",
+		},
+	}
+
+	session, err := s.GetBrowserSession("", r)
+	assert.NoError(t, err)
+	assert.Equal(t, &utils.BrowserSession{}, session)
+
+	session, err = s.GetBrowserSession(utils.BROWSER_SESSION_FIREFOX, r)
+	assert.NoError(t, err)
+	assert.Equal(t, &utils.BrowserSession{
+		Mode:  utils.BROWSER_SESSION_FIREFOX,
+		Name:  "000000123456:Admin",
+		Color: "red",
+	}, session)
+
+	session, err = s.GetBrowserSession(utils.BROWSER_SESSION_CHROMIUM, r)
+	assert.NoError(t, err)
+	assert.Equal(t, &utils.BrowserSession{
+		Mode:        utils.BROWSER_SESSION_CHROMIUM,
+		Name:        "000000123456:Admin",
+		UserDataDir: "~/.aws-sso/chromium/000000123456_Admin",
+	}, session)
+
+	// configured templates
+	s.BrowserSession = BrowserSession{
+		Mode:        utils.BROWSER_SESSION_CHROMIUM,
+		Name:        "{{ .AccountAlias }}/{{ .RoleName }}",
+		UserDataDir: "/tmp/{{ .AccountId }}/{{ .SafeName }}",
+	}
+	session, err = s.GetBrowserSession("", r)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev/Admin", session.Name)
+	assert.Equal(t, "/tmp/123456/dev_Admin", session.UserDataDir)
+
+	s.BrowserSession.Icon = `{{ index .Tags "Icon" | default "briefcase" }}`
+	session, err = s.GetBrowserSession(utils.BROWSER_SESSION_FIREFOX, r)
+	assert.NoError(t, err)
+	assert.Equal(t, "briefcase", session.Icon)
+
+	s.BrowserSession.Name = "{{ .Missing }}"
+	_, err = s.GetBrowserSession("", r)
+	assert.Error(t, err)
+
+	s.BrowserSession.Name = "{{ .Profile"
+	_, err = s.GetBrowserSession("", r)
+	assert.Error(t, err)
+
+	_, err = s.GetBrowserSession("invalid", r)
+	assert.Error(t, err)
+}
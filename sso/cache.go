@@ -35,6 +35,7 @@ const CACHE_VERSION = 3
 type SSOCache struct {
 	LastUpdate int64    `json:"LastUpdate,omitempty"` // when these records for this SSO were updated
 	History    []string `json:"History,omitempty"`
+	Favorites  []string `json:"Favorites,omitempty"` // role ARNs pinned by the user
 	Roles      *Roles   `json:"Roles,omitempty"`
 	name       string   // name of this SSO Instance
 }
@@ -94,6 +95,34 @@ func (c *Cache) GetSSO() *SSOCache {
 	return c.SSO[c.ssoName]
 }
 
+// SetSSO changes the active SSO instance returned by GetSSO
+func (c *Cache) SetSSO(name string) {
+	c.ssoName = name
+}
+
+// IsFavorite returns if the role ARN is a favorite
+func (s *SSOCache) IsFavorite(arn string) bool {
+	for _, f := range s.Favorites {
+		if f == arn {
+			return true
+		}
+	}
+	return false
+}
+
+// ToggleFavorite adds or removes the role ARN from the favorites and returns
+// if it is now a favorite.  Does not save to disk.
+func (s *SSOCache) ToggleFavorite(arn string) bool {
+	for i, f := range s.Favorites {
+		if f == arn {
+			s.Favorites = append(s.Favorites[:i], s.Favorites[i+1:]...)
+			return false
+		}
+	}
+	s.Favorites = append(s.Favorites, arn)
+	return true
+}
+
 // Expired returns if our Roles cache data is too old.
 // If configFile is a valid file, we check the lastModificationTime of that file
 // vs. the ConfigCreatedAt to determine if the cache needs to be updated
@@ -331,6 +360,52 @@ func (c *Cache) MarkRolesExpired() error {
 	return c.Save(false)
 }
 
+// ExpiredRoleArns returns the ARNs of the roles in every SSO instance which
+// have an expired Expires time, meaning there are stale creds in the SecureStore
+func (c *Cache) ExpiredRoleArns() []string {
+	arns := []string{}
+	now := time.Now().Unix()
+	for _, cache := range c.SSO {
+		if cache.Roles == nil {
+			continue
+		}
+		for _, account := range cache.Roles.Accounts {
+			for _, role := range account.Roles {
+				if role.Expires != 0 && role.Expires <= now {
+					arns = append(arns, role.Arn)
+				}
+			}
+		}
+	}
+	return arns
+}
+
+// ResetRolesExpires clears the Expires time of the given roles in every SSO instance
+func (c *Cache) ResetRolesExpires(arns []string) error {
+	if len(arns) == 0 {
+		return nil
+	}
+
+	reset := map[string]bool{}
+	for _, arn := range arns {
+		reset[arn] = true
+	}
+
+	for _, cache := range c.SSO {
+		if cache.Roles == nil {
+			continue
+		}
+		for _, account := range cache.Roles.Accounts {
+			for _, role := range account.Roles {
+				if reset[role.Arn] {
+					role.Expires = 0
+				}
+			}
+		}
+	}
+	return c.Save(false)
+}
+
 // returns all tags, but with with spaces replaced with underscores
 func (c *Cache) GetAllTagsSelect() *TagsList {
 	cache := c.GetSSO()
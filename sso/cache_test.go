@@ -489,6 +489,24 @@ func (suite *CacheTestSuite) TestSetRoleExpires() {
 	assert.Error(t, err)
 }
 
+func (suite *CacheTestSuite) TestResetRolesExpires() {
+	t := suite.T()
+	assert.NoError(t, suite.cache.SetRoleExpires(TEST_ROLE_ARN, 12344553243))
+	assert.NotContains(t, suite.cache.ExpiredRoleArns(), TEST_ROLE_ARN)
+
+	assert.NoError(t, suite.cache.SetRoleExpires(TEST_ROLE_ARN, time.Now().Add(-time.Minute).Unix()))
+	assert.Contains(t, suite.cache.ExpiredRoleArns(), TEST_ROLE_ARN)
+
+	assert.NoError(t, suite.cache.ResetRolesExpires([]string{TEST_ROLE_ARN, INVALID_ROLE_ARN}))
+	assert.NotContains(t, suite.cache.ExpiredRoleArns(), TEST_ROLE_ARN)
+
+	flat, err := suite.cache.GetRole(TEST_ROLE_ARN)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), flat.Expires)
+
+	assert.NoError(t, suite.cache.ResetRolesExpires([]string{}))
+}
+
 func (suite *CacheTestSuite) TestCheckProfiles() {
 	t := suite.T()
 	tests := ProfileTests{}
@@ -523,3 +541,39 @@ func (suite *CacheTestSuite) TestCheckProfiles() {
 	err = r.checkProfiles(&badSettings)
 	assert.Error(t, err)
 }
+
+func (suite *CacheTestSuite) TestFavorites() {
+	t := suite.T()
+
+	s := &SSOCache{}
+	assert.False(t, s.IsFavorite("arn:aws:iam::123456789012:role/Foo"))
+
+	assert.True(t, s.ToggleFavorite("arn:aws:iam::123456789012:role/Foo"))
+	assert.True(t, s.ToggleFavorite("arn:aws:iam::123456789012:role/Bar"))
+	assert.True(t, s.IsFavorite("arn:aws:iam::123456789012:role/Foo"))
+	assert.Equal(t, []string{
+		"arn:aws:iam::123456789012:role/Foo",
+		"arn:aws:iam::123456789012:role/Bar",
+	}, s.Favorites)
+
+	assert.False(t, s.ToggleFavorite("arn:aws:iam::123456789012:role/Foo"))
+	assert.False(t, s.IsFavorite("arn:aws:iam::123456789012:role/Foo"))
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/Bar"}, s.Favorites)
+}
+
+func (suite *CacheTestSuite) TestSetSSO() {
+	t := suite.T()
+
+	c := &Cache{
+		settings: &Settings{},
+		ssoName:  "Default",
+		SSO: map[string]*SSOCache{
+			"Default": {LastUpdate: 1, Roles: &Roles{}},
+			"Other":   {LastUpdate: 2, Roles: &Roles{}},
+		},
+	}
+	assert.Equal(t, int64(1), c.GetSSO().LastUpdate)
+	c.SetSSO("Other")
+	assert.Equal(t, int64(2), c.GetSSO().LastUpdate)
+	assert.Equal(t, "Other", c.GetSSO().Roles.ssoName)
+}
@@ -0,0 +1,140 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scores for FuzzyScore.  Any substring match beats a scattered match.
+const (
+	FUZZY_EXACT       = 300
+	FUZZY_PREFIX      = 200
+	FUZZY_SUBSTRING   = 100
+	FUZZY_BOUNDARY    = 10 // match starts a word
+	FUZZY_MAX_SCATTER = FUZZY_SUBSTRING - 1
+)
+
+// FuzzyMatch is a role matching a fuzzy search
+type FuzzyMatch struct {
+	Arn   string
+	Score int
+}
+
+// FuzzyScore returns how well the pattern matches the string, ignoring case.
+// Returns false if the characters of the pattern do not appear in order.
+func FuzzyScore(pattern, str string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	s := []rune(strings.ToLower(str))
+	if len(p) == 0 {
+		return 0, true
+	}
+
+	if i := strings.Index(string(s), string(p)); i >= 0 {
+		switch {
+		case len(p) == len(s):
+			return FUZZY_EXACT + len(p), true
+		case i == 0:
+			return FUZZY_PREFIX + len(p), true
+		}
+		score := FUZZY_SUBSTRING + len(p)
+		if isFuzzyBoundary(s, len([]rune(string(s)[:i]))) {
+			score += FUZZY_BOUNDARY
+		}
+		return score, true
+	}
+
+	// scattered match: reward consecutive characters and word starts
+	score, pi, last := 0, 0, -2
+	for si := 0; si < len(s) && pi < len(p); si++ {
+		if s[si] != p[pi] {
+			continue
+		}
+		score++
+		if si == last+1 {
+			score += 2
+		}
+		if isFuzzyBoundary(s, si) {
+			score += 3
+		}
+		last = si
+		pi++
+	}
+	if pi < len(p) {
+		return 0, false
+	}
+	if score > FUZZY_MAX_SCATTER {
+		score = FUZZY_MAX_SCATTER
+	}
+	return score, true
+}
+
+// isFuzzyBoundary returns if the rune at i starts a word
+func isFuzzyBoundary(s []rune, i int) bool {
+	return i == 0 || !(unicode.IsLetter(s[i-1]) || unicode.IsDigit(s[i-1]))
+}
+
+// FuzzySearch returns the roles where every word of the query fuzzy matches
+// one of the tag values or is part of the ARN, best match first.  The History
+// tag is ignored.  An empty query returns every role.
+func (r *RoleTags) FuzzySearch(query string) []FuzzyMatch {
+	words := strings.Fields(query)
+	matches := []FuzzyMatch{}
+
+	for arn, tags := range *r {
+		values := []string{}
+		for k, v := range tags {
+			if k != "History" {
+				values = append(values, v)
+			}
+		}
+
+		total, found := 0, true
+		for _, word := range words {
+			best := -1
+			// scattered matches in an ARN are meaningless
+			if score, ok := FuzzyScore(word, arn); ok && score >= FUZZY_SUBSTRING {
+				best = score
+			}
+			for _, v := range values {
+				if score, ok := FuzzyScore(word, v); ok && score > best {
+					best = score
+				}
+			}
+			if best < 0 {
+				found = false
+				break
+			}
+			total += best
+		}
+		if found {
+			matches = append(matches, FuzzyMatch{Arn: arn, Score: total})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Arn < matches[j].Arn
+	})
+	return matches
+}
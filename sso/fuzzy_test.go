@@ -0,0 +1,150 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	t.Parallel()
+
+	exact, ok := FuzzyScore("Admin", "admin")
+	assert.True(t, ok)
+	prefix, ok := FuzzyScore("adm", "AdministratorAccess")
+	assert.True(t, ok)
+	boundary, ok := FuzzyScore("dev", "my-dev-account")
+	assert.True(t, ok)
+	substring, ok := FuzzyScore("dev", "mydevaccount")
+	assert.True(t, ok)
+	scatter, ok := FuzzyScore("mda", "my_dev_account")
+	assert.True(t, ok)
+	weak, ok := FuzzyScore("mda", "something_in_my_data")
+	assert.True(t, ok)
+
+	assert.Greater(t, exact, prefix)
+	assert.Greater(t, prefix, boundary)
+	assert.Greater(t, boundary, substring)
+	assert.Greater(t, substring, scatter)
+	assert.Greater(t, scatter, weak)
+
+	_, ok = FuzzyScore("xyz", "my_dev_account")
+	assert.False(t, ok)
+	_, ok = FuzzyScore("adm", "mda")
+	assert.False(t, ok)
+
+	score, ok := FuzzyScore("", "anything")
+	assert.True(t, ok)
+	assert.Equal(t, 0, score)
+
+	score, ok = FuzzyScore("abcdefghijklmnopqrstuvwxyz", "a-b-c-d-e-f-g-h-i-j-k-l-m-n-o-p-q-r-s-t-u-v-w-x-y-z")
+	assert.True(t, ok)
+	assert.Less(t, score, FUZZY_SUBSTRING)
+}
+
+func TestFuzzySearch(t *testing.T) {
+	t.Parallel()
+
+	rt := RoleTags{
+		"arn:aws:iam::123456789012:role/Admin": {
+			"AccountName": "Dev_Account",
+			"Role":        "Admin",
+			"History":     "prod",
+		},
+		"arn:aws:iam::123456789012:role/ReadOnly": {
+			"AccountName": "Dev_Account",
+			"Role":        "ReadOnly",
+		},
+		"arn:aws:iam::210987654321:role/Admin": {
+			"AccountName": "Prod_Account",
+			"Role":        "Admin",
+		},
+	}
+
+	// every word must match
+	matches := rt.FuzzySearch("dev admin")
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Admin", matches[0].Arn)
+
+	// History tag is ignored
+	matches = rt.FuzzySearch("prod")
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "arn:aws:iam::210987654321:role/Admin", matches[0].Arn)
+
+	// best matches first
+	matches = rt.FuzzySearch("readonly")
+	assert.Equal(t, "arn:aws:iam::123456789012:role/ReadOnly", matches[0].Arn)
+
+	// ARN matches too, but only as a substring
+	matches = rt.FuzzySearch("210987654321")
+	assert.Equal(t, 1, len(matches))
+	matches = rt.FuzzySearch("read")
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/ReadOnly", matches[0].Arn)
+
+	// empty query returns everything sorted by ARN
+	matches = rt.FuzzySearch("")
+	assert.Equal(t, []FuzzyMatch{
+		{Arn: "arn:aws:iam::123456789012:role/Admin"},
+		{Arn: "arn:aws:iam::123456789012:role/ReadOnly"},
+		{Arn: "arn:aws:iam::210987654321:role/Admin"},
+	}, matches)
+
+	assert.Empty(t, rt.FuzzySearch("nothing"))
+}
+
+func TestFuzzySearchArn(t *testing.T) {
+	t.Parallel()
+
+	rt := RoleTags{
+		"arn:aws:iam::123456789012:role/Admin": {
+			"AccountName": "Dev_Account",
+		},
+		"arn:aws:iam::123456789012:role/ReadOnly": {
+			"AccountName": "Dev_Account",
+		},
+		"arn:aws:iam::210987654321:role/Admin": {
+			"AccountName": "Prod_Account",
+		},
+	}
+
+	// substrings of the ARN match
+	tests := map[string][]string{
+		"123456789012": {
+			"arn:aws:iam::123456789012:role/Admin",
+			"arn:aws:iam::123456789012:role/ReadOnly",
+		},
+		"role/readonly":           {"arn:aws:iam::123456789012:role/ReadOnly"},
+		"210987654321:role/Admin": {"arn:aws:iam::210987654321:role/Admin"},
+	}
+	for query, expected := range tests {
+		arns := []string{}
+		for _, m := range rt.FuzzySearch(query) {
+			arns = append(arns, m.Arn)
+		}
+		assert.ElementsMatch(t, expected, arns, query)
+	}
+
+	// letters scattered across the ARN do not
+	for _, query := range []string{"aiam", "arole", "1r0", "iamadmin"} {
+		assert.Empty(t, rt.FuzzySearch(query), query)
+	}
+}
@@ -0,0 +1,74 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// ProfilesFilter selects which roles the config command generates profiles for
+type ProfilesFilter struct {
+	SSO         []string `koanf:"SSO" yaml:"SSO,omitempty"`                 // AWS SSO instance names
+	Tags        []string `koanf:"Tags" yaml:"Tags,omitempty"`               // tag queries
+	HistoryOnly bool     `koanf:"HistoryOnly" yaml:"HistoryOnly,omitempty"` // only roles in the History
+}
+
+// Validate returns an error if any of the Tags are invalid
+func (p *ProfilesFilter) Validate() error {
+	for _, tag := range p.Tags {
+		if _, err := ParseTagQuery(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchSSO returns if the AWS SSO instance is selected by the filter
+func (p *ProfilesFilter) MatchSSO(ssoName string) bool {
+	if len(p.SSO) == 0 {
+		return true
+	}
+	for _, name := range p.SSO {
+		if name == ssoName {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns if the role matches all of the Tags queries and is in the history
+// of the given SSOCache if HistoryOnly is set.  Invalid Tags never match.
+func (p *ProfilesFilter) Match(role *AWSRoleFlat, cache *SSOCache) bool {
+	if p.HistoryOnly {
+		found := false
+		for _, arn := range cache.History {
+			if arn == role.Arn {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, tag := range p.Tags {
+		q, err := ParseTagQuery(tag)
+		if err != nil || !q.Match(role.Tags) {
+			return false
+		}
+	}
+	return true
+}
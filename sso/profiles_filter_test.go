@@ -0,0 +1,74 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfilesFilter(t *testing.T) {
+	cache := &SSOCache{
+		History: []string{"arn:aws:iam::123456789012:role/Admin"},
+	}
+	admin := &AWSRoleFlat{
+		Arn:  "arn:aws:iam::123456789012:role/Admin",
+		Tags: map[string]string{"Env": "prod"},
+	}
+	ro := &AWSRoleFlat{
+		Arn:  "arn:aws:iam::123456789012:role/ReadOnly",
+		Tags: map[string]string{"Env": "dev"},
+	}
+
+	p := ProfilesFilter{}
+	assert.NoError(t, p.Validate())
+	assert.True(t, p.MatchSSO("Default"))
+	assert.True(t, p.Match(admin, cache))
+	assert.True(t, p.Match(ro, cache))
+
+	p = ProfilesFilter{SSO: []string{"Other"}}
+	assert.False(t, p.MatchSSO("Default"))
+	assert.True(t, p.MatchSSO("Other"))
+
+	p = ProfilesFilter{Tags: []string{"Env!=prod"}}
+	assert.False(t, p.Match(admin, cache))
+	assert.True(t, p.Match(ro, cache))
+
+	p = ProfilesFilter{HistoryOnly: true}
+	assert.True(t, p.Match(admin, cache))
+	assert.False(t, p.Match(ro, cache))
+
+	p = ProfilesFilter{HistoryOnly: true, Tags: []string{"Env=dev"}}
+	assert.False(t, p.Match(admin, cache))
+	assert.False(t, p.Match(ro, cache))
+
+	// every query must match
+	p = ProfilesFilter{Tags: []string{"Env IN (dev, qa)", "NOT Team"}}
+	assert.NoError(t, p.Validate())
+	assert.False(t, p.Match(admin, cache))
+	assert.True(t, p.Match(ro, cache))
+
+	p = ProfilesFilter{Tags: []string{"Env=dev", "Env=qa"}}
+	assert.False(t, p.Match(ro, cache))
+
+	p = ProfilesFilter{Tags: []string{"=bad"}}
+	assert.Error(t, p.Validate())
+	assert.False(t, p.Match(admin, cache))
+}
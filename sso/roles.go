@@ -324,6 +324,24 @@ func (r *AWSRoleFlat) ProfileName(s *Settings) (string, error) {
 		format = DEFAULT_PROFILE_TEMPLATE
 	}
 
+	templ, err := template.New("profile_name").Funcs(TemplateFuncMap()).Parse(format)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	log.Tracef("RoleInfo: %s", spew.Sdump(r))
+	log.Tracef("Template: %s", spew.Sdump(templ))
+	if err := templ.Execute(buf, r); err != nil {
+		log.WithError(err).Errorf("Unable to generate AWS_SSO_PROFILE")
+	}
+
+	return buf.String(), nil
+}
+
+// TemplateFuncMap returns the sprig functions along with our custom functions
+// for use in user supplied templates
+func TemplateFuncMap() template.FuncMap {
 	// our custom functions
 	customFuncs := template.FuncMap{
 		"AccountIdStr":  accountIdToStr,
@@ -338,20 +356,7 @@ func (r *AWSRoleFlat) ProfileName(s *Settings) (string, error) {
 	for k, v := range customFuncs {
 		funcMap[k] = v
 	}
-
-	templ, err := template.New("profile_name").Funcs(funcMap).Parse(format)
-	if err != nil {
-		return "", err
-	}
-
-	buf := new(bytes.Buffer)
-	log.Tracef("RoleInfo: %s", spew.Sdump(r))
-	log.Tracef("Template: %s", spew.Sdump(templ))
-	if err := templ.Execute(buf, r); err != nil {
-		log.WithError(err).Errorf("Unable to generate AWS_SSO_PROFILE")
-	}
-
-	return buf.String(), nil
+	return funcMap
 }
 
 func emptyString(str string) bool {
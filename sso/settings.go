@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	// "github.com/davecgh/go-spew/spew"
@@ -47,22 +48,32 @@ type Settings struct {
 	Cache             *Cache                 `yaml:"-"` // our cache data
 	SSO               map[string]*SSOConfig  `koanf:"SSOConfig" yaml:"SSOConfig,omitempty"`
 	DefaultSSO        string                 `koanf:"DefaultSSO" yaml:"DefaultSSO,omitempty"`   // specify default SSO by key
-	SecureStore       string                 `koanf:"SecureStore" yaml:"SecureStore,omitempty"` // json or keyring
+	SecureStore       string                 `koanf:"SecureStore" yaml:"SecureStore,omitempty"` // json, encrypted, exec or keyring
 	DefaultRegion     string                 `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
 	ConsoleDuration   int32                  `koanf:"ConsoleDuration" yaml:"ConsoleDuration,omitempty"`
+	ConsoleServices   map[string]string      `koanf:"ConsoleServices" yaml:"ConsoleServices,omitempty"`
+	ConsoleIssuer     string                 `koanf:"ConsoleIssuer" yaml:"ConsoleIssuer,omitempty"`
+	BrowserSession    BrowserSession         `koanf:"BrowserSession" yaml:"BrowserSession,omitempty"`
 	JsonStore         string                 `koanf:"JsonStore" yaml:"JsonStore,omitempty"`
+	EncryptedStore    string                 `koanf:"EncryptedStore" yaml:"EncryptedStore,omitempty"`
+	EncryptedStoreCmd []string               `koanf:"EncryptedStorePasswordCommand" yaml:"EncryptedStorePasswordCommand,omitempty"`
+	ExecStoreCommand  []string               `koanf:"ExecStoreCommand" yaml:"ExecStoreCommand,omitempty"`
 	UrlAction         string                 `koanf:"UrlAction" yaml:"UrlAction,omitempty"`
 	Browser           string                 `koanf:"Browser" yaml:"Browser,omitempty"`
 	UrlExecCommand    interface{}            `koanf:"UrlExecCommand" yaml:"UrlExecCommand,omitempty"` // string or list
 	ProfileFormat     string                 `koanf:"ProfileFormat" yaml:"ProfileFormat,omitempty"`
 	AccountPrimaryTag []string               `koanf:"AccountPrimaryTag" yaml:"AccountPrimaryTag,omitempty"`
 	PromptColors      PromptColors           `koanf:"PromptColors" yaml:"PromptColors,omitempty"` // go-prompt colors
+	SelectMode        string                 `koanf:"SelectMode" yaml:"SelectMode,omitempty"`     // tags or fuzzy
 	LogLevel          string                 `koanf:"LogLevel" yaml:"LogLevel,omitempty"`
 	LogLines          bool                   `koanf:"LogLines" yaml:"LogLines,omitempty"`
 	HistoryLimit      int64                  `koanf:"HistoryLimit" yaml:"HistoryLimit,omitempty"`
 	HistoryMinutes    int64                  `koanf:"HistoryMinutes" yaml:"HistoryMinutes,omitempty"`
 	ListFields        []string               `koanf:"ListFields" yaml:"ListFields,omitempty"`
 	ConfigVariables   map[string]interface{} `koanf:"ConfigVariables" yaml:"ConfigVariables,omitempty"`
+	ConfigTemplate    string                 `koanf:"ConfigTemplate" yaml:"ConfigTemplate,omitempty"`
+	ConfigFilter      ProfilesFilter         `koanf:"ConfigProfilesFilter" yaml:"ConfigProfilesFilter,omitempty"`
+	ConfigBackups     int                    `koanf:"ConfigBackups" yaml:"ConfigBackups,omitempty"`
 	EnvVarTags        []string               `koanf:"EnvVarTags" yaml:"EnvVarTags,omitempty"`
 }
 
@@ -72,25 +83,33 @@ type SSOConfig struct {
 	StartUrl      string                 `koanf:"StartUrl" yaml:"StartUrl"`
 	Accounts      map[string]*SSOAccount `koanf:"Accounts" yaml:"Accounts,omitempty"` // key must be a string to avoid parse errors!
 	DefaultRegion string                 `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
+	ConsoleIssuer string                 `koanf:"ConsoleIssuer" yaml:"ConsoleIssuer,omitempty"`
+	ConsoleLogout *bool                  `koanf:"ConsoleLogout" yaml:"ConsoleLogout,omitempty"`
 }
 
 type SSOAccount struct {
-	config        *SSOConfig          // pointer back up
-	Name          string              `koanf:"Name" yaml:"Name,omitempty"` // Admin configured Account Name
-	Tags          map[string]string   `koanf:"Tags" yaml:"Tags,omitempty" `
-	Roles         map[string]*SSORole `koanf:"Roles" yaml:"Roles,omitempty"`
-	DefaultRegion string              `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
+	config          *SSOConfig             // pointer back up
+	Name            string                 `koanf:"Name" yaml:"Name,omitempty"` // Admin configured Account Name
+	Tags            map[string]string      `koanf:"Tags" yaml:"Tags,omitempty" `
+	Roles           map[string]*SSORole    `koanf:"Roles" yaml:"Roles,omitempty"`
+	DefaultRegion   string                 `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
+	ConsoleUrl      string                 `koanf:"ConsoleUrl" yaml:"ConsoleUrl,omitempty"`
+	ConsoleLogout   *bool                  `koanf:"ConsoleLogout" yaml:"ConsoleLogout,omitempty"`
+	ConfigVariables map[string]interface{} `koanf:"ConfigVariables" yaml:"ConfigVariables,omitempty"`
 }
 
 type SSORole struct {
-	account        *SSOAccount       // pointer back up
-	ARN            string            `yaml:"ARN"`
-	Profile        string            `koanf:"Profile" yaml:"Profile,omitempty"`
-	Tags           map[string]string `koanf:"Tags" yaml:"Tags,omitempty"`
-	DefaultRegion  string            `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
-	Via            string            `koanf:"Via" yaml:"Via,omitempty"`
-	ExternalId     string            `koanf:"ExternalId" yaml:"ExternalId,omitempty"`
-	SourceIdentity string            `koanf:"SourceIdentity" yaml:"SourceIdentity,omitempty"`
+	account         *SSOAccount            // pointer back up
+	ARN             string                 `yaml:"ARN"`
+	Profile         string                 `koanf:"Profile" yaml:"Profile,omitempty"`
+	Tags            map[string]string      `koanf:"Tags" yaml:"Tags,omitempty"`
+	DefaultRegion   string                 `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
+	Via             string                 `koanf:"Via" yaml:"Via,omitempty"`
+	ExternalId      string                 `koanf:"ExternalId" yaml:"ExternalId,omitempty"`
+	SourceIdentity  string                 `koanf:"SourceIdentity" yaml:"SourceIdentity,omitempty"`
+	ConsoleUrl      string                 `koanf:"ConsoleUrl" yaml:"ConsoleUrl,omitempty"`
+	ConsoleLogout   *bool                  `koanf:"ConsoleLogout" yaml:"ConsoleLogout,omitempty"`
+	ConfigVariables map[string]interface{} `koanf:"ConfigVariables" yaml:"ConfigVariables,omitempty"`
 }
 
 // GetDefaultRegion scans the config settings file to pick the most local DefaultRegion from the tree
@@ -133,6 +152,95 @@ func (s *Settings) GetDefaultRegion(id int64, roleName string, noRegion bool) st
 	return role
 }
 
+// GetConfigVariables returns the ConfigVariables for the role in the given SSO
+// instance.  Values defined on the role override those on the account which
+// override the global ConfigVariables.
+func (s *Settings) GetConfigVariables(ssoName string, id int64, roleName string) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for k, v := range s.ConfigVariables {
+		vars[k] = v
+	}
+
+	accountId, err := utils.AccountIdToString(id)
+	if err != nil {
+		log.WithError(err).Errorf("Unable to GetConfigVariables()")
+		return vars
+	}
+
+	if c, ok := s.SSO[ssoName]; ok {
+		if a, ok := c.Accounts[accountId]; ok {
+			for k, v := range a.ConfigVariables {
+				vars[k] = v
+			}
+			if r, ok := a.Roles[roleName]; ok {
+				for k, v := range r.ConfigVariables {
+					vars[k] = v
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// GetConsoleUrl returns the AWS Console landing page for the role in the given
+// SSO instance.  Values defined on the role override those on the account.
+func (s *Settings) GetConsoleUrl(ssoName string, id int64, roleName string) string {
+	accountId, err := utils.AccountIdToString(id)
+	if err != nil {
+		log.WithError(err).Errorf("Unable to GetConsoleUrl()")
+		return ""
+	}
+
+	consoleUrl := ""
+	if c, ok := s.SSO[ssoName]; ok {
+		if a, ok := c.Accounts[accountId]; ok {
+			consoleUrl = a.ConsoleUrl
+			if r, ok := a.Roles[roleName]; ok && r.ConsoleUrl != "" {
+				consoleUrl = r.ConsoleUrl
+			}
+		}
+	}
+	return consoleUrl
+}
+
+// GetConsoleLogout returns true if the AWS Console session should be signed out
+// before signing in to the role in the given SSO instance.  Values defined on
+// the role override those on the account which override the SSO instance.
+func (s *Settings) GetConsoleLogout(ssoName string, id int64, roleName string) bool {
+	logout := false
+
+	c, ok := s.SSO[ssoName]
+	if !ok {
+		return logout
+	}
+	if c.ConsoleLogout != nil {
+		logout = *c.ConsoleLogout
+	}
+
+	accountId, err := utils.AccountIdToString(id)
+	if err != nil {
+		return logout
+	}
+	if a, ok := c.Accounts[accountId]; ok {
+		if a.ConsoleLogout != nil {
+			logout = *a.ConsoleLogout
+		}
+		if r, ok := a.Roles[roleName]; ok && r.ConsoleLogout != nil {
+			logout = *r.ConsoleLogout
+		}
+	}
+	return logout
+}
+
+// GetConsoleIssuer returns the Issuer for AWS Console sessions of the given
+// SSO instance which overrides the global ConsoleIssuer
+func (s *Settings) GetConsoleIssuer(ssoName string) string {
+	if c, ok := s.SSO[ssoName]; ok && c.ConsoleIssuer != "" {
+		return c.ConsoleIssuer
+	}
+	return s.ConsoleIssuer
+}
+
 var DEFAULT_ACCOUNT_PRIMARY_TAGS []string = []string{
 	"AccountName",
 	"AccountAlias",
@@ -232,6 +340,38 @@ func (s *Settings) Save(configFile string, overwrite bool) error {
 	return ioutil.WriteFile(configFile, data, 0600)
 }
 
+// SetConfigValue updates (or adds) a single top level key in the config file
+// without touching the rest of the file like Save() would
+func SetConfigValue(configFile, key, value string) error {
+	configFile = utils.GetHomePath(configFile)
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%s: %s", key, value)
+	re := regexp.MustCompile(fmt.Sprintf(`(?m)^%s:.*$`, regexp.QuoteMeta(key)))
+	if re.Match(data) {
+		data = re.ReplaceAllLiteral(data, []byte(line))
+	} else {
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		data = append(data, []byte(line+"\n")...)
+	}
+
+	tmpFile := configFile + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, configFile)
+}
+
 // configure our settings using the overrides
 func (s *Settings) setOverrides(override OverrideSettings) {
 	// Setup Logging
@@ -171,6 +171,32 @@ func (suite *SettingsTestSuite) TestSave() {
 	assert.NotNil(t, err)
 }
 
+func TestSetConfigValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "config.yaml")
+	assert.Error(t, SetConfigValue(p, "SecureStore", "json"))
+
+	config := "# my config\nSecureStore: file # old\nLogLevel: warn"
+	assert.Nil(t, ioutil.WriteFile(p, []byte(config), 0640))
+
+	assert.Nil(t, SetConfigValue(p, "SecureStore", "json"))
+	data, err := ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "# my config\nSecureStore: json\nLogLevel: warn", string(data))
+
+	assert.Nil(t, SetConfigValue(p, "JsonStore", "~/store.json"))
+	data, err = ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "# my config\nSecureStore: json\nLogLevel: warn\nJsonStore: ~/store.json\n", string(data))
+
+	info, err := os.Stat(p)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
 func clearEnv() {
 	os.Setenv("AWS_DEFAULT_REGION", "")
 	os.Setenv("AWS_SSO_DEFAULT_REGION", "")
@@ -201,6 +227,78 @@ func (suite *SettingsTestSuite) TestGetDefaultRegion() {
 	assert.Equal(t, "us-east-1", suite.settings.GetDefaultRegion(833365043586, "AWSAdministratorAccess:", false))
 }
 
+func (suite *SettingsTestSuite) TestGetConfigVariables() {
+	t := suite.T()
+
+	assert.Equal(t, map[string]interface{}{
+		"output":                 "json",
+		"cli_pager":              "less",
+		"sts_regional_endpoints": "regional",
+	}, suite.settings.GetConfigVariables("Default", 258234615182, "AWSAdministratorAccess"))
+
+	assert.Equal(t, map[string]interface{}{
+		"output":                 "table",
+		"cli_pager":              "less",
+		"sts_regional_endpoints": "regional",
+	}, suite.settings.GetConfigVariables("Default", 258234615182, "LimitedAccess"))
+
+	global := map[string]interface{}{
+		"output":                 "text",
+		"sts_regional_endpoints": "regional",
+	}
+	assert.Equal(t, global, suite.settings.GetConfigVariables("Default", 833365043586, "AWSAdministratorAccess"))
+	assert.Equal(t, global, suite.settings.GetConfigVariables("Another", 258234615182, "AWSAdministratorAccess"))
+	assert.Equal(t, global, suite.settings.GetConfigVariables("Missing", 258234615182, "AWSAdministratorAccess"))
+
+	// must not modify the global ConfigVariables
+	assert.Equal(t, "text", suite.settings.ConfigVariables["output"])
+}
+
+func (suite *SettingsTestSuite) TestGetConsoleUrl() {
+	t := suite.T()
+
+	assert.Equal(t, "arn:aws:s3:::my-bucket", suite.settings.GetConsoleUrl("Default", 258234615182, "AWSAdministratorAccess"))
+	assert.Equal(t, "s3", suite.settings.GetConsoleUrl("Default", 258234615182, "LimitedAccess"))
+	assert.Equal(t, "", suite.settings.GetConsoleUrl("Default", 833365043586, "AWSAdministratorAccess"))
+	assert.Equal(t, "", suite.settings.GetConsoleUrl("Missing", 258234615182, "AWSAdministratorAccess"))
+	assert.Equal(t, "", suite.settings.GetConsoleUrl("Default", -1, "AWSAdministratorAccess"))
+}
+
+func (suite *SettingsTestSuite) TestGetConsoleLogout() {
+	t := suite.T()
+
+	assert.True(t, suite.settings.GetConsoleLogout("Default", 258234615182, "AWSAdministratorAccess"))
+	assert.False(t, suite.settings.GetConsoleLogout("Default", 258234615182, "LimitedAccess"))
+	assert.True(t, suite.settings.GetConsoleLogout("Default", 833365043586, "AWSAdministratorAccess"))
+	assert.True(t, suite.settings.GetConsoleLogout("Default", 0, ""))
+	assert.False(t, suite.settings.GetConsoleLogout("Another", 182347455, "AWSAdministratorAccess"))
+	assert.False(t, suite.settings.GetConsoleLogout("Missing", 258234615182, "AWSAdministratorAccess"))
+}
+
+func (suite *SettingsTestSuite) TestGetConsoleIssuer() {
+	t := suite.T()
+
+	assert.Equal(t, "https://d-755555555.awsapps.com/start", suite.settings.GetConsoleIssuer("Another"))
+	assert.Equal(t, "", suite.settings.GetConsoleIssuer("Default"))
+
+	s := &Settings{
+		ConsoleIssuer: "https://example.com/",
+		SSO:           suite.settings.SSO,
+	}
+	assert.Equal(t, "https://example.com/", s.GetConsoleIssuer("Default"))
+	assert.Equal(t, "https://d-755555555.awsapps.com/start", s.GetConsoleIssuer("Another"))
+}
+
+func (suite *SettingsTestSuite) TestConfigFilter() {
+	t := suite.T()
+
+	assert.Equal(t, ProfilesFilter{
+		SSO:         []string{"Default"},
+		Tags:        []string{"Foo!=Moo"},
+		HistoryOnly: true,
+	}, suite.settings.ConfigFilter)
+}
+
 func (suite *SettingsTestSuite) TestOtherSSO() {
 	t := suite.T()
 	over := OverrideSettings{
@@ -0,0 +1,532 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+ * TagQuery is a small boolean query language for selecting roles by tag:
+ *
+ *   query      := or
+ *   or         := and { ("OR" | "||") and }
+ *   and        := not { ["AND" | "&&"] not }      // AND is implied
+ *   not        := ("NOT" | "!") not | primary
+ *   primary    := "(" or ")" | key [ op value | ["NOT"] "IN" "(" value { "," value } ")" ]
+ *   op         := "=" | "!=" | "=~" | "!~"
+ *
+ * Keys and values are either bare words or single/double quoted strings with
+ * backslash escapes.  A key by itself matches if the tag exists.  `=` and `!=`
+ * treat values containing `*` or `?` as a glob.  `=~` and `!~` use regular
+ * expressions which must match the whole value.  Keywords are case insensitive.
+ */
+
+const MAX_QUERY_DEPTH = 100 // max nesting of NOT and parens
+
+// TagQuery is a parsed tag query
+type TagQuery struct {
+	query string
+	root  queryNode
+}
+
+// ParseTagQuery parses the query string
+func ParseTagQuery(query string) (*TagQuery, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, fmt.Errorf("Invalid tag query: empty query")
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected '%s'", t.val)
+	}
+	return &TagQuery{query: query, root: root}, nil
+}
+
+// Match returns if the tags match the query
+func (q *TagQuery) Match(tags map[string]string) bool {
+	return q.root.match(tags)
+}
+
+// String returns the normalized query
+func (q *TagQuery) String() string {
+	return q.root.String()
+}
+
+// MatchingRolesQuery returns the roles which match the query
+func (r *Roles) MatchingRolesQuery(q *TagQuery) []*AWSRoleFlat {
+	ret := []*AWSRoleFlat{}
+	for _, role := range r.GetAllRoles() {
+		if q.Match(role.Tags) {
+			ret = append(ret, role)
+		}
+	}
+	return ret
+}
+
+/*
+ * Evaluation
+ */
+
+type queryNode interface {
+	match(tags map[string]string) bool
+	String() string
+}
+
+type orNode struct {
+	left, right queryNode
+}
+
+func (n orNode) match(tags map[string]string) bool {
+	return n.left.match(tags) || n.right.match(tags)
+}
+
+func (n orNode) String() string {
+	return fmt.Sprintf("(%s OR %s)", n.left.String(), n.right.String())
+}
+
+type andNode struct {
+	left, right queryNode
+}
+
+func (n andNode) match(tags map[string]string) bool {
+	return n.left.match(tags) && n.right.match(tags)
+}
+
+func (n andNode) String() string {
+	return fmt.Sprintf("(%s AND %s)", n.left.String(), n.right.String())
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (n notNode) match(tags map[string]string) bool {
+	return !n.node.match(tags)
+}
+
+func (n notNode) String() string {
+	return fmt.Sprintf("NOT %s", n.node.String())
+}
+
+// existsNode matches if the tag exists
+type existsNode struct {
+	key string
+}
+
+func (n existsNode) match(tags map[string]string) bool {
+	_, ok := tags[n.key]
+	return ok
+}
+
+func (n existsNode) String() string {
+	return quoteQuery(n.key)
+}
+
+// compareNode implements =, !=, =~ and !~.  Missing tags never match = or =~.
+type compareNode struct {
+	key   string
+	op    string
+	value string
+	re    *regexp.Regexp // for regex & glob
+}
+
+func (n compareNode) match(tags map[string]string) bool {
+	v, ok := tags[n.key]
+	var match bool
+	if n.re != nil {
+		match = ok && n.re.MatchString(v)
+	} else {
+		match = ok && v == n.value
+	}
+	if n.op == "!=" || n.op == "!~" {
+		return !match
+	}
+	return match
+}
+
+func (n compareNode) String() string {
+	return fmt.Sprintf("%s %s %s", quoteQuery(n.key), n.op, quoteQuery(n.value))
+}
+
+// inNode matches if the tag has one of the values
+type inNode struct {
+	key    string
+	values []string
+}
+
+func (n inNode) match(tags map[string]string) bool {
+	v, ok := tags[n.key]
+	if !ok {
+		return false
+	}
+	for _, value := range n.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (n inNode) String() string {
+	values := make([]string, len(n.values))
+	for i, v := range n.values {
+		values[i] = quoteQuery(v)
+	}
+	return fmt.Sprintf("%s IN (%s)", quoteQuery(n.key), strings.Join(values, ", "))
+}
+
+// quoteQuery returns the string double quoted with backslash escapes
+func quoteQuery(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// globToRegexp converts a glob with * and ? to an anchored regexp
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+/*
+ * Lexer
+ */
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp // = != =~ !~
+	tokNot
+	tokAnd
+	tokOr
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type queryToken struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+// characters which end a bare word
+const querySpecialChars = "()=!,\"'&|~"
+
+func lexQuery(query string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	runes := []rune(query)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			tokens = append(tokens, queryToken{tokLParen, "(", i})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, queryToken{tokRParen, ")", i})
+			i++
+
+		case c == ',':
+			tokens = append(tokens, queryToken{tokComma, ",", i})
+			i++
+
+		case c == '=' && next == '~', c == '!' && next == '~', c == '!' && next == '=':
+			tokens = append(tokens, queryToken{tokOp, string([]rune{c, next}), i})
+			i += 2
+
+		case c == '=' && next == '=':
+			tokens = append(tokens, queryToken{tokOp, "=", i})
+			i += 2
+
+		case c == '=':
+			tokens = append(tokens, queryToken{tokOp, "=", i})
+			i++
+
+		case c == '!':
+			tokens = append(tokens, queryToken{tokNot, "!", i})
+			i++
+
+		case c == '&' && next == '&':
+			tokens = append(tokens, queryToken{tokAnd, "&&", i})
+			i += 2
+
+		case c == '|' && next == '|':
+			tokens = append(tokens, queryToken{tokOr, "||", i})
+			i += 2
+
+		case c == '"' || c == '\'':
+			start := i
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					b.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == c {
+					closed = true
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return tokens, fmt.Errorf("Invalid tag query at position %d: unterminated string", start)
+			}
+			tokens = append(tokens, queryToken{tokString, b.String(), start})
+
+		case strings.ContainsRune(querySpecialChars, c):
+			return tokens, fmt.Errorf("Invalid tag query at position %d: unexpected '%c'", i, c)
+
+		default:
+			start := i
+			for i < len(runes) && !strings.ContainsRune(querySpecialChars+" \t\n\r", runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			kind := tokWord
+			switch strings.ToUpper(word) {
+			case "AND":
+				kind = tokAnd
+			case "OR":
+				kind = tokOr
+			case "NOT":
+				kind = tokNot
+			}
+			tokens = append(tokens, queryToken{kind, word, start})
+		}
+	}
+	tokens = append(tokens, queryToken{tokEOF, "end of query", len(runes)})
+	return tokens, nil
+}
+
+/*
+ * Parser
+ */
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	depth  int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) errorf(t queryToken, format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid tag query at position %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokWord, tokString, tokNot, tokLParen:
+			// implied AND
+		default:
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.peek().kind != tokNot {
+		return p.parsePrimary()
+	}
+
+	t := p.next()
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MAX_QUERY_DEPTH {
+		return nil, p.errorf(t, "too deeply nested")
+	}
+
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return notNode{node}, nil
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > MAX_QUERY_DEPTH {
+			return nil, p.errorf(t, "too deeply nested")
+		}
+
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, p.errorf(closing, "expected ')' but got '%s'", closing.val)
+		}
+		return node, nil
+
+	case tokWord, tokString:
+		return p.parseKey(t.val)
+	}
+	return nil, p.errorf(t, "expected tag key but got '%s'", t.val)
+}
+
+// parseKey parses what follows the tag key
+func (p *queryParser) parseKey(key string) (queryNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokOp:
+		p.next()
+		v := p.next()
+		if v.kind != tokWord && v.kind != tokString {
+			return nil, p.errorf(v, "expected value but got '%s'", v.val)
+		}
+
+		node := compareNode{key: key, op: t.val, value: v.val}
+		var err error
+		switch t.val {
+		case "=~", "!~":
+			node.re, err = regexp.Compile("^(?:" + v.val + ")$")
+		default:
+			if strings.ContainsAny(v.val, "*?") {
+				node.re, err = globToRegexp(v.val)
+			}
+		}
+		if err != nil {
+			return nil, p.errorf(v, "invalid pattern: %s", err.Error())
+		}
+		return node, nil
+
+	case t.kind == tokWord && strings.ToUpper(t.val) == "IN":
+		p.next()
+		return p.parseIn(key)
+
+	case t.kind == tokNot && p.pos+1 < len(p.tokens) &&
+		p.tokens[p.pos+1].kind == tokWord && strings.ToUpper(p.tokens[p.pos+1].val) == "IN":
+		p.next()
+		p.next()
+		node, err := p.parseIn(key)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return existsNode{key}, nil
+}
+
+// parseIn parses the list of values for IN
+func (p *queryParser) parseIn(key string) (queryNode, error) {
+	if t := p.next(); t.kind != tokLParen {
+		return nil, p.errorf(t, "expected '(' but got '%s'", t.val)
+	}
+
+	node := inNode{key: key}
+	for {
+		v := p.next()
+		if v.kind != tokWord && v.kind != tokString {
+			return nil, p.errorf(v, "expected value but got '%s'", v.val)
+		}
+		node.values = append(node.values, v.val)
+
+		t := p.next()
+		switch t.kind {
+		case tokComma:
+			continue
+		case tokRParen:
+			return node, nil
+		}
+		return nil, p.errorf(t, "expected ',' or ')' but got '%s'", t.val)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build go1.18
+// +build go1.18
+
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+)
+
+// FuzzParseTagQuery checks the parser never panics and that the normalized
+// query parses to an identical query
+func FuzzParseTagQuery(f *testing.F) {
+	seeds := []string{
+		`Env=dev`,
+		`AccountName="My Dev Account" AND Role=~"Admin.*"`,
+		`(Env=prod OR Env=dev) AND NOT Team IN (ops, 'sec ops')`,
+		`Role=arn:aws:iam::*:role/Adm?n || !Missing`,
+		`Env NOT IN (a, b) && Quote="say \"hi\""`,
+		`((((`,
+		`Env=`,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, q string) {
+		query, err := ParseTagQuery(q)
+		if err != nil {
+			return
+		}
+
+		again, err := ParseTagQuery(query.String())
+		if err != nil {
+			t.Fatalf("unable to parse normalized query %s of %s: %s", query.String(), q, err.Error())
+		}
+		if again.String() != query.String() {
+			t.Fatalf("normalized query changed: %s != %s", again.String(), query.String())
+		}
+		if again.Match(testQueryTags) != query.Match(testQueryTags) {
+			t.Fatalf("normalized query %s does not match the same as %s", query.String(), q)
+		}
+	})
+}
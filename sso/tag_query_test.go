@@ -0,0 +1,139 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testQueryTags = map[string]string{
+	"AccountName": "My Dev Account",
+	"Env":         "dev",
+	"Team":        "infra",
+	"Role":        "arn:aws:iam::123456789012:role/Admin",
+	"Quote":       `say "hi"`,
+}
+
+func TestTagQueryMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		`Env=dev`:                              true,
+		`Env == dev`:                           true,
+		`Env=prod`:                             false,
+		`Env!=prod`:                            true,
+		`Missing!=prod`:                        true,
+		`Missing=prod`:                         false,
+		`Env`:                                  true,
+		`Missing`:                              false,
+		`!Missing`:                             true,
+		`NOT Env`:                              false,
+		`AccountName="My Dev Account"`:         true,
+		`AccountName='My Dev Account'`:         true,
+		`AccountName="My Dev"`:                 false,
+		`AccountName="My*"`:                    true,
+		`AccountName=My*Account`:               true,
+		`AccountName=My???`:                    false,
+		`Role=arn:aws:iam::*:role/Admin`:       true,
+		`Role=*/Adm?n`:                         true,
+		`Env=~d.v`:                             true,
+		`Env=~d`:                               false, // anchored
+		`Env!~"(prod|stage)"`:                  true,
+		`Env IN (dev, prod)`:                   true,
+		`Env in ("prod", 'stage')`:             false,
+		`Env NOT IN (prod, stage)`:             true,
+		`Env=dev Team=infra`:                   true,
+		`Env=dev AND Team=ops`:                 false,
+		`Env=dev && Team=infra`:                true,
+		`Env=prod OR Team=infra`:               true,
+		`Env=prod || Team=ops`:                 false,
+		`Env=prod OR Env=dev AND Team=ops`:     false,
+		`(Env=prod OR Env=dev) AND Team=ops`:   false,
+		`Env=prod OR (Env=dev AND Team=infra)`: true,
+		`NOT (Env=prod OR Team=ops)`:           true,
+		`not not Env=dev`:                      true,
+		`Quote="say \"hi\""`:                   true,
+		`"AccountName" = "My Dev Account"`:     true,
+	}
+
+	for q, expected := range tests {
+		query, err := ParseTagQuery(q)
+		assert.NoError(t, err, q)
+		if err == nil {
+			assert.Equal(t, expected, query.Match(testQueryTags), q)
+		}
+	}
+}
+
+func TestTagQueryErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		``,
+		`   `,
+		`Env=`,
+		`=dev`,
+		`Env=dev AND`,
+		`Env=dev OR OR Team=infra`,
+		`(Env=dev`,
+		`Env=dev)`,
+		`Env="dev`,
+		`Env=~"(dev"`,
+		`Env IN dev`,
+		`Env IN (dev`,
+		`Env IN ()`,
+		`Env IN (dev prod)`,
+		`Env & Team`,
+		`Env | Team`,
+		`Env ~ dev`,
+		strings.Repeat("(", MAX_QUERY_DEPTH+1) + "Env" + strings.Repeat(")", MAX_QUERY_DEPTH+1),
+		strings.Repeat("NOT ", MAX_QUERY_DEPTH+1) + "Env",
+	}
+
+	for _, q := range tests {
+		_, err := ParseTagQuery(q)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestTagQueryString(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		`Env=dev`:                     `"Env" = "dev"`,
+		`Env`:                         `"Env"`,
+		`!Env`:                        `NOT "Env"`,
+		`a=1 b=2 OR c=~"x\"y"`:        `(("a" = "1" AND "b" = "2") OR "c" =~ "x\"y")`,
+		`Env NOT IN (dev, 'my prod')`: `NOT "Env" IN ("dev", "my prod")`,
+	}
+
+	for q, expected := range tests {
+		query, err := ParseTagQuery(q)
+		assert.NoError(t, err, q)
+		assert.Equal(t, expected, query.String(), q)
+
+		// String() must parse to the same query
+		again, err := ParseTagQuery(query.String())
+		assert.NoError(t, err, q)
+		assert.Equal(t, expected, again.String(), q)
+	}
+}
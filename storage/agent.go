@@ -0,0 +1,467 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	ENV_SSO_AGENT_SOCK = "AWS_SSO_AGENT_SOCK"
+
+	AGENT_GET    = "get"
+	AGENT_SET    = "set"
+	AGENT_DELETE = "delete"
+	AGENT_PING   = "ping"
+	AGENT_LOCK   = "lock"
+	AGENT_UNLOCK = "unlock"
+	AGENT_STOP   = "stop"
+
+	AGENT_TIMEOUT = 30 * time.Second
+)
+
+// AgentRequest is sent by the AgentClient to the AgentServer.  Type uses the
+// same values as the ExecStore protocol.
+type AgentRequest struct {
+	Action   string          `json:"Action"`
+	Type     string          `json:"Type,omitempty"`
+	Key      string          `json:"Key,omitempty"`
+	Value    json.RawMessage `json:"Value,omitempty"`
+	Password string          `json:"Password,omitempty"` // only for unlock
+}
+
+// AgentResponse is returned by the AgentServer
+type AgentResponse struct {
+	Value  json.RawMessage `json:"Value,omitempty"`
+	Locked bool            `json:"Locked"`
+	Error  string          `json:"Error,omitempty"`
+}
+
+// OpenStoreFunc (re)opens the backend SecureStorage when the agent is unlocked
+type OpenStoreFunc func(password string) (SecureStorage, error)
+
+type agentCacheItem struct {
+	value   json.RawMessage
+	expires time.Time
+}
+
+// AgentServer holds an unlocked SecureStorage and serves it over a Unix socket
+// so that every CLI invocation doesn't have to unlock the store again
+type AgentServer struct {
+	socket   string
+	ttl      time.Duration
+	open     OpenStoreFunc
+	store    SecureStorage // nil when locked
+	cache    map[string]agentCacheItem
+	mutex    sync.Mutex
+	listener net.Listener
+	stopped  bool
+}
+
+// NewAgentServer creates a new AgentServer for the already opened store.  Items
+// read from the store are kept in memory for ttl.
+func NewAgentServer(socket string, ttl time.Duration, store SecureStorage, open OpenStoreFunc) *AgentServer {
+	return &AgentServer{
+		socket: socket,
+		ttl:    ttl,
+		open:   open,
+		store:  store,
+		cache:  map[string]agentCacheItem{},
+	}
+}
+
+// Listen creates the Unix socket which is only accessible by the current user
+func (a *AgentServer) Listen() error {
+	if err := utils.EnsureDirExists(a.socket); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(a.socket); err == nil {
+		// refuse to steal the socket from a running agent
+		if conn, err := net.Dial("unix", a.socket); err == nil {
+			conn.Close()
+			return fmt.Errorf("Agent is already running on %s", a.socket)
+		}
+		if err = os.Remove(a.socket); err != nil {
+			return fmt.Errorf("Unable to remove stale socket %s: %s", a.socket, err.Error())
+		}
+	}
+
+	var err error
+	a.listener, err = listenUnix(a.socket)
+	return err
+}
+
+// Serve handles connections until Stop() is called
+func (a *AgentServer) Serve() error {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			a.mutex.Lock()
+			stopped := a.stopped
+			a.mutex.Unlock()
+			if stopped {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// Stop closes the listener, removes the socket and forgets all secrets
+func (a *AgentServer) Stop() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.stopped = true
+	a.store = nil
+	a.cache = map[string]agentCacheItem{}
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Close() // also removes the socket file
+}
+
+func (a *AgentServer) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(AGENT_TIMEOUT))
+
+	req := AgentRequest{}
+	resp := AgentResponse{}
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("Invalid request: %s", err.Error())
+	} else if req.Action == AGENT_STOP {
+		// respond before we close the listener
+		_ = json.NewEncoder(conn).Encode(resp)
+		if err := a.Stop(); err != nil {
+			log.WithError(err).Errorf("Unable to stop agent")
+		}
+		return
+	} else {
+		resp = a.process(req)
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.WithError(err).Warnf("Unable to send agent response")
+	}
+}
+
+func (a *AgentServer) process(req AgentRequest) AgentResponse {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	resp := AgentResponse{}
+	var err error
+
+	switch req.Action {
+	case AGENT_PING:
+	case AGENT_LOCK:
+		a.store = nil
+		a.cache = map[string]agentCacheItem{}
+		log.Infof("Agent locked")
+	case AGENT_UNLOCK:
+		if a.store == nil {
+			if a.store, err = a.open(req.Password); err != nil {
+				a.store = nil
+			} else {
+				log.Infof("Agent unlocked")
+			}
+		}
+	case AGENT_GET:
+		resp.Value, err = a.get(req.Type, req.Key)
+	case AGENT_SET:
+		err = a.set(req.Type, req.Key, req.Value)
+	case AGENT_DELETE:
+		err = a.delete(req.Type, req.Key)
+	default:
+		err = fmt.Errorf("Invalid Action: %s", req.Action)
+	}
+
+	resp.Locked = a.store == nil
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+func agentCacheKey(itemType, key string) string {
+	return fmt.Sprintf("%s|%s", itemType, key)
+}
+
+func (a *AgentServer) get(itemType, key string) (json.RawMessage, error) {
+	if a.store == nil {
+		return nil, fmt.Errorf("Agent is locked")
+	}
+
+	cacheKey := agentCacheKey(itemType, key)
+	if item, ok := a.cache[cacheKey]; ok {
+		if time.Now().Before(item.expires) {
+			return item.value, nil
+		}
+		delete(a.cache, cacheKey)
+	}
+
+	value, err := storeGet(a.store, itemType, key)
+	if err != nil {
+		return nil, err
+	}
+	a.cache[cacheKey] = agentCacheItem{
+		value:   value,
+		expires: time.Now().Add(a.ttl),
+	}
+	return value, nil
+}
+
+func (a *AgentServer) set(itemType, key string, value json.RawMessage) error {
+	if a.store == nil {
+		return fmt.Errorf("Agent is locked")
+	}
+
+	if err := storeSet(a.store, itemType, key, value); err != nil {
+		return err
+	}
+	a.cache[agentCacheKey(itemType, key)] = agentCacheItem{
+		value:   value,
+		expires: time.Now().Add(a.ttl),
+	}
+	return nil
+}
+
+func (a *AgentServer) delete(itemType, key string) error {
+	if a.store == nil {
+		return fmt.Errorf("Agent is locked")
+	}
+
+	delete(a.cache, agentCacheKey(itemType, key))
+	return storeDelete(a.store, itemType, key)
+}
+
+// storeGet returns the JSON encoded item of the given type from the SecureStorage
+func storeGet(store SecureStorage, itemType, key string) (json.RawMessage, error) {
+	var v interface{}
+	var err error
+
+	switch itemType {
+	case EXEC_STORE_REGISTER_CLIENT_DATA:
+		x := RegisterClientData{}
+		err = store.GetRegisterClientData(key, &x)
+		v = x
+	case EXEC_STORE_CREATE_TOKEN_RESPONSE:
+		x := CreateTokenResponse{}
+		err = store.GetCreateTokenResponse(key, &x)
+		v = x
+	case EXEC_STORE_ROLE_CREDENTIALS:
+		x := RoleCredentials{}
+		err = store.GetRoleCredentials(key, &x)
+		v = x
+	default:
+		return nil, fmt.Errorf("Invalid Type: %s", itemType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// storeSet saves the JSON encoded item of the given type in the SecureStorage
+func storeSet(store SecureStorage, itemType, key string, value json.RawMessage) error {
+	switch itemType {
+	case EXEC_STORE_REGISTER_CLIENT_DATA:
+		x := RegisterClientData{}
+		if err := json.Unmarshal(value, &x); err != nil {
+			return err
+		}
+		return store.SaveRegisterClientData(key, x)
+	case EXEC_STORE_CREATE_TOKEN_RESPONSE:
+		x := CreateTokenResponse{}
+		if err := json.Unmarshal(value, &x); err != nil {
+			return err
+		}
+		return store.SaveCreateTokenResponse(key, x)
+	case EXEC_STORE_ROLE_CREDENTIALS:
+		x := RoleCredentials{}
+		if err := json.Unmarshal(value, &x); err != nil {
+			return err
+		}
+		return store.SaveRoleCredentials(key, x)
+	}
+	return fmt.Errorf("Invalid Type: %s", itemType)
+}
+
+// storeDelete deletes the item of the given type from the SecureStorage
+func storeDelete(store SecureStorage, itemType, key string) error {
+	switch itemType {
+	case EXEC_STORE_REGISTER_CLIENT_DATA:
+		return store.DeleteRegisterClientData(key)
+	case EXEC_STORE_CREATE_TOKEN_RESPONSE:
+		return store.DeleteCreateTokenResponse(key)
+	case EXEC_STORE_ROLE_CREDENTIALS:
+		return store.DeleteRoleCredentials(key)
+	}
+	return fmt.Errorf("Invalid Type: %s", itemType)
+}
+
+// AgentClient implements SecureStorage by talking to an AgentServer
+type AgentClient struct {
+	socket string
+}
+
+// OpenAgentClient connects to the agent listening on the given socket
+func OpenAgentClient(socket string) (*AgentClient, error) {
+	c := AgentClient{socket: socket}
+	_, err := c.call(AgentRequest{Action: AGENT_PING})
+	return &c, err
+}
+
+// call sends a single request to the agent and returns the response
+func (c *AgentClient) call(req AgentRequest) (AgentResponse, error) {
+	resp := AgentResponse{}
+
+	conn, err := net.DialTimeout("unix", c.socket, AGENT_TIMEOUT)
+	if err != nil {
+		return resp, fmt.Errorf("Unable to connect to agent %s: %s", c.socket, err.Error())
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(AGENT_TIMEOUT))
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("Invalid response from agent: %s", err.Error())
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Lock tells the agent to forget the unlocked store and all cached secrets
+func (c *AgentClient) Lock() error {
+	_, err := c.call(AgentRequest{Action: AGENT_LOCK})
+	return err
+}
+
+// Unlock tells the agent to re-open the store with the optional password
+func (c *AgentClient) Unlock(password string) error {
+	_, err := c.call(AgentRequest{Action: AGENT_UNLOCK, Password: password})
+	return err
+}
+
+// Stop tells the agent to exit
+func (c *AgentClient) Stop() error {
+	_, err := c.call(AgentRequest{Action: AGENT_STOP})
+	return err
+}
+
+// IsLocked returns if the agent is currently locked
+func (c *AgentClient) IsLocked() (bool, error) {
+	resp, err := c.call(AgentRequest{Action: AGENT_PING})
+	return resp.Locked, err
+}
+
+func (c *AgentClient) get(itemType, key string, v interface{}) error {
+	resp, err := c.call(AgentRequest{
+		Action: AGENT_GET,
+		Type:   itemType,
+		Key:    key,
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Value, v)
+}
+
+func (c *AgentClient) set(itemType, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.call(AgentRequest{
+		Action: AGENT_SET,
+		Type:   itemType,
+		Key:    key,
+		Value:  value,
+	})
+	return err
+}
+
+func (c *AgentClient) delete(itemType, key string) error {
+	_, err := c.call(AgentRequest{
+		Action: AGENT_DELETE,
+		Type:   itemType,
+		Key:    key,
+	})
+	return err
+}
+
+// SaveRegisterClientData saves the RegisterClientData via the agent
+func (c *AgentClient) SaveRegisterClientData(key string, client RegisterClientData) error {
+	return c.set(EXEC_STORE_REGISTER_CLIENT_DATA, key, client)
+}
+
+// GetRegisterClientData retrieves the RegisterClientData via the agent
+func (c *AgentClient) GetRegisterClientData(key string, client *RegisterClientData) error {
+	return c.get(EXEC_STORE_REGISTER_CLIENT_DATA, key, client)
+}
+
+// DeleteRegisterClientData deletes the RegisterClientData via the agent
+func (c *AgentClient) DeleteRegisterClientData(key string) error {
+	return c.delete(EXEC_STORE_REGISTER_CLIENT_DATA, key)
+}
+
+// SaveCreateTokenResponse saves the CreateTokenResponse via the agent
+func (c *AgentClient) SaveCreateTokenResponse(key string, token CreateTokenResponse) error {
+	return c.set(EXEC_STORE_CREATE_TOKEN_RESPONSE, key, token)
+}
+
+// GetCreateTokenResponse retrieves the CreateTokenResponse via the agent
+func (c *AgentClient) GetCreateTokenResponse(key string, token *CreateTokenResponse) error {
+	return c.get(EXEC_STORE_CREATE_TOKEN_RESPONSE, key, token)
+}
+
+// DeleteCreateTokenResponse deletes the CreateTokenResponse via the agent
+func (c *AgentClient) DeleteCreateTokenResponse(key string) error {
+	return c.delete(EXEC_STORE_CREATE_TOKEN_RESPONSE, key)
+}
+
+// SaveRoleCredentials saves the RoleCredentials via the agent
+func (c *AgentClient) SaveRoleCredentials(arn string, token RoleCredentials) error {
+	return c.set(EXEC_STORE_ROLE_CREDENTIALS, arn, token)
+}
+
+// GetRoleCredentials retrieves the RoleCredentials via the agent
+func (c *AgentClient) GetRoleCredentials(arn string, token *RoleCredentials) error {
+	return c.get(EXEC_STORE_ROLE_CREDENTIALS, arn, token)
+}
+
+// DeleteRoleCredentials deletes the RoleCredentials via the agent
+func (c *AgentClient) DeleteRoleCredentials(arn string) error {
+	return c.delete(EXEC_STORE_ROLE_CREDENTIALS, arn)
+}
@@ -0,0 +1,217 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AgentTestSuite struct {
+	suite.Suite
+	dir     string
+	socket  string
+	backend *EncryptedStore
+	server  *AgentServer
+	client  *AgentClient
+	done    chan error
+}
+
+func TestAgentSuite(t *testing.T) {
+	s := &AgentTestSuite{}
+	suite.Run(t, s)
+}
+
+func (s *AgentTestSuite) SetupTest() {
+	t := s.T()
+	var err error
+
+	s.dir, err = os.MkdirTemp("", "test-agent")
+	assert.NoError(t, err)
+	s.socket = path.Join(s.dir, "agent", "agent.sock")
+
+	storeFile := path.Join(s.dir, "store.enc")
+	s.backend, err = OpenEncryptedStore(storeFile, staticPassphrase("justapassword"))
+	assert.NoError(t, err)
+
+	open := func(password string) (SecureStorage, error) {
+		return OpenEncryptedStore(storeFile, staticPassphrase(password))
+	}
+	s.server = NewAgentServer(s.socket, time.Minute, s.backend, open)
+	assert.NoError(t, s.server.Listen())
+
+	s.done = make(chan error, 1)
+	go func() {
+		s.done <- s.server.Serve()
+	}()
+
+	s.client, err = OpenAgentClient(s.socket)
+	assert.NoError(t, err)
+}
+
+func (s *AgentTestSuite) TearDownTest() {
+	_ = s.server.Stop()
+	<-s.done
+	os.RemoveAll(s.dir)
+}
+
+func (s *AgentTestSuite) TestSocketPermissions() {
+	t := s.T()
+
+	info, err := os.Stat(s.socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// can't start a second agent on the same socket
+	second := NewAgentServer(s.socket, time.Minute, s.backend, nil)
+	assert.Error(t, second.Listen())
+
+	_, err = OpenAgentClient(path.Join(s.dir, "missing.sock"))
+	assert.Error(t, err)
+}
+
+func (s *AgentTestSuite) TestSecureStorage() {
+	t := s.T()
+
+	rcd := RegisterClientData{ClientId: "client", ClientSecret: "secret"}
+	assert.NoError(t, s.client.SaveRegisterClientData("us-east-1", rcd))
+	rcd2 := RegisterClientData{}
+	assert.NoError(t, s.client.GetRegisterClientData("us-east-1", &rcd2))
+	assert.Equal(t, rcd, rcd2)
+	assert.NoError(t, s.client.DeleteRegisterClientData("us-east-1"))
+	assert.Error(t, s.client.GetRegisterClientData("us-east-1", &rcd2))
+
+	ctr := CreateTokenResponse{AccessToken: "token", ExpiresAt: time.Now().Unix() + 60}
+	assert.NoError(t, s.client.SaveCreateTokenResponse("key", ctr))
+	ctr2 := CreateTokenResponse{}
+	assert.NoError(t, s.client.GetCreateTokenResponse("key", &ctr2))
+	assert.Equal(t, ctr, ctr2)
+	assert.NoError(t, s.client.DeleteCreateTokenResponse("key"))
+	assert.Error(t, s.client.DeleteCreateTokenResponse("key"))
+
+	rc := RoleCredentials{RoleName: "MyRole", AccountId: 1234, SecretAccessKey: "secret"}
+	assert.NoError(t, s.client.SaveRoleCredentials("arn", rc))
+	rc2 := RoleCredentials{}
+	assert.NoError(t, s.client.GetRoleCredentials("arn", &rc2))
+	assert.Equal(t, rc, rc2)
+
+	// writes go through to the backend
+	rc3 := RoleCredentials{}
+	assert.NoError(t, s.backend.GetRoleCredentials("arn", &rc3))
+	assert.Equal(t, rc, rc3)
+
+	assert.NoError(t, s.client.DeleteRoleCredentials("arn"))
+	assert.Error(t, s.client.GetRoleCredentials("arn", &rc2))
+}
+
+func (s *AgentTestSuite) TestCache() {
+	t := s.T()
+
+	rc := RoleCredentials{RoleName: "MyRole", AccountId: 1234}
+	assert.NoError(t, s.backend.SaveRoleCredentials("arn", rc))
+
+	rc2 := RoleCredentials{}
+	assert.NoError(t, s.client.GetRoleCredentials("arn", &rc2))
+	assert.Equal(t, rc, rc2)
+
+	// served from memory until the TTL expires
+	assert.NoError(t, s.backend.DeleteRoleCredentials("arn"))
+	assert.NoError(t, s.client.GetRoleCredentials("arn", &rc2))
+
+	s.server.mutex.Lock()
+	s.server.ttl = 0
+	s.server.mutex.Unlock()
+	assert.NoError(t, s.backend.SaveRoleCredentials("arn2", rc))
+	assert.NoError(t, s.client.GetRoleCredentials("arn2", &rc2))
+	assert.NoError(t, s.backend.DeleteRoleCredentials("arn2"))
+	assert.Error(t, s.client.GetRoleCredentials("arn2", &rc2))
+}
+
+func (s *AgentTestSuite) TestLockUnlock() {
+	t := s.T()
+
+	rc := RoleCredentials{RoleName: "MyRole", AccountId: 1234}
+	assert.NoError(t, s.client.SaveRoleCredentials("arn", rc))
+
+	locked, err := s.client.IsLocked()
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	assert.NoError(t, s.client.Lock())
+	locked, err = s.client.IsLocked()
+	assert.NoError(t, err)
+	assert.True(t, locked)
+
+	rc2 := RoleCredentials{}
+	assert.Error(t, s.client.GetRoleCredentials("arn", &rc2))
+	assert.Error(t, s.client.SaveRoleCredentials("arn", rc))
+	assert.Error(t, s.client.DeleteRoleCredentials("arn"))
+
+	assert.Error(t, s.client.Unlock("wrongpassword"))
+	locked, _ = s.client.IsLocked()
+	assert.True(t, locked)
+
+	assert.NoError(t, s.client.Unlock("justapassword"))
+	locked, _ = s.client.IsLocked()
+	assert.False(t, locked)
+
+	assert.NoError(t, s.client.GetRoleCredentials("arn", &rc2))
+	assert.Equal(t, rc, rc2)
+}
+
+func (s *AgentTestSuite) TestStop() {
+	t := s.T()
+
+	assert.NoError(t, s.client.Stop())
+	assert.NoError(t, <-s.done)
+	s.done <- nil // for TearDownTest
+
+	_, err := os.Stat(s.socket)
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, s.client.Lock())
+}
+
+func TestStoreHelpers(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-agent")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	store, err := OpenEncryptedStore(path.Join(d, "store.enc"), staticPassphrase("password"))
+	assert.NoError(t, err)
+
+	for _, itemType := range []string{"Foo", ""} {
+		_, err = storeGet(store, itemType, "key")
+		assert.Error(t, err, fmt.Sprintf("get %s", itemType))
+		assert.Error(t, storeSet(store, itemType, "key", []byte("{}")))
+		assert.Error(t, storeDelete(store, itemType, "key"))
+	}
+
+	assert.Error(t, storeSet(store, EXEC_STORE_ROLE_CREDENTIALS, "key", []byte("not json")))
+	assert.NoError(t, storeSet(store, EXEC_STORE_ROLE_CREDENTIALS, "key", []byte(`{"roleName":"foo"}`)))
+	value, err := storeGet(store, EXEC_STORE_ROLE_CREDENTIALS, "key")
+	assert.NoError(t, err)
+	assert.Contains(t, string(value), `"roleName":"foo"`)
+	assert.NoError(t, storeDelete(store, EXEC_STORE_ROLE_CREDENTIALS, "key"))
+}
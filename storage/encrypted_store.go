@@ -0,0 +1,299 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/synfinatic/aws-sso-cli/utils"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	ENCRYPTED_STORE_VERSION = 1
+	ENCRYPTED_STORE_KDF     = "scrypt"
+	ENCRYPTED_STORE_AEAD    = "xchacha20poly1305"
+	// scrypt parameters recommended for interactive logins
+	SCRYPT_N        = 32768
+	SCRYPT_R        = 8
+	SCRYPT_P        = 1
+	SCRYPT_SALT_LEN = 32
+)
+
+// encryptedStoreFile is the on-disk format of the EncryptedStore.  Everything
+// except Data is non-secret and authenticated as additional data.
+type encryptedStoreFile struct {
+	Version int    `json:"Version"`
+	KDF     string `json:"KDF"`
+	N       int    `json:"N"`
+	R       int    `json:"R"`
+	P       int    `json:"P"`
+	Salt    []byte `json:"Salt"`
+	AEAD    string `json:"AEAD"`
+	Nonce   []byte `json:"Nonce"`
+	Data    []byte `json:"Data"`
+}
+
+// additionalData returns the header fields which are authenticated by the AEAD
+func (f *encryptedStoreFile) additionalData() []byte {
+	return []byte(fmt.Sprintf("aws-sso-cli|%d|%s|%d|%d|%d|%x|%s",
+		f.Version, f.KDF, f.N, f.R, f.P, f.Salt, f.AEAD))
+}
+
+// EncryptedStore implements SecureStorage using a single file encrypted
+// with a passphrase derived key.  It does not depend on any OS keyring.
+type EncryptedStore struct {
+	filename string
+	header   encryptedStoreFile
+	key      []byte
+	data     StorageData
+}
+
+// OpenEncryptedStore opens (or creates) the encrypted file at fileName and
+// decrypts it using the passphrase returned by passphrase
+func OpenEncryptedStore(fileName string, passphrase PassphraseFunc) (*EncryptedStore, error) {
+	es := EncryptedStore{
+		filename: fileName,
+		data:     NewStorageData(),
+	}
+
+	fileBytes, err := ioutil.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(fileBytes) == 0) {
+		log.Infof("Creating new encrypted store: %s", fileName)
+		pass, err := passphrase()
+		if err != nil {
+			return &es, err
+		}
+		if err = es.setPassphrase(pass); err != nil {
+			return &es, err
+		}
+		return &es, nil
+	} else if err != nil {
+		return &es, err
+	}
+
+	if err = json.Unmarshal(fileBytes, &es.header); err != nil {
+		return &es, fmt.Errorf("Unable to parse %s: %s", fileName, err.Error())
+	}
+
+	if es.header.Version != ENCRYPTED_STORE_VERSION {
+		return &es, fmt.Errorf("Unsupported encrypted store version: %d", es.header.Version)
+	}
+	if es.header.KDF != ENCRYPTED_STORE_KDF || es.header.AEAD != ENCRYPTED_STORE_AEAD {
+		return &es, fmt.Errorf("Unsupported encrypted store format: %s/%s", es.header.KDF, es.header.AEAD)
+	}
+
+	pass, err := passphrase()
+	if err != nil {
+		return &es, err
+	}
+
+	es.key, err = deriveKey(pass, es.header.Salt, es.header.N, es.header.R, es.header.P)
+	if err != nil {
+		return &es, err
+	}
+
+	aead, err := chacha20poly1305.NewX(es.key)
+	if err != nil {
+		return &es, err
+	}
+
+	plaintext, err := aead.Open(nil, es.header.Nonce, es.header.Data, es.header.additionalData())
+	if err != nil {
+		return &es, fmt.Errorf("Unable to decrypt %s: invalid passphrase or corrupted file", fileName)
+	}
+
+	if err = json.Unmarshal(plaintext, &es.data); err != nil {
+		return &es, fmt.Errorf("Unable to parse decrypted store: %s", err.Error())
+	}
+	es.initMaps()
+	return &es, nil
+}
+
+// deriveKey generates our encryption key from the passphrase and salt
+func deriveKey(passphrase string, salt []byte, n, r, p int) ([]byte, error) {
+	if passphrase == "" {
+		return []byte{}, fmt.Errorf("Empty passphrase is not allowed")
+	}
+	return scrypt.Key([]byte(passphrase), salt, n, r, p, chacha20poly1305.KeySize)
+}
+
+// setPassphrase generates a new salt & key for the given passphrase.  Does not save.
+func (es *EncryptedStore) setPassphrase(passphrase string) error {
+	salt := make([]byte, SCRYPT_SALT_LEN)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	key, err := deriveKey(passphrase, salt, SCRYPT_N, SCRYPT_R, SCRYPT_P)
+	if err != nil {
+		return err
+	}
+
+	es.key = key
+	es.header = encryptedStoreFile{
+		Version: ENCRYPTED_STORE_VERSION,
+		KDF:     ENCRYPTED_STORE_KDF,
+		N:       SCRYPT_N,
+		R:       SCRYPT_R,
+		P:       SCRYPT_P,
+		Salt:    salt,
+		AEAD:    ENCRYPTED_STORE_AEAD,
+	}
+	return nil
+}
+
+// initMaps makes sure we don't have any nil maps after loading the data
+func (es *EncryptedStore) initMaps() {
+	if es.data.RegisterClientData == nil {
+		es.data.RegisterClientData = map[string]RegisterClientData{}
+	}
+	if es.data.CreateTokenResponse == nil {
+		es.data.CreateTokenResponse = map[string]CreateTokenResponse{}
+	}
+	if es.data.RoleCredentials == nil {
+		es.data.RoleCredentials = map[string]RoleCredentials{}
+	}
+}
+
+// Rekey re-encrypts the store using the passphrase returned by passphrase
+func (es *EncryptedStore) Rekey(passphrase PassphraseFunc) error {
+	pass, err := passphrase()
+	if err != nil {
+		return err
+	}
+	if err = es.setPassphrase(pass); err != nil {
+		return err
+	}
+	return es.save()
+}
+
+// save encrypts and writes the store file with a fresh nonce
+func (es *EncryptedStore) save() error {
+	log.Debugf("Saving encrypted store")
+	plaintext, err := json.Marshal(es.data)
+	if err != nil {
+		return err
+	}
+
+	aead, err := chacha20poly1305.NewX(es.key)
+	if err != nil {
+		return err
+	}
+
+	es.header.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(es.header.Nonce); err != nil {
+		return err
+	}
+	es.header.Data = aead.Seal(nil, es.header.Nonce, plaintext, es.header.additionalData())
+
+	fileBytes, err := json.MarshalIndent(es.header, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = utils.EnsureDirExists(es.filename); err != nil {
+		return err
+	}
+
+	// write to a temp file and rename so we never leave a truncated store behind
+	tmpFile := es.filename + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, fileBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, es.filename)
+}
+
+// SaveRegisterClientData saves the RegisterClientData in our encrypted store
+func (es *EncryptedStore) SaveRegisterClientData(key string, client RegisterClientData) error {
+	es.data.RegisterClientData[key] = client
+	return es.save()
+}
+
+// GetRegisterClientData retrieves the RegisterClientData from our encrypted store
+func (es *EncryptedStore) GetRegisterClientData(key string, client *RegisterClientData) error {
+	var ok bool
+	if *client, ok = es.data.RegisterClientData[key]; !ok {
+		return fmt.Errorf("No RegisterClientData for %s", key)
+	}
+	return nil
+}
+
+// DeleteRegisterClientData deletes the RegisterClientData from the encrypted store
+func (es *EncryptedStore) DeleteRegisterClientData(key string) error {
+	if _, ok := es.data.RegisterClientData[key]; !ok {
+		return fmt.Errorf("Missing RegisterClientData for key: %s", key)
+	}
+	delete(es.data.RegisterClientData, key)
+	return es.save()
+}
+
+// SaveCreateTokenResponse stores the token in the encrypted store
+func (es *EncryptedStore) SaveCreateTokenResponse(key string, token CreateTokenResponse) error {
+	es.data.CreateTokenResponse[key] = token
+	return es.save()
+}
+
+// GetCreateTokenResponse retrieves the CreateTokenResponse from the encrypted store
+func (es *EncryptedStore) GetCreateTokenResponse(key string, token *CreateTokenResponse) error {
+	var ok bool
+	if *token, ok = es.data.CreateTokenResponse[key]; !ok {
+		return fmt.Errorf("No CreateTokenResponse for %s", key)
+	}
+	return nil
+}
+
+// DeleteCreateTokenResponse deletes the token from the encrypted store
+func (es *EncryptedStore) DeleteCreateTokenResponse(key string) error {
+	if _, ok := es.data.CreateTokenResponse[key]; !ok {
+		return fmt.Errorf("Missing CreateTokenResponse for key: %s", key)
+	}
+	delete(es.data.CreateTokenResponse, key)
+	return es.save()
+}
+
+// SaveRoleCredentials stores the token in the encrypted store
+func (es *EncryptedStore) SaveRoleCredentials(arn string, token RoleCredentials) error {
+	es.data.RoleCredentials[arn] = token
+	return es.save()
+}
+
+// GetRoleCredentials retrieves the RoleCredentials from the encrypted store
+func (es *EncryptedStore) GetRoleCredentials(arn string, token *RoleCredentials) error {
+	var ok bool
+	if *token, ok = es.data.RoleCredentials[arn]; !ok {
+		return fmt.Errorf("No RoleCredentials for %s", arn)
+	}
+	return nil
+}
+
+// DeleteRoleCredentials deletes the token from the encrypted store
+func (es *EncryptedStore) DeleteRoleCredentials(arn string) error {
+	if _, ok := es.data.RoleCredentials[arn]; !ok {
+		return fmt.Errorf("Missing RoleCredentials for arn: %s", arn)
+	}
+	delete(es.data.RoleCredentials, arn)
+	return es.save()
+}
@@ -0,0 +1,230 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type EncryptedStoreTestSuite struct {
+	suite.Suite
+	dir   string
+	file  string
+	store *EncryptedStore
+}
+
+func TestEncryptedStoreSuite(t *testing.T) {
+	s := &EncryptedStoreTestSuite{}
+	suite.Run(t, s)
+}
+
+func staticPassphrase(pass string) PassphraseFunc {
+	return func() (string, error) {
+		return pass, nil
+	}
+}
+
+func (s *EncryptedStoreTestSuite) SetupTest() {
+	t := s.T()
+	var err error
+
+	s.dir, err = os.MkdirTemp("", "test-encrypted-store")
+	assert.NoError(t, err)
+	s.file = path.Join(s.dir, "store.enc")
+
+	s.store, err = OpenEncryptedStore(s.file, staticPassphrase("justapassword"))
+	assert.NoError(t, err)
+}
+
+func (s *EncryptedStoreTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *EncryptedStoreTestSuite) TestRoundTrip() {
+	t := s.T()
+
+	rcd := RegisterClientData{
+		ClientId:              "not a real client id",
+		ClientSecret:          "not a real secret",
+		ClientSecretExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+	}
+	ctr := CreateTokenResponse{
+		AccessToken: "not a real token",
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+	}
+	rc := RoleCredentials{
+		RoleName:        "MyRole",
+		AccountId:       234566767,
+		AccessKeyId:     "some not-so-secret-string",
+		SecretAccessKey: "a string we actually want to keep secret",
+		SessionToken:    "Another secret string",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}
+
+	assert.NoError(t, s.store.SaveRegisterClientData("client", rcd))
+	assert.NoError(t, s.store.SaveCreateTokenResponse("token", ctr))
+	assert.NoError(t, s.store.SaveRoleCredentials("arn", rc))
+
+	// secrets must not be written in cleartext
+	fileBytes, err := ioutil.ReadFile(s.file)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(fileBytes), rc.SecretAccessKey)
+
+	store, err := OpenEncryptedStore(s.file, staticPassphrase("justapassword"))
+	assert.NoError(t, err)
+
+	rcd2 := RegisterClientData{}
+	assert.NoError(t, store.GetRegisterClientData("client", &rcd2))
+	assert.Equal(t, rcd, rcd2)
+
+	ctr2 := CreateTokenResponse{}
+	assert.NoError(t, store.GetCreateTokenResponse("token", &ctr2))
+	assert.Equal(t, ctr, ctr2)
+
+	rc2 := RoleCredentials{}
+	assert.NoError(t, store.GetRoleCredentials("arn", &rc2))
+	assert.Equal(t, rc, rc2)
+
+	assert.Error(t, store.GetRegisterClientData("missing", &rcd2))
+	assert.Error(t, store.GetCreateTokenResponse("missing", &ctr2))
+	assert.Error(t, store.GetRoleCredentials("missing", &rc2))
+
+	assert.NoError(t, store.DeleteRegisterClientData("client"))
+	assert.NoError(t, store.DeleteCreateTokenResponse("token"))
+	assert.NoError(t, store.DeleteRoleCredentials("arn"))
+
+	assert.Error(t, store.DeleteRegisterClientData("client"))
+	assert.Error(t, store.DeleteCreateTokenResponse("token"))
+	assert.Error(t, store.DeleteRoleCredentials("arn"))
+}
+
+func (s *EncryptedStoreTestSuite) TestBadPassphrase() {
+	t := s.T()
+
+	assert.NoError(t, s.store.SaveRoleCredentials("arn", RoleCredentials{RoleName: "foo"}))
+
+	_, err := OpenEncryptedStore(s.file, staticPassphrase("wrongpassword"))
+	assert.Error(t, err)
+
+	_, err = OpenEncryptedStore(s.file, staticPassphrase(""))
+	assert.Error(t, err)
+
+	_, err = OpenEncryptedStore(path.Join(s.dir, "new.enc"), func() (string, error) {
+		return "", fmt.Errorf("no password")
+	})
+	assert.Error(t, err)
+}
+
+func (s *EncryptedStoreTestSuite) TestCorruptFile() {
+	t := s.T()
+
+	assert.NoError(t, ioutil.WriteFile(s.file, []byte("not json"), 0600))
+	_, err := OpenEncryptedStore(s.file, staticPassphrase("justapassword"))
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(s.file, []byte(`{"Version": 99}`), 0600))
+	_, err = OpenEncryptedStore(s.file, staticPassphrase("justapassword"))
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(s.file, []byte(`{"Version": 1, "KDF": "md5"}`), 0600))
+	_, err = OpenEncryptedStore(s.file, staticPassphrase("justapassword"))
+	assert.Error(t, err)
+}
+
+func (s *EncryptedStoreTestSuite) TestRekey() {
+	t := s.T()
+
+	rc := RoleCredentials{RoleName: "MyRole", AccountId: 1234}
+	assert.NoError(t, s.store.SaveRoleCredentials("arn", rc))
+	assert.NoError(t, s.store.Rekey(staticPassphrase("anewpassword")))
+
+	_, err := OpenEncryptedStore(s.file, staticPassphrase("justapassword"))
+	assert.Error(t, err)
+
+	store, err := OpenEncryptedStore(s.file, staticPassphrase("anewpassword"))
+	assert.NoError(t, err)
+
+	rc2 := RoleCredentials{}
+	assert.NoError(t, store.GetRoleCredentials("arn", &rc2))
+	assert.Equal(t, rc, rc2)
+
+	assert.Error(t, s.store.Rekey(staticPassphrase("")))
+}
+
+func TestPassphraseSource(t *testing.T) {
+	os.Setenv("AWS_SSO_TEST_PASSWORD", "fromenv")
+	defer os.Unsetenv("AWS_SSO_TEST_PASSWORD")
+
+	ps := &PassphraseSource{EnvVar: "AWS_SSO_TEST_PASSWORD"}
+	pass, err := ps.Func()()
+	assert.NoError(t, err)
+	assert.Equal(t, "fromenv", pass)
+
+	// cached value wins even after the env var changes
+	os.Setenv("AWS_SSO_TEST_PASSWORD", "changed")
+	pass, err = ps.Func()()
+	assert.NoError(t, err)
+	assert.Equal(t, "fromenv", pass)
+
+	// file descriptor
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	_, err = w.WriteString("fromfd\n")
+	assert.NoError(t, err)
+	w.Close()
+	os.Setenv("AWS_SSO_TEST_PASSWORD_FD", fmt.Sprintf("%d", r.Fd()))
+	defer os.Unsetenv("AWS_SSO_TEST_PASSWORD_FD")
+
+	ps = &PassphraseSource{EnvVar: "AWS_SSO_TEST_MISSING", FdEnvVar: "AWS_SSO_TEST_PASSWORD_FD"}
+	pass, err = ps.Func()()
+	assert.NoError(t, err)
+	assert.Equal(t, "fromfd", pass)
+
+	os.Setenv("AWS_SSO_TEST_PASSWORD_FD", "notanumber")
+	ps = &PassphraseSource{FdEnvVar: "AWS_SSO_TEST_PASSWORD_FD"}
+	_, err = ps.Func()()
+	assert.Error(t, err)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// helper command
+	ps = &PassphraseSource{Command: []string{"echo", "fromcommand"}}
+	pass, err = ps.Func()()
+	assert.NoError(t, err)
+	assert.Equal(t, "fromcommand", pass)
+
+	ps = &PassphraseSource{Command: []string{"false"}}
+	_, err = ps.Func()()
+	assert.Error(t, err)
+
+	ps = &PassphraseSource{Command: []string{"true"}}
+	_, err = ps.Func()()
+	assert.Error(t, err)
+}
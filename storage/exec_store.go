@@ -0,0 +1,294 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/synfinatic/aws-sso-cli/utils"
+)
+
+const (
+	EXEC_STORE_GET    = "get"
+	EXEC_STORE_SET    = "set"
+	EXEC_STORE_DELETE = "delete"
+	EXEC_STORE_LIST   = "list"
+
+	EXEC_STORE_REGISTER_CLIENT_DATA  = "RegisterClientData"
+	EXEC_STORE_CREATE_TOKEN_RESPONSE = "CreateTokenResponse"
+	EXEC_STORE_ROLE_CREDENTIALS      = "RoleCredentials"
+)
+
+// ExecStoreRequest is written as JSON to the stdin of the helper command
+type ExecStoreRequest struct {
+	Action string          `json:"Action"`          // get|set|delete|list
+	Type   string          `json:"Type"`            // RegisterClientData|CreateTokenResponse|RoleCredentials
+	Key    string          `json:"Key,omitempty"`   // not used for list
+	Value  json.RawMessage `json:"Value,omitempty"` // only used for set
+}
+
+// ExecStoreResponse is read as JSON from the stdout of the helper command
+type ExecStoreResponse struct {
+	Value json.RawMessage `json:"Value,omitempty"` // only returned for get
+	Keys  []string        `json:"Keys,omitempty"`  // only returned for list
+	Error string          `json:"Error,omitempty"` // set on any failure
+}
+
+// ExecStore implements SecureStorage by delegating to an external command
+type ExecStore struct {
+	command []string
+}
+
+// OpenExecStore returns an ExecStore which runs the given command for every operation
+func OpenExecStore(command []string) (*ExecStore, error) {
+	if len(command) == 0 || command[0] == "" {
+		return &ExecStore{}, fmt.Errorf("ExecStoreCommand is not configured")
+	}
+	return &ExecStore{command: command}, nil
+}
+
+// call executes our helper command with the request and parses the response
+func (es *ExecStore) call(req ExecStoreRequest) (ExecStoreResponse, error) {
+	resp := ExecStoreResponse{}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(es.command[0], es.command[1:]...) // #nosec
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	log.Debugf("ExecStore %s %s %s", req.Action, req.Type, req.Key)
+	runErr := cmd.Run()
+
+	if err = json.Unmarshal(stdout.Bytes(), &resp); err != nil && runErr == nil {
+		return resp, fmt.Errorf("Invalid response from `%s`: %s", es.command[0], err.Error())
+	}
+
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s", resp.Error)
+	} else if runErr != nil {
+		return resp, fmt.Errorf("Unable to exec `%s`: %s", strings.Join(es.command, " "), runErr.Error())
+	}
+	return resp, nil
+}
+
+func (es *ExecStore) get(itemType, key string, v interface{}) error {
+	resp, err := es.call(ExecStoreRequest{
+		Action: EXEC_STORE_GET,
+		Type:   itemType,
+		Key:    key,
+	})
+	if err != nil {
+		return fmt.Errorf("No %s for %s: %s", itemType, key, err.Error())
+	}
+	return json.Unmarshal(resp.Value, v)
+}
+
+func (es *ExecStore) set(itemType, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = es.call(ExecStoreRequest{
+		Action: EXEC_STORE_SET,
+		Type:   itemType,
+		Key:    key,
+		Value:  value,
+	})
+	return err
+}
+
+func (es *ExecStore) delete(itemType, key string) error {
+	_, err := es.call(ExecStoreRequest{
+		Action: EXEC_STORE_DELETE,
+		Type:   itemType,
+		Key:    key,
+	})
+	return err
+}
+
+// List returns all the keys of the given type stored by the helper
+func (es *ExecStore) List(itemType string) ([]string, error) {
+	resp, err := es.call(ExecStoreRequest{
+		Action: EXEC_STORE_LIST,
+		Type:   itemType,
+	})
+	return resp.Keys, err
+}
+
+// SaveRegisterClientData saves the RegisterClientData via the helper
+func (es *ExecStore) SaveRegisterClientData(key string, client RegisterClientData) error {
+	return es.set(EXEC_STORE_REGISTER_CLIENT_DATA, key, client)
+}
+
+// GetRegisterClientData retrieves the RegisterClientData via the helper
+func (es *ExecStore) GetRegisterClientData(key string, client *RegisterClientData) error {
+	return es.get(EXEC_STORE_REGISTER_CLIENT_DATA, key, client)
+}
+
+// DeleteRegisterClientData deletes the RegisterClientData via the helper
+func (es *ExecStore) DeleteRegisterClientData(key string) error {
+	return es.delete(EXEC_STORE_REGISTER_CLIENT_DATA, key)
+}
+
+// SaveCreateTokenResponse saves the CreateTokenResponse via the helper
+func (es *ExecStore) SaveCreateTokenResponse(key string, token CreateTokenResponse) error {
+	return es.set(EXEC_STORE_CREATE_TOKEN_RESPONSE, key, token)
+}
+
+// GetCreateTokenResponse retrieves the CreateTokenResponse via the helper
+func (es *ExecStore) GetCreateTokenResponse(key string, token *CreateTokenResponse) error {
+	return es.get(EXEC_STORE_CREATE_TOKEN_RESPONSE, key, token)
+}
+
+// DeleteCreateTokenResponse deletes the CreateTokenResponse via the helper
+func (es *ExecStore) DeleteCreateTokenResponse(key string) error {
+	return es.delete(EXEC_STORE_CREATE_TOKEN_RESPONSE, key)
+}
+
+// SaveRoleCredentials saves the RoleCredentials via the helper
+func (es *ExecStore) SaveRoleCredentials(arn string, token RoleCredentials) error {
+	return es.set(EXEC_STORE_ROLE_CREDENTIALS, arn, token)
+}
+
+// GetRoleCredentials retrieves the RoleCredentials via the helper
+func (es *ExecStore) GetRoleCredentials(arn string, token *RoleCredentials) error {
+	return es.get(EXEC_STORE_ROLE_CREDENTIALS, arn, token)
+}
+
+// DeleteRoleCredentials deletes the RoleCredentials via the helper
+func (es *ExecStore) DeleteRoleCredentials(arn string) error {
+	return es.delete(EXEC_STORE_ROLE_CREDENTIALS, arn)
+}
+
+// ExecStoreHelper is the reference implementation of the helper side of the
+// ExecStore protocol.  It stores the opaque values in a local JSON file.
+type ExecStoreHelper struct {
+	filename string
+	Items    map[string]map[string]json.RawMessage `json:"Items"` // Type => Key => Value
+}
+
+// NewExecStoreHelper loads the helper's JSON file if it exists
+func NewExecStoreHelper(fileName string) (*ExecStoreHelper, error) {
+	h := ExecStoreHelper{
+		filename: fileName,
+		Items:    map[string]map[string]json.RawMessage{},
+	}
+
+	fileBytes, err := ioutil.ReadFile(fileName)
+	if err == nil && len(fileBytes) > 0 {
+		err = json.Unmarshal(fileBytes, &h)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return &h, err
+}
+
+func (h *ExecStoreHelper) save() error {
+	jbytes, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = utils.EnsureDirExists(h.filename); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(h.filename, jbytes, 0600)
+}
+
+// Handle reads a single ExecStoreRequest from in and writes the ExecStoreResponse to out.
+// The returned error is also reported to the caller in the response.
+func (h *ExecStoreHelper) Handle(in io.Reader, out io.Writer) error {
+	req := ExecStoreRequest{}
+	resp := ExecStoreResponse{}
+
+	err := json.NewDecoder(in).Decode(&req)
+	if err == nil {
+		resp, err = h.process(req)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	if jerr := json.NewEncoder(out).Encode(resp); jerr != nil {
+		return jerr
+	}
+	return err
+}
+
+func (h *ExecStoreHelper) process(req ExecStoreRequest) (ExecStoreResponse, error) {
+	resp := ExecStoreResponse{}
+
+	switch req.Type {
+	case EXEC_STORE_REGISTER_CLIENT_DATA, EXEC_STORE_CREATE_TOKEN_RESPONSE, EXEC_STORE_ROLE_CREDENTIALS:
+	default:
+		return resp, fmt.Errorf("Invalid Type: %s", req.Type)
+	}
+
+	if _, ok := h.Items[req.Type]; !ok {
+		h.Items[req.Type] = map[string]json.RawMessage{}
+	}
+	items := h.Items[req.Type]
+
+	switch req.Action {
+	case EXEC_STORE_GET:
+		value, ok := items[req.Key]
+		if !ok {
+			return resp, fmt.Errorf("%s not found", req.Key)
+		}
+		resp.Value = value
+
+	case EXEC_STORE_SET:
+		if len(req.Value) == 0 {
+			return resp, fmt.Errorf("Missing Value for %s", req.Key)
+		}
+		items[req.Key] = req.Value
+		return resp, h.save()
+
+	case EXEC_STORE_DELETE:
+		if _, ok := items[req.Key]; !ok {
+			return resp, fmt.Errorf("%s not found", req.Key)
+		}
+		delete(items, req.Key)
+		return resp, h.save()
+
+	case EXEC_STORE_LIST:
+		resp.Keys = []string{}
+		for k := range items {
+			resp.Keys = append(resp.Keys, k)
+		}
+		sort.Strings(resp.Keys)
+
+	default:
+		return resp, fmt.Errorf("Invalid Action: %s", req.Action)
+	}
+	return resp, nil
+}
@@ -0,0 +1,214 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const ENV_EXEC_STORE_HELPER = "AWS_SSO_TEST_EXEC_STORE_HELPER"
+
+// TestExecStoreHelperProcess isn't a real test.  It lets the test binary act as
+// the reference ExecStore helper for the conformance tests below.
+func TestExecStoreHelperProcess(t *testing.T) {
+	helperFile := os.Getenv(ENV_EXEC_STORE_HELPER)
+	if helperFile == "" {
+		return
+	}
+
+	helper, err := NewExecStoreHelper(helperFile)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err = helper.Handle(os.Stdin, os.Stdout); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+type ExecStoreTestSuite struct {
+	suite.Suite
+	dir   string
+	store *ExecStore
+}
+
+func TestExecStoreSuite(t *testing.T) {
+	s := &ExecStoreTestSuite{}
+	suite.Run(t, s)
+}
+
+func (s *ExecStoreTestSuite) SetupTest() {
+	t := s.T()
+	var err error
+
+	s.dir, err = os.MkdirTemp("", "test-exec-store")
+	assert.NoError(t, err)
+
+	os.Setenv(ENV_EXEC_STORE_HELPER, path.Join(s.dir, "helper.json"))
+	s.store, err = OpenExecStore([]string{os.Args[0], "-test.run=TestExecStoreHelperProcess"})
+	assert.NoError(t, err)
+}
+
+func (s *ExecStoreTestSuite) TearDownTest() {
+	os.Unsetenv(ENV_EXEC_STORE_HELPER)
+	os.RemoveAll(s.dir)
+}
+
+func (s *ExecStoreTestSuite) TestRegisterClientData() {
+	t := s.T()
+
+	rcd := RegisterClientData{
+		AuthorizationEndpoint: "https://foobar.com",
+		ClientId:              "ThisIsNotARealClientId",
+		ClientIdIssuedAt:      time.Now().Unix(),
+		ClientSecret:          "WeAllWishForGreatness",
+		ClientSecretExpiresAt: time.Now().Unix() + 1,
+	}
+
+	assert.NoError(t, s.store.SaveRegisterClientData("foo", rcd))
+
+	rcd2 := RegisterClientData{}
+	assert.NoError(t, s.store.GetRegisterClientData("foo", &rcd2))
+	assert.Equal(t, rcd, rcd2)
+	assert.Error(t, s.store.GetRegisterClientData("bar", &rcd2))
+
+	keys, err := s.store.List(EXEC_STORE_REGISTER_CLIENT_DATA)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+
+	assert.NoError(t, s.store.DeleteRegisterClientData("foo"))
+	assert.Error(t, s.store.DeleteRegisterClientData("foo"))
+	assert.Error(t, s.store.GetRegisterClientData("foo", &rcd2))
+}
+
+func (s *ExecStoreTestSuite) TestCreateTokenResponse() {
+	t := s.T()
+
+	ctr := CreateTokenResponse{
+		AccessToken:  "Foobar",
+		ExpiresIn:    60,
+		ExpiresAt:    time.Now().Unix() + 60,
+		IdToken:      "hellothere",
+		RefreshToken: "just another token",
+		TokenType:    "yes",
+	}
+
+	assert.NoError(t, s.store.SaveCreateTokenResponse("foo", ctr))
+
+	ctr2 := CreateTokenResponse{}
+	assert.NoError(t, s.store.GetCreateTokenResponse("foo", &ctr2))
+	assert.Equal(t, ctr, ctr2)
+	assert.Error(t, s.store.GetCreateTokenResponse("bar", &ctr2))
+
+	keys, err := s.store.List(EXEC_STORE_CREATE_TOKEN_RESPONSE)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+
+	assert.NoError(t, s.store.DeleteCreateTokenResponse("foo"))
+	assert.Error(t, s.store.DeleteCreateTokenResponse("foo"))
+}
+
+func (s *ExecStoreTestSuite) TestRoleCredentials() {
+	t := s.T()
+
+	rc := RoleCredentials{
+		RoleName:        "MyRole",
+		AccountId:       234566767,
+		AccessKeyId:     "some not-so-secret-string",
+		SecretAccessKey: "a string we actually want to keep secret",
+		SessionToken:    "Another secret string",
+		Expiration:      time.Now().UnixMilli(),
+	}
+	arn := "arn:aws:iam::000234566767:role/MyRole"
+
+	assert.NoError(t, s.store.SaveRoleCredentials(arn, rc))
+
+	rc2 := RoleCredentials{}
+	assert.NoError(t, s.store.GetRoleCredentials(arn, &rc2))
+	assert.Equal(t, rc, rc2)
+	assert.Error(t, s.store.GetRoleCredentials("bar", &rc2))
+
+	keys, err := s.store.List(EXEC_STORE_ROLE_CREDENTIALS)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{arn}, keys)
+
+	// types are independent of each other
+	keys, err = s.store.List(EXEC_STORE_REGISTER_CLIENT_DATA)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	assert.NoError(t, s.store.DeleteRoleCredentials(arn))
+	assert.Error(t, s.store.DeleteRoleCredentials(arn))
+}
+
+func (s *ExecStoreTestSuite) TestBadHelper() {
+	t := s.T()
+
+	_, err := OpenExecStore([]string{})
+	assert.Error(t, err)
+
+	store, err := OpenExecStore([]string{path.Join(s.dir, "does-not-exist")})
+	assert.NoError(t, err)
+	assert.Error(t, store.SaveRoleCredentials("foo", RoleCredentials{}))
+	assert.Error(t, store.GetRoleCredentials("foo", &RoleCredentials{}))
+	_, err = store.List(EXEC_STORE_ROLE_CREDENTIALS)
+	assert.Error(t, err)
+}
+
+func TestExecStoreHelperHandle(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-exec-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	helper, err := NewExecStoreHelper(path.Join(d, "helper.json"))
+	assert.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	assert.Error(t, helper.Handle(bytes.NewBufferString("not json"), out))
+	assert.Contains(t, out.String(), `"Error"`)
+
+	out.Reset()
+	assert.Error(t, helper.Handle(bytes.NewBufferString(`{"Action":"get","Type":"Foo","Key":"x"}`), out))
+	assert.Contains(t, out.String(), "Invalid Type")
+
+	out.Reset()
+	assert.Error(t, helper.Handle(bytes.NewBufferString(`{"Action":"what","Type":"RoleCredentials"}`), out))
+	assert.Contains(t, out.String(), "Invalid Action")
+
+	out.Reset()
+	assert.Error(t, helper.Handle(bytes.NewBufferString(`{"Action":"set","Type":"RoleCredentials","Key":"x"}`), out))
+	assert.Contains(t, out.String(), "Missing Value")
+
+	out.Reset()
+	assert.NoError(t, helper.Handle(bytes.NewBufferString(`{"Action":"set","Type":"RoleCredentials","Key":"x","Value":{"roleName":"foo"}}`), out))
+
+	// reload from disk
+	helper, err = NewExecStoreHelper(path.Join(d, "helper.json"))
+	assert.NoError(t, err)
+	out.Reset()
+	assert.NoError(t, helper.Handle(bytes.NewBufferString(`{"Action":"get","Type":"RoleCredentials","Key":"x"}`), out))
+	assert.Contains(t, out.String(), `"roleName":"foo"`)
+}
@@ -0,0 +1,78 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"time"
+)
+
+// GCResult lists the keys of the expired items which were deleted
+type GCResult struct {
+	RegisterClientData  []string
+	CreateTokenResponse []string
+	RoleCredentials     []string
+}
+
+// GarbageCollect deletes all the items in keys which have already expired.
+// Unlike the Expired() methods, no safety margin is used so that items which
+// are about to expire are still available.
+func GarbageCollect(store SecureStorage, keys StoreKeys) (GCResult, error) {
+	result := GCResult{
+		RegisterClientData:  []string{},
+		CreateTokenResponse: []string{},
+		RoleCredentials:     []string{},
+	}
+	now := time.Now()
+
+	for _, key := range keys.SSO {
+		client := RegisterClientData{}
+		// a zero ClientSecretExpiresAt means the secret has no known expiry
+		if err := store.GetRegisterClientData(key, &client); err == nil &&
+			client.ClientSecretExpiresAt != 0 && client.ClientSecretExpiresAt <= now.Unix() {
+			if err = store.DeleteRegisterClientData(key); err != nil {
+				return result, fmt.Errorf("Unable to delete RegisterClientData for %s: %s", key, err.Error())
+			}
+			result.RegisterClientData = append(result.RegisterClientData, key)
+		}
+
+		token := CreateTokenResponse{}
+		if err := store.GetCreateTokenResponse(key, &token); err == nil && token.ExpiresAt <= now.Unix() {
+			if err = store.DeleteCreateTokenResponse(key); err != nil {
+				return result, fmt.Errorf("Unable to delete CreateTokenResponse for %s: %s", key, err.Error())
+			}
+			result.CreateTokenResponse = append(result.CreateTokenResponse, key)
+		}
+	}
+
+	for _, arn := range keys.Roles {
+		creds := RoleCredentials{}
+		if err := store.GetRoleCredentials(arn, &creds); err == nil && creds.Expiration <= now.UnixMilli() {
+			if err = store.DeleteRoleCredentials(arn); err != nil {
+				return result, fmt.Errorf("Unable to delete RoleCredentials for %s: %s", arn, err.Error())
+			}
+			result.RoleCredentials = append(result.RoleCredentials, arn)
+		}
+	}
+
+	if count := len(result.RegisterClientData) + len(result.CreateTokenResponse) + len(result.RoleCredentials); count > 0 {
+		log.Debugf("Deleted %d expired items from the SecureStore", count)
+	}
+	return result, nil
+}
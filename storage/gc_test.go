@@ -0,0 +1,96 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	store, err := newKeyringStore(newMemoryKeyringApi(), keyring.Config{})
+	assert.NoError(t, err)
+
+	now := time.Now()
+	assert.NoError(t, store.SaveRegisterClientData("expired", RegisterClientData{ClientSecretExpiresAt: now.Add(-time.Minute).Unix()}))
+	// expires within the Expired() safety margin, but is still valid
+	assert.NoError(t, store.SaveRegisterClientData("valid", RegisterClientData{ClientSecretExpiresAt: now.Add(30 * time.Minute).Unix()}))
+	assert.NoError(t, store.SaveRegisterClientData("noexpiry", RegisterClientData{}))
+	assert.NoError(t, store.SaveCreateTokenResponse("expired", CreateTokenResponse{ExpiresAt: now.Add(-time.Minute).Unix()}))
+	assert.NoError(t, store.SaveCreateTokenResponse("valid", CreateTokenResponse{ExpiresAt: now.Add(30 * time.Second).Unix()}))
+	assert.NoError(t, store.SaveRoleCredentials("arn-expired", RoleCredentials{Expiration: now.Add(-time.Minute).UnixMilli()}))
+	assert.NoError(t, store.SaveRoleCredentials("arn-valid", RoleCredentials{Expiration: now.Add(30 * time.Second).UnixMilli()}))
+
+	keys := StoreKeys{
+		SSO:   []string{"expired", "valid", "noexpiry", "missing"},
+		Roles: []string{"arn-expired", "arn-valid", "arn-missing"},
+	}
+	result, err := GarbageCollect(store, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"expired"}, result.RegisterClientData)
+	assert.Equal(t, []string{"expired"}, result.CreateTokenResponse)
+	assert.Equal(t, []string{"arn-expired"}, result.RoleCredentials)
+
+	assert.Error(t, store.GetRegisterClientData("expired", &RegisterClientData{}))
+	assert.NoError(t, store.GetRegisterClientData("valid", &RegisterClientData{}))
+	assert.NoError(t, store.GetRegisterClientData("noexpiry", &RegisterClientData{}))
+	assert.Error(t, store.GetCreateTokenResponse("expired", &CreateTokenResponse{}))
+	assert.NoError(t, store.GetCreateTokenResponse("valid", &CreateTokenResponse{}))
+	assert.Error(t, store.GetRoleCredentials("arn-expired", &RoleCredentials{}))
+	assert.NoError(t, store.GetRoleCredentials("arn-valid", &RoleCredentials{}))
+
+	// second pass has nothing to do
+	result, err = GarbageCollect(store, keys)
+	assert.NoError(t, err)
+	assert.Empty(t, result.RegisterClientData)
+	assert.Empty(t, result.CreateTokenResponse)
+	assert.Empty(t, result.RoleCredentials)
+}
+
+// readOnlyKeyringApi can't remove items
+type readOnlyKeyringApi struct {
+	*memoryKeyringApi
+}
+
+func (r *readOnlyKeyringApi) Remove(key string) error {
+	return fmt.Errorf("Unable to remove %s", key)
+}
+
+func TestGarbageCollectErrors(t *testing.T) {
+	ring := &readOnlyKeyringApi{newMemoryKeyringApi()}
+	store, err := newKeyringStore(ring, keyring.Config{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.SaveRegisterClientData("key", RegisterClientData{ClientSecretExpiresAt: 1}))
+	_, err = GarbageCollect(store, StoreKeys{SSO: []string{"key"}})
+	assert.Error(t, err)
+
+	ring.items = map[string]keyring.Item{}
+	assert.NoError(t, store.SaveCreateTokenResponse("key", CreateTokenResponse{}))
+	_, err = GarbageCollect(store, StoreKeys{SSO: []string{"key"}})
+	assert.Error(t, err)
+
+	assert.NoError(t, store.SaveRoleCredentials("arn", RoleCredentials{}))
+	_, err = GarbageCollect(store, StoreKeys{Roles: []string{"arn"}})
+	assert.Error(t, err)
+}
@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 
 	// "github.com/davecgh/go-spew/spew"
 	"github.com/synfinatic/aws-sso-cli/utils"
@@ -47,9 +48,10 @@ func OpenJsonStore(fileName string) (*JsonStore, error) {
 	}
 
 	cacheBytes, err := ioutil.ReadFile(fileName)
-	if err != nil {
+	if os.IsNotExist(err) {
 		log.Infof("Creating new cache file: %s", fileName)
-	} else if len(cacheBytes) > 0 {
+		err = nil
+	} else if err == nil && len(cacheBytes) > 0 {
 		err = json.Unmarshal(cacheBytes, &cache)
 	}
 
@@ -156,3 +156,16 @@ func (s *JsonStoreTestSuite) TestCreateTokenResponse() {
 	err = s.json.GetCreateTokenResponse(key, &tr)
 	assert.NotNil(t, err)
 }
+
+func TestOpenJsonStoreErrors(t *testing.T) {
+	d := t.TempDir()
+
+	// missing files are created on save
+	js, err := OpenJsonStore(d + "/missing.json")
+	assert.NoError(t, err)
+	assert.Empty(t, js.RoleCredentials)
+
+	// other read errors must not be ignored or we'd overwrite the file
+	_, err = OpenJsonStore(d)
+	assert.Error(t, err)
+}
@@ -36,6 +36,7 @@ const (
 	KEYRING_NAME                 = "awsssocli"
 	REGISTER_CLIENT_DATA_PREFIX  = "client-data"
 	CREATE_TOKEN_RESPONSE_PREFIX = "token-response"
+	ROLE_CREDENTIALS_PREFIX      = "role-credentials"
 	ENV_SSO_FILE_PASSWORD        = "AWS_SSO_FILE_PASSWORD" // #nosec
 )
 
@@ -62,6 +63,12 @@ type KeyringApi interface {
 }
 
 func NewKeyringConfig(name, configDir string) (*keyring.Config, error) {
+	return NewKeyringConfigPassword(name, configDir, "")
+}
+
+// NewKeyringConfigPassword is just like NewKeyringConfig(), but the file
+// backend uses the given password instead of prompting the user
+func NewKeyringConfigPassword(name, configDir, password string) (*keyring.Config, error) {
 	securePath := path.Join(configDir, "secure")
 
 	c := keyring.Config{
@@ -79,34 +86,54 @@ func NewKeyringConfig(name, configDir string) (*keyring.Config, error) {
 		KWalletFolder:           KEYRING_ID,
 		WinCredPrefix:           KEYRING_ID,
 	}
+	if password != "" {
+		c.FilePasswordFunc = func(string) (string, error) {
+			return password, nil
+		}
+	}
 	if name != "" {
 		c.AllowedBackends = []keyring.BackendType{keyring.BackendType(name)}
-		rolesFile := getHomePath(path.Join(securePath, RECORD_KEY))
-
-		if name == "file" {
-			if _, err := os.Stat(rolesFile); os.IsNotExist(err) {
-				// new secure store, so we should prompt user twice for password
-				// if ENV var is not set
-				if password := os.Getenv(ENV_SSO_FILE_PASSWORD); password == "" {
-					pass1, err := fileKeyringPassword("Select password")
-					if err != nil {
-						return &c, fmt.Errorf("Password error: %s", err.Error())
-					}
-					pass2, err := fileKeyringPassword("Verify password")
-					if err != nil {
-						return &c, fmt.Errorf("Password error: %s", err.Error())
-					}
-					if pass1 != pass2 {
-						return &c, fmt.Errorf("Password missmatch: %s", err.Error())
-					}
-					NewPassword = pass1
+
+		if name == "file" && password == "" && fileStoreIsNew(securePath) {
+			// new secure store, so we should prompt user twice for password
+			// if ENV var is not set
+			if password := os.Getenv(ENV_SSO_FILE_PASSWORD); password == "" {
+				pass1, err := filePasswordPrompt("Select password")
+				if err != nil {
+					return &c, fmt.Errorf("Password error: %s", err.Error())
+				}
+				pass2, err := filePasswordPrompt("Verify password")
+				if err != nil {
+					return &c, fmt.Errorf("Password error: %s", err.Error())
+				}
+				if pass1 != pass2 {
+					return &c, fmt.Errorf("Password missmatch")
 				}
+				NewPassword = pass1
 			}
 		}
 	}
 	return &c, nil
 }
 
+// fileStoreIsNew returns true if the file backend has no items yet.  Each
+// item is a separate file, so we can't rely on the legacy RECORD_KEY file.
+func fileStoreIsNew(securePath string) bool {
+	files, err := os.ReadDir(getHomePath(securePath))
+	if err != nil {
+		return true
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// filePasswordPrompt asks the user for the password of a new file store
+var filePasswordPrompt = fileKeyringPassword
+
 func fileKeyringPassword(prompt string) (string, error) {
 	if password := os.Getenv(ENV_SSO_FILE_PASSWORD); password != "" {
 		return password, nil
@@ -134,11 +161,16 @@ func OpenKeyring(cfg *keyring.Config) (*KeyringStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newKeyringStore(ring, *cfg)
+}
+
+// newKeyringStore wraps the KeyringApi and migrates any legacy data
+func newKeyringStore(ring KeyringApi, cfg keyring.Config) (*KeyringStore, error) {
 	kr := KeyringStore{
 		keyring: ring,
-		config:  *cfg,
+		config:  cfg,
 	}
-	return &kr, nil
+	return &kr, kr.migrateStorageData()
 }
 
 func (kr *KeyringStore) RegisterClientKey(ssoRegion string) string {
@@ -163,7 +195,7 @@ type Unmarshaler func([]byte, interface{}) error
 
 var storageDataUnmarshal Unmarshaler = json.Unmarshal
 
-// loads the entire StorageData into memory
+// loads the entire legacy RECORD_KEY StorageData into memory
 func (kr *KeyringStore) getStorageData(s *StorageData) error {
 	data, err := kr.keyring.Get(RECORD_KEY)
 	if err != nil {
@@ -177,7 +209,7 @@ func (kr *KeyringStore) getStorageData(s *StorageData) error {
 	return nil
 }
 
-// saves the entire StorageData into our KeyringStore
+// saves the entire StorageData into the legacy RECORD_KEY of our KeyringStore
 func (kr *KeyringStore) saveStorageData(s StorageData) error {
 	jdata, _ := json.Marshal(s)
 	err := kr.keyring.Set(keyring.Item{
@@ -196,48 +228,97 @@ func (kr *KeyringStore) saveStorageData(s StorageData) error {
 	return err
 }
 
-// Save our RegisterClientData in the key chain
-func (kr *KeyringStore) SaveRegisterClientData(region string, client RegisterClientData) error {
-	storage := StorageData{}
-	if err := kr.getStorageData(&storage); err != nil {
+// getItem loads a single item from the keyring
+func (kr *KeyringStore) getItem(key string, v interface{}) error {
+	item, err := kr.keyring.Get(key)
+	if err != nil {
+		return err
+	}
+	return storageDataUnmarshal(item.Data, v)
+}
+
+// setItem stores a single item in the keyring
+func (kr *KeyringStore) setItem(key string, v interface{}) error {
+	jdata, err := json.Marshal(v)
+	if err != nil {
 		return err
 	}
+	err = kr.keyring.Set(keyring.Item{
+		Key:         key,
+		Data:        jdata,
+		Label:       KEYRING_ID,
+		Description: "aws-sso secure storage",
+	})
 
-	key := kr.RegisterClientKey(region)
-	storage.RegisterClientData[key] = client
+	// same wincred workaround as saveStorageData()
+	if err != nil && runtime.GOOS == "windows" && err.Error() == "The stub received bad data." {
+		return nil
+	}
+	return err
+}
 
-	return kr.saveStorageData(storage)
+// removeItem deletes a single item from the keyring, failing if it doesn't exist
+func (kr *KeyringStore) removeItem(key string) error {
+	if _, err := kr.keyring.Get(key); err != nil {
+		return err
+	}
+	return kr.keyring.Remove(key)
 }
 
-// Get our RegisterClientData from the key chain
-func (kr *KeyringStore) GetRegisterClientData(region string, client *RegisterClientData) error {
+// migrateStorageData moves the items in the legacy RECORD_KEY blob into their
+// own keyring items.  Items which already exist are not overwritten.
+func (kr *KeyringStore) migrateStorageData() error {
+	if _, err := kr.keyring.Get(RECORD_KEY); err != nil {
+		return nil // nothing to migrate
+	}
+
 	storage := StorageData{}
-	ok := false
 	if err := kr.getStorageData(&storage); err != nil {
-		return err
+		return fmt.Errorf("Unable to read %s: %s", RECORD_KEY, err.Error())
+	}
+
+	items := map[string]interface{}{}
+	for k, v := range storage.RegisterClientData {
+		items[k] = v // keys are already prefixed
+	}
+	for k, v := range storage.CreateTokenResponse {
+		items[k] = v // keys are already prefixed
+	}
+	for arn, v := range storage.RoleCredentials {
+		items[kr.RoleCredentialsKey(arn)] = v
 	}
 
-	key := kr.RegisterClientKey(region)
-	if *client, ok = storage.RegisterClientData[key]; !ok {
-		return fmt.Errorf("No RegisterClientData for %s", region)
+	for key, v := range items {
+		if _, err := kr.keyring.Get(key); err == nil {
+			continue
+		}
+		if err := kr.setItem(key, v); err != nil {
+			return fmt.Errorf("Unable to migrate %s: %s", key, err.Error())
+		}
+	}
+
+	log.Infof("Migrated %d items from %s", len(items), RECORD_KEY)
+	return kr.keyring.Remove(RECORD_KEY)
+}
+
+// Save our RegisterClientData in the key chain
+func (kr *KeyringStore) SaveRegisterClientData(region string, client RegisterClientData) error {
+	return kr.setItem(kr.RegisterClientKey(region), client)
+}
+
+// Get our RegisterClientData from the key chain
+func (kr *KeyringStore) GetRegisterClientData(region string, client *RegisterClientData) error {
+	if err := kr.getItem(kr.RegisterClientKey(region), client); err != nil {
+		return fmt.Errorf("No RegisterClientData for %s: %s", region, err.Error())
 	}
 	return nil
 }
 
 // Delete the RegisterClientData from the keychain
 func (kr *KeyringStore) DeleteRegisterClientData(region string) error {
-	storage := StorageData{}
-	if err := kr.getStorageData(&storage); err != nil {
-		return err
-	}
-
-	key := kr.RegisterClientKey(region)
-	if _, ok := storage.RegisterClientData[key]; !ok {
-		// return error if key doesn't exist
+	if err := kr.removeItem(kr.RegisterClientKey(region)); err != nil {
 		return fmt.Errorf("Missing RegisterClientData for region: %s", region)
 	}
-
-	delete(storage.RegisterClientData, key)
 	return nil
 }
 
@@ -247,88 +328,47 @@ func (kr *KeyringStore) CreateTokenResponseKey(key string) string {
 
 // SaveCreateTokenResponse stores the token in the keyring
 func (kr *KeyringStore) SaveCreateTokenResponse(key string, token CreateTokenResponse) error {
-	storage := StorageData{}
-	if err := kr.getStorageData(&storage); err != nil {
-		return err
-	}
-
-	k := kr.CreateTokenResponseKey(key)
-	storage.CreateTokenResponse[k] = token
-
-	return kr.saveStorageData(storage)
+	return kr.setItem(kr.CreateTokenResponseKey(key), token)
 }
 
 // GetCreateTokenResponse retrieves the CreateTokenResponse from the keyring
 func (kr *KeyringStore) GetCreateTokenResponse(key string, token *CreateTokenResponse) error {
-	storage := StorageData{}
-	ok := false
-	if err := kr.getStorageData(&storage); err != nil {
-		return err
-	}
-
-	k := kr.CreateTokenResponseKey(key)
-	if *token, ok = storage.CreateTokenResponse[k]; !ok {
-		return fmt.Errorf("No CreateTokenResponse for %s", key)
+	if err := kr.getItem(kr.CreateTokenResponseKey(key), token); err != nil {
+		return fmt.Errorf("No CreateTokenResponse for %s: %s", key, err.Error())
 	}
 	return nil
 }
 
 // DeleteCreateTokenResponse deletes the CreateTokenResponse from the keyring
 func (kr *KeyringStore) DeleteCreateTokenResponse(key string) error {
-	storage := StorageData{}
-	if err := kr.getStorageData(&storage); err != nil {
-		return err
-	}
-
-	k := kr.CreateTokenResponseKey(key)
-	if _, ok := storage.CreateTokenResponse[k]; !ok {
-		// return error if key doesn't exist
+	if err := kr.removeItem(kr.CreateTokenResponseKey(key)); err != nil {
 		return fmt.Errorf("Missing CreateTokenResponse for key: %s", key)
 	}
-
-	delete(storage.CreateTokenResponse, k)
 	return nil
 }
 
+func (kr *KeyringStore) RoleCredentialsKey(arn string) string {
+	return fmt.Sprintf("%s:%s", ROLE_CREDENTIALS_PREFIX, arn)
+}
+
 // SaveRoleCredentials stores the token in the arnring
 func (kr *KeyringStore) SaveRoleCredentials(arn string, token RoleCredentials) error {
-	storage := StorageData{}
-	if err := kr.getStorageData(&storage); err != nil {
-		return fmt.Errorf("Unable to getStorageData: %s", err.Error())
-	}
-
-	storage.RoleCredentials[arn] = token
-
-	return kr.saveStorageData(storage)
+	return kr.setItem(kr.RoleCredentialsKey(arn), token)
 }
 
 // GetRoleCredentials retrieves the RoleCredentials from the Keyring
 func (kr *KeyringStore) GetRoleCredentials(arn string, token *RoleCredentials) error {
-	storage := StorageData{}
-	ok := false
-	if err := kr.getStorageData(&storage); err != nil {
-		return err
-	}
-
-	if *token, ok = storage.RoleCredentials[arn]; !ok {
-		return fmt.Errorf("No RoleCredentials for %s", arn)
+	if err := kr.getItem(kr.RoleCredentialsKey(arn), token); err != nil {
+		return fmt.Errorf("No RoleCredentials for %s: %s", arn, err.Error())
 	}
 	return nil
 }
 
 // DeleteRoleCredentials deletes the RoleCredentials from the Keyring
 func (kr *KeyringStore) DeleteRoleCredentials(arn string) error {
-	storage := StorageData{}
-	if err := kr.getStorageData(&storage); err != nil {
-		return err
-	}
-
-	if _, ok := storage.RoleCredentials[arn]; !ok {
-		// return error if key doesn't exist
+	if err := kr.removeItem(kr.RoleCredentialsKey(arn)); err != nil {
 		return fmt.Errorf("Missing RoleCredentials for arn: %s", arn)
 	}
-
-	delete(storage.RoleCredentials, arn)
 	return nil
 }
 
@@ -24,12 +24,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/99designs/keyring"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"github.com/synfinatic/aws-sso-cli/utils"
 )
 
 type KeyringSuite struct {
@@ -286,32 +288,257 @@ func (suite *KeyringSuite) TestUnmarshalFailure() {
 
 	storageDataUnmarshal = UnmarshalFailure
 
+	// saving and deleting individual items never unmarshals
 	err := suite.store.SaveRegisterClientData("region", RegisterClientData{})
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	err = suite.store.GetRegisterClientData("region", &RegisterClientData{})
 	assert.Error(t, err)
 
 	err = suite.store.DeleteRegisterClientData("region")
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	err = suite.store.SaveCreateTokenResponse("key", CreateTokenResponse{})
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	err = suite.store.GetCreateTokenResponse("key", &CreateTokenResponse{})
 	assert.Error(t, err)
 
 	err = suite.store.DeleteCreateTokenResponse("key")
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	err = suite.store.SaveRoleCredentials("arn", RoleCredentials{})
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	err = suite.store.GetRoleCredentials("arn", &RoleCredentials{})
 	assert.Error(t, err)
 
 	err = suite.store.DeleteRoleCredentials("arn")
+	assert.NoError(t, err)
+
+	// legacy data can't be migrated
+	err = suite.store.saveStorageData(NewStorageData())
+	assert.NoError(t, err)
+	err = suite.store.migrateStorageData()
 	assert.Error(t, err)
 
 	storageDataUnmarshal = json.Unmarshal
+
+	err = suite.store.migrateStorageData()
+	assert.NoError(t, err)
+}
+
+// memoryKeyringApi is a thread safe in-memory KeyringApi
+type memoryKeyringApi struct {
+	lock  sync.Mutex
+	items map[string]keyring.Item
+	sets  int
+}
+
+func newMemoryKeyringApi() *memoryKeyringApi {
+	return &memoryKeyringApi{
+		items: map[string]keyring.Item{},
+	}
+}
+
+func (m *memoryKeyringApi) Get(key string) (keyring.Item, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.items[key]
+	if !ok {
+		return item, keyring.ErrKeyNotFound
+	}
+	return item, nil
+}
+
+func (m *memoryKeyringApi) Set(item keyring.Item) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.items[item.Key] = item
+	m.sets++
+	return nil
+}
+
+func (m *memoryKeyringApi) Remove(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.items[key]; !ok {
+		return keyring.ErrKeyNotFound
+	}
+	delete(m.items, key)
+	return nil
+}
+
+func TestKeyringPerItem(t *testing.T) {
+	ring := newMemoryKeyringApi()
+	ks, err := newKeyringStore(ring, keyring.Config{})
+	assert.NoError(t, err)
+
+	rc := RoleCredentials{RoleName: "MyRole", AccountId: 1234}
+	arn := rc.RoleArn()
+	assert.NoError(t, ks.SaveRoleCredentials(arn, rc))
+	assert.NoError(t, ks.SaveRegisterClientData("region", RegisterClientData{ClientId: "id"}))
+	assert.NoError(t, ks.SaveCreateTokenResponse("key", CreateTokenResponse{AccessToken: "token"}))
+
+	// one keyring item per record and no legacy blob
+	assert.Len(t, ring.items, 3)
+	assert.Contains(t, ring.items, "role-credentials:"+arn)
+	assert.Contains(t, ring.items, "client-data:region")
+	assert.Contains(t, ring.items, "token-response:key")
+	assert.NotContains(t, ring.items, RECORD_KEY)
+
+	assert.NoError(t, ks.DeleteRoleCredentials(arn))
+	assert.NotContains(t, ring.items, "role-credentials:"+arn)
+	assert.Error(t, ks.DeleteRoleCredentials(arn))
+}
+
+func TestKeyringMigrateStorageData(t *testing.T) {
+	ring := newMemoryKeyringApi()
+	legacy := &KeyringStore{keyring: ring}
+
+	rc := RoleCredentials{RoleName: "MyRole", AccountId: 1234}
+	newer := RoleCredentials{RoleName: "Newer", AccountId: 1234}
+	rcd := RegisterClientData{ClientId: "id"}
+	ctr := CreateTokenResponse{AccessToken: "token"}
+
+	data := NewStorageData()
+	data.RoleCredentials["arn1"] = rc
+	data.RoleCredentials["arn2"] = rc
+	data.RegisterClientData[legacy.RegisterClientKey("region")] = rcd
+	data.CreateTokenResponse[legacy.CreateTokenResponseKey("key")] = ctr
+	assert.NoError(t, legacy.saveStorageData(data))
+
+	// per-item records win over the legacy blob
+	assert.NoError(t, legacy.setItem(legacy.RoleCredentialsKey("arn2"), newer))
+
+	ks, err := newKeyringStore(ring, keyring.Config{})
+	assert.NoError(t, err)
+	assert.NotContains(t, ring.items, RECORD_KEY)
+
+	rc2 := RoleCredentials{}
+	assert.NoError(t, ks.GetRoleCredentials("arn1", &rc2))
+	assert.Equal(t, rc, rc2)
+	assert.NoError(t, ks.GetRoleCredentials("arn2", &rc2))
+	assert.Equal(t, newer, rc2)
+
+	rcd2 := RegisterClientData{}
+	assert.NoError(t, ks.GetRegisterClientData("region", &rcd2))
+	assert.Equal(t, rcd, rcd2)
+
+	ctr2 := CreateTokenResponse{}
+	assert.NoError(t, ks.GetCreateTokenResponse("key", &ctr2))
+	assert.Equal(t, ctr, ctr2)
+
+	// nothing to do the second time
+	sets := ring.sets
+	_, err = newKeyringStore(ring, keyring.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, sets, ring.sets)
+}
+
+func TestKeyringConcurrentWrites(t *testing.T) {
+	ring := newMemoryKeyringApi()
+
+	// each goroutine acts like a separate aws-sso process
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ks, err := newKeyringStore(ring, keyring.Config{})
+			assert.NoError(t, err)
+
+			rc := RoleCredentials{RoleName: fmt.Sprintf("Role%d", i), AccountId: int64(i)}
+			assert.NoError(t, ks.SaveRoleCredentials(rc.RoleArn(), rc))
+			assert.NoError(t, ks.SaveCreateTokenResponse(fmt.Sprintf("key%d", i), CreateTokenResponse{}))
+		}(i)
+	}
+	wg.Wait()
+
+	// no updates were lost
+	ks, err := newKeyringStore(ring, keyring.Config{})
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		rc := RoleCredentials{}
+		arn := utils.MakeRoleARN(int64(i), fmt.Sprintf("Role%d", i))
+		assert.NoError(t, ks.GetRoleCredentials(arn, &rc))
+		assert.Equal(t, fmt.Sprintf("Role%d", i), rc.RoleName)
+		assert.NoError(t, ks.GetCreateTokenResponse(fmt.Sprintf("key%d", i), &CreateTokenResponse{}))
+	}
+}
+
+func TestNewKeyringConfigPassword(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	os.Unsetenv(ENV_SSO_FILE_PASSWORD)
+	defer os.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+
+	// new file store doesn't prompt when given a password
+	c, err := NewKeyringConfigPassword("file", d, "agentpassword")
+	assert.NoError(t, err)
+	pass, err := c.FilePasswordFunc("prompt")
+	assert.NoError(t, err)
+	assert.Equal(t, "agentpassword", pass)
+	assert.Empty(t, os.Getenv(ENV_SSO_FILE_PASSWORD))
+	assert.Empty(t, NewPassword)
+
+	store, err := OpenKeyring(c)
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveRoleCredentials("foo", RoleCredentials{AccessKeyId: "key"}))
+
+	rc := RoleCredentials{}
+	store, err = OpenKeyring(c)
+	assert.NoError(t, err)
+	assert.NoError(t, store.GetRoleCredentials("foo", &rc))
+	assert.Equal(t, "key", rc.AccessKeyId)
+}
+
+func TestNewKeyringConfigMigratedFileStore(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	os.Unsetenv(ENV_SSO_FILE_PASSWORD)
+	defer os.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+
+	prompts := 0
+	filePasswordPrompt = func(string) (string, error) {
+		prompts++
+		return "mypassword", nil
+	}
+	defer func() {
+		filePasswordPrompt = fileKeyringPassword
+		NewPassword = ""
+	}()
+
+	// new store asks for the password twice
+	c, err := NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, prompts)
+
+	// legacy store with only the RECORD_KEY blob
+	store, err := OpenKeyring(c)
+	assert.NoError(t, err)
+	data := NewStorageData()
+	data.RoleCredentials["arn1"] = RoleCredentials{AccessKeyId: "key"}
+	assert.NoError(t, store.saveStorageData(data))
+
+	// migrate which deletes the blob
+	_, err = OpenKeyring(c)
+	assert.NoError(t, err)
+	_, err = os.Stat(path.Join(d, "secure", RECORD_KEY))
+	assert.True(t, os.IsNotExist(err))
+
+	// reopening the migrated store doesn't prompt for a new password
+	NewPassword = ""
+	prompts = 0
+	_, err = NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, prompts)
+	assert.Empty(t, NewPassword)
 }
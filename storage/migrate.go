@@ -0,0 +1,117 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// StoreKeys are the keys of the items we look for in a SecureStorage.  Since
+// not every backend can enumerate its contents, the caller has to provide them.
+type StoreKeys struct {
+	SSO   []string // keys for the RegisterClientData & CreateTokenResponse
+	Roles []string // role ARNs for the RoleCredentials
+}
+
+// MigrateResult lists the keys of the items which were copied
+type MigrateResult struct {
+	RegisterClientData  []string
+	CreateTokenResponse []string
+	RoleCredentials     []string
+	ExpiredRoles        []string // skipped because they have expired
+}
+
+// Migrate copies all the items in keys from one SecureStorage to another and
+// verifies that each item can be read back unchanged
+func Migrate(from, to SecureStorage, keys StoreKeys) (MigrateResult, error) {
+	result := MigrateResult{
+		RegisterClientData:  []string{},
+		CreateTokenResponse: []string{},
+		RoleCredentials:     []string{},
+		ExpiredRoles:        []string{},
+	}
+
+	for _, key := range keys.SSO {
+		client := RegisterClientData{}
+		if err := from.GetRegisterClientData(key, &client); err == nil && client.ClientId != "" {
+			if err = to.SaveRegisterClientData(key, client); err != nil {
+				return result, fmt.Errorf("Unable to save RegisterClientData for %s: %s", key, err.Error())
+			}
+			check := RegisterClientData{}
+			if err = to.GetRegisterClientData(key, &check); err != nil || !reflect.DeepEqual(client, check) {
+				return result, fmt.Errorf("Unable to verify RegisterClientData for %s", key)
+			}
+			result.RegisterClientData = append(result.RegisterClientData, key)
+		}
+
+		token := CreateTokenResponse{}
+		if err := from.GetCreateTokenResponse(key, &token); err == nil && token.AccessToken != "" {
+			if err = to.SaveCreateTokenResponse(key, token); err != nil {
+				return result, fmt.Errorf("Unable to save CreateTokenResponse for %s: %s", key, err.Error())
+			}
+			check := CreateTokenResponse{}
+			if err = to.GetCreateTokenResponse(key, &check); err != nil || !reflect.DeepEqual(token, check) {
+				return result, fmt.Errorf("Unable to verify CreateTokenResponse for %s", key)
+			}
+			result.CreateTokenResponse = append(result.CreateTokenResponse, key)
+		}
+	}
+
+	for _, arn := range keys.Roles {
+		creds := RoleCredentials{}
+		if err := from.GetRoleCredentials(arn, &creds); err != nil || creds.AccessKeyId == "" {
+			continue
+		}
+		if creds.Expired() {
+			result.ExpiredRoles = append(result.ExpiredRoles, arn)
+			continue
+		}
+		if err := to.SaveRoleCredentials(arn, creds); err != nil {
+			return result, fmt.Errorf("Unable to save RoleCredentials for %s: %s", arn, err.Error())
+		}
+		check := RoleCredentials{}
+		if err := to.GetRoleCredentials(arn, &check); err != nil || !reflect.DeepEqual(creds, check) {
+			return result, fmt.Errorf("Unable to verify RoleCredentials for %s", arn)
+		}
+		result.RoleCredentials = append(result.RoleCredentials, arn)
+	}
+
+	return result, nil
+}
+
+// Wipe deletes all the items in the MigrateResult, including expired roles
+func Wipe(store SecureStorage, result MigrateResult) error {
+	for _, key := range result.RegisterClientData {
+		if err := store.DeleteRegisterClientData(key); err != nil {
+			return fmt.Errorf("Unable to delete RegisterClientData for %s: %s", key, err.Error())
+		}
+	}
+	for _, key := range result.CreateTokenResponse {
+		if err := store.DeleteCreateTokenResponse(key); err != nil {
+			return fmt.Errorf("Unable to delete CreateTokenResponse for %s: %s", key, err.Error())
+		}
+	}
+	for _, arn := range append(result.RoleCredentials, result.ExpiredRoles...) {
+		if err := store.DeleteRoleCredentials(arn); err != nil {
+			return fmt.Errorf("Unable to delete RoleCredentials for %s: %s", arn, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-migrate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	from, err := OpenJsonStore(path.Join(d, "store.json"))
+	assert.NoError(t, err)
+	to, err := OpenEncryptedStore(path.Join(d, "store.enc"), staticPassphrase("password"))
+	assert.NoError(t, err)
+
+	ssoKey := "us-east-1|https://d-1234567890.awsapps.com/start"
+	rcd := RegisterClientData{ClientId: "client", ClientSecret: "secret"}
+	ctr := CreateTokenResponse{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	valid := RoleCredentials{
+		RoleName:    "Valid",
+		AccountId:   1234,
+		AccessKeyId: "AKIAVALID",
+		Expiration:  time.Now().Add(time.Hour).UnixMilli(),
+	}
+	expired := RoleCredentials{
+		RoleName:    "Expired",
+		AccountId:   1234,
+		AccessKeyId: "AKIAEXPIRED",
+		Expiration:  time.Now().Add(-1 * time.Hour).UnixMilli(),
+	}
+
+	assert.NoError(t, from.SaveRegisterClientData(ssoKey, rcd))
+	assert.NoError(t, from.SaveCreateTokenResponse(ssoKey, ctr))
+	assert.NoError(t, from.SaveRoleCredentials(valid.RoleArn(), valid))
+	assert.NoError(t, from.SaveRoleCredentials(expired.RoleArn(), expired))
+
+	keys := StoreKeys{
+		SSO:   []string{ssoKey, "eu-west-1|https://missing"},
+		Roles: []string{valid.RoleArn(), expired.RoleArn(), "arn:aws:iam::000000001234:role/Missing"},
+	}
+
+	result, err := Migrate(from, to, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ssoKey}, result.RegisterClientData)
+	assert.Equal(t, []string{ssoKey}, result.CreateTokenResponse)
+	assert.Equal(t, []string{valid.RoleArn()}, result.RoleCredentials)
+	assert.Equal(t, []string{expired.RoleArn()}, result.ExpiredRoles)
+
+	rcd2 := RegisterClientData{}
+	assert.NoError(t, to.GetRegisterClientData(ssoKey, &rcd2))
+	assert.Equal(t, rcd, rcd2)
+	ctr2 := CreateTokenResponse{}
+	assert.NoError(t, to.GetCreateTokenResponse(ssoKey, &ctr2))
+	assert.Equal(t, ctr, ctr2)
+	rc := RoleCredentials{}
+	assert.NoError(t, to.GetRoleCredentials(valid.RoleArn(), &rc))
+	assert.Equal(t, valid, rc)
+	assert.Error(t, to.GetRoleCredentials(expired.RoleArn(), &rc))
+
+	assert.NoError(t, Wipe(from, result))
+	assert.Error(t, from.GetRegisterClientData(ssoKey, &rcd2))
+	assert.Error(t, from.GetCreateTokenResponse(ssoKey, &ctr2))
+	assert.Error(t, from.GetRoleCredentials(valid.RoleArn(), &rc))
+	assert.Error(t, from.GetRoleCredentials(expired.RoleArn(), &rc))
+
+	// wiping items which are already gone fails with the encrypted store
+	assert.Error(t, Wipe(to, MigrateResult{RoleCredentials: []string{expired.RoleArn()}}))
+}
@@ -0,0 +1,150 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	ENV_SSO_STORE_PASSWORD     = "AWS_SSO_STORE_PASSWORD"     // #nosec
+	ENV_SSO_STORE_PASSWORD_FD  = "AWS_SSO_STORE_PASSWORD_FD"  // #nosec
+	ENV_SSO_STORE_NEW_PASSWORD = "AWS_SSO_STORE_NEW_PASSWORD" // #nosec
+)
+
+// PassphraseFunc returns the passphrase used to encrypt/decrypt a store
+type PassphraseFunc func() (string, error)
+
+// PassphraseSource defines where we look for a passphrase, in order:
+// environment variable, file descriptor, helper command and finally
+// prompting the user on the terminal
+type PassphraseSource struct {
+	EnvVar   string   // env var containing the passphrase
+	FdEnvVar string   // env var containing a file descriptor to read the passphrase from
+	Command  []string // helper command which prints the passphrase on stdout
+	Prompt   string   // prompt for the terminal
+	Confirm  bool     // prompt twice when using the terminal
+	cached   string
+}
+
+// Func returns a PassphraseFunc which only looks up the passphrase once
+func (ps *PassphraseSource) Func() PassphraseFunc {
+	return func() (string, error) {
+		if ps.cached != "" {
+			return ps.cached, nil
+		}
+		pass, err := ps.get()
+		if err != nil {
+			return "", err
+		}
+		ps.cached = pass
+		return pass, nil
+	}
+}
+
+func (ps *PassphraseSource) get() (string, error) {
+	if ps.EnvVar != "" {
+		if pass := os.Getenv(ps.EnvVar); pass != "" {
+			return pass, nil
+		}
+	}
+
+	if ps.FdEnvVar != "" {
+		if fdStr := os.Getenv(ps.FdEnvVar); fdStr != "" {
+			return passphraseFromFd(fdStr)
+		}
+	}
+
+	if len(ps.Command) > 0 {
+		return passphraseFromCommand(ps.Command)
+	}
+
+	pass1, err := passphrasePrompt(ps.Prompt)
+	if err != nil {
+		return "", err
+	}
+	if ps.Confirm {
+		pass2, err := passphrasePrompt(fmt.Sprintf("Verify %s", strings.ToLower(ps.Prompt)))
+		if err != nil {
+			return "", err
+		}
+		if pass1 != pass2 {
+			return "", fmt.Errorf("Password missmatch")
+		}
+	}
+	return pass1, nil
+}
+
+// passphraseFromFd reads the first line from the given file descriptor
+func passphraseFromFd(fdStr string) (string, error) {
+	fd, err := strconv.ParseUint(fdStr, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("Invalid file descriptor '%s': %s", fdStr, err.Error())
+	}
+	f := os.NewFile(uintptr(fd), "passphrase")
+	if f == nil {
+		return "", fmt.Errorf("Invalid file descriptor: %s", fdStr)
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" && err != nil {
+		return "", fmt.Errorf("Unable to read passphrase from fd %s: %s", fdStr, err.Error())
+	}
+	return line, nil
+}
+
+// passphraseFromCommand executes the helper command and returns the first line of stdout
+func passphraseFromCommand(command []string) (string, error) {
+	cmd := exec.Command(command[0], command[1:]...) // #nosec
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Unable to exec `%s`: %s", strings.Join(command, " "), err.Error())
+	}
+	pass := strings.SplitN(string(out), "\n", 2)[0]
+	pass = strings.TrimRight(pass, "\r")
+	if pass == "" {
+		return "", fmt.Errorf("`%s` returned an empty passphrase", strings.Join(command, " "))
+	}
+	return pass, nil
+}
+
+// passphrasePrompt prompts the user on the terminal without echo
+func passphrasePrompt(prompt string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	b, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", fmt.Errorf("Aborting with empty password")
+	}
+	return string(b), nil
+}
@@ -0,0 +1,139 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	BROWSER_SESSION_NONE     = "none"
+	BROWSER_SESSION_FIREFOX  = "firefox-container"
+	BROWSER_SESSION_CHROMIUM = "chromium-profile"
+)
+
+// Colors & icons supported by the Firefox Multi-Account Containers extension
+var FIREFOX_CONTAINER_COLORS = []string{
+	"blue", "turquoise", "green", "yellow", "orange", "red", "pink", "purple",
+}
+
+var FIREFOX_CONTAINER_ICONS = []string{
+	"fingerprint", "briefcase", "dollar", "cart", "circle", "gift",
+	"vacation", "food", "fruit", "pet", "tree", "chill", "fence",
+}
+
+// BrowserSession is the isolated browser session to open a URL in
+type BrowserSession struct {
+	Mode        string // none, firefox-container or chromium-profile
+	Name        string // name of the Firefox container
+	Color       string // color of the Firefox container
+	Icon        string // icon of the Firefox container
+	UserDataDir string // Chromium --user-data-dir
+}
+
+// FirefoxContainerUrl returns the URL which opens the url in the named container
+// via the Open external links in a container Firefox extension.  Invalid colors
+// and icons are replaced with one picked based on the name.
+func FirefoxContainerUrl(u, name, color, icon string) string {
+	color = pickOption(FIREFOX_CONTAINER_COLORS, color, name)
+	icon = pickOption(FIREFOX_CONTAINER_ICONS, icon, name)
+
+	return fmt.Sprintf("ext+container:name=%s&color=%s&icon=%s&url=%s",
+		url.QueryEscape(name), color, icon, url.QueryEscape(u))
+}
+
+// pickOption returns the value if it is a valid option, otherwise an option
+// consistently picked for the name
+func pickOption(options []string, value, name string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, o := range options {
+		if o == value {
+			return o
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return options[h.Sum32()%uint32(len(options))]
+}
+
+// ChromiumArgs returns the Chromium arguments to open the url using
+// the given user data directory
+func ChromiumArgs(u, userDataDir string) []string {
+	return []string{
+		fmt.Sprintf("--user-data-dir=%s", userDataDir),
+		"--no-first-run",
+		"--no-default-browser-check",
+		u,
+	}
+}
+
+// these types & variables make our code easier to unit test
+type browserLauncherFunc func(string, []string) error
+
+var browserLauncher browserLauncherFunc = launchBrowser
+
+// launchBrowser starts the browser without waiting for it to exit
+func launchBrowser(program string, args []string) error {
+	cmd := exec.Command(program, args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// OpenSession opens the URL in the isolated browser session.  Firefox
+// containers wrap the URL before it is handled by the UrlAction and Chromium
+// profiles always launch the Browser.
+func (h *HandleUrl) OpenSession(u string, session *BrowserSession, pre, post string) error {
+	if session == nil {
+		return h.Open(u, pre, post)
+	}
+
+	switch session.Mode {
+	case "", BROWSER_SESSION_NONE:
+		return h.Open(u, pre, post)
+
+	case BROWSER_SESSION_FIREFOX:
+		return h.Open(FirefoxContainerUrl(u, session.Name, session.Color, session.Icon), pre, post)
+
+	case BROWSER_SESSION_CHROMIUM:
+		if h.Browser == "" {
+			return fmt.Errorf("Browser must be set to use %s", BROWSER_SESSION_CHROMIUM)
+		}
+		if session.UserDataDir == "" {
+			return fmt.Errorf("UserDataDir must be set to use %s", BROWSER_SESSION_CHROMIUM)
+		}
+		dir := GetHomePath(session.UserDataDir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("Unable to create %s: %s", dir, err.Error())
+		}
+		if err := browserLauncher(h.Browser, ChromiumArgs(u, dir)); err != nil {
+			return fmt.Errorf("Unable to open URL with %s: %s", h.Browser, err.Error())
+		}
+		log.Infof("Opening URL in %s.\n", h.Browser)
+		return nil
+	}
+
+	return fmt.Errorf("Invalid browser session: %s", session.Mode)
+}
@@ -0,0 +1,90 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirefoxContainerUrl(t *testing.T) {
+	assert.Equal(t,
+		"ext+container:name=dev%3AAdmin&color=red&icon=fence&url=https%3A%2F%2Fexample.com%2F%3Fa%3Db%26c%3Dd",
+		FirefoxContainerUrl("https://example.com/?a=b&c=d", "dev:Admin", "Red", "fence"))
+
+	// invalid values are consistently replaced
+	u := FirefoxContainerUrl("url", "name", "plaid", "")
+	assert.Equal(t, u, FirefoxContainerUrl("url", "name", "", "unicorn"))
+	assert.Contains(t, u, fmt.Sprintf("color=%s&", pickOption(FIREFOX_CONTAINER_COLORS, "", "name")))
+	assert.Contains(t, u, fmt.Sprintf("icon=%s&", pickOption(FIREFOX_CONTAINER_ICONS, "", "name")))
+}
+
+func TestOpenSession(t *testing.T) {
+	defer func() {
+		browserLauncher = launchBrowser
+		printWriter = os.Stderr
+	}()
+
+	printWriter = new(bytes.Buffer)
+	h := NewHandleUrl("printurl", "", "")
+	assert.NoError(t, h.OpenSession("url", nil, "", ""))
+	assert.NoError(t, h.OpenSession("url", &BrowserSession{Mode: BROWSER_SESSION_NONE}, "", ""))
+	assert.NoError(t, h.OpenSession("url", &BrowserSession{
+		Mode:  BROWSER_SESSION_FIREFOX,
+		Name:  "foo",
+		Color: "blue",
+		Icon:  "dollar",
+	}, "", ""))
+	assert.Equal(t, "url\nurl\next+container:name=foo&color=blue&icon=dollar&url=url\n",
+		printWriter.(*bytes.Buffer).String())
+
+	assert.Error(t, h.OpenSession("url", &BrowserSession{Mode: "invalid"}, "", ""))
+
+	// chromium requires a browser & user data dir
+	session := &BrowserSession{
+		Mode:        BROWSER_SESSION_CHROMIUM,
+		UserDataDir: filepath.Join(t.TempDir(), "profiles", "foo"),
+	}
+	assert.Error(t, h.OpenSession("url", session, "", ""))
+	assert.Error(t, NewHandleUrl("open", "chromium", "").OpenSession("url",
+		&BrowserSession{Mode: BROWSER_SESSION_CHROMIUM}, "", ""))
+
+	var program string
+	var args []string
+	browserLauncher = func(p string, a []string) error {
+		program = p
+		args = a
+		return nil
+	}
+	h = NewHandleUrl("print", "chromium", "")
+	assert.NoError(t, h.OpenSession("url", session, "", ""))
+	assert.Equal(t, "chromium", program)
+	assert.Equal(t, ChromiumArgs("url", session.UserDataDir), args)
+	assert.DirExists(t, session.UserDataDir)
+
+	browserLauncher = func(p string, a []string) error {
+		return fmt.Errorf("there was an error")
+	}
+	assert.Error(t, h.OpenSession("url", session, "", ""))
+}
@@ -0,0 +1,314 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// AwsPartition describes the console & signin endpoints of an AWS partition
+type AwsPartition struct {
+	Name          string
+	ConsoleDomain string
+	SigninDomain  string
+}
+
+var AWS_PARTITIONS = map[string]AwsPartition{
+	"aws": {
+		Name:          "aws",
+		ConsoleDomain: "console.aws.amazon.com",
+		SigninDomain:  "signin.aws.amazon.com",
+	},
+	"aws-us-gov": {
+		Name:          "aws-us-gov",
+		ConsoleDomain: "console.amazonaws-us-gov.com",
+		SigninDomain:  "signin.amazonaws-us-gov.com",
+	},
+	"aws-cn": {
+		Name:          "aws-cn",
+		ConsoleDomain: "console.amazonaws.cn",
+		SigninDomain:  "signin.amazonaws.cn",
+	},
+}
+
+// RegionPartition returns the AwsPartition the region belongs to
+func RegionPartition(region string) AwsPartition {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return AWS_PARTITIONS["aws-us-gov"]
+	case strings.HasPrefix(region, "cn-"):
+		return AWS_PARTITIONS["aws-cn"]
+	}
+	return AWS_PARTITIONS["aws"]
+}
+
+// ConsoleUrl returns the base URL of the AWS Console
+func (p AwsPartition) ConsoleUrl() string {
+	return fmt.Sprintf("https://%s", p.ConsoleDomain)
+}
+
+// FederationUrl returns the URL of the federation endpoint
+func (p AwsPartition) FederationUrl() string {
+	return fmt.Sprintf("https://%s/federation", p.SigninDomain)
+}
+
+// LogoutUrl returns the URL of the console logout endpoint
+func (p AwsPartition) LogoutUrl() string {
+	return fmt.Sprintf("https://%s/oauth", p.SigninDomain)
+}
+
+// ValidateConsoleUrl returns an error if the URL is not an https URL in
+// the console domain of the partition
+func (p AwsPartition) ValidateConsoleUrl(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("Invalid console URL %s: %s", u, err.Error())
+	}
+	host := parsed.Hostname()
+	if parsed.Scheme != "https" || parsed.User != nil || parsed.Port() != "" ||
+		(host != p.ConsoleDomain && !strings.HasSuffix(host, "."+p.ConsoleDomain)) {
+		return fmt.Errorf("Invalid console URL %s: must be within https://%s", u, p.ConsoleDomain)
+	}
+	return nil
+}
+
+// DEFAULT_CONSOLE_SERVICES maps service shortcuts to their AWS Console path.
+// Paths are Go templates with {{ .Region }} available.
+var DEFAULT_CONSOLE_SERVICES = map[string]string{
+	"acm":            "/acm/home?region={{ .Region }}",
+	"apigateway":     "/apigateway/main/apis?region={{ .Region }}",
+	"billing":        "/billing/home",
+	"cloudformation": "/cloudformation/home?region={{ .Region }}",
+	"cloudfront":     "/cloudfront/v3/home",
+	"cloudtrail":     "/cloudtrail/home?region={{ .Region }}",
+	"cloudwatch":     "/cloudwatch/home?region={{ .Region }}",
+	"dynamodb":       "/dynamodbv2/home?region={{ .Region }}",
+	"ec2":            "/ec2/home?region={{ .Region }}",
+	"ecr":            "/ecr/repositories?region={{ .Region }}",
+	"ecs":            "/ecs/v2/clusters?region={{ .Region }}",
+	"eks":            "/eks/home?region={{ .Region }}",
+	"iam":            "/iamv2/home",
+	"kms":            "/kms/home?region={{ .Region }}",
+	"lambda":         "/lambda/home?region={{ .Region }}",
+	"logs":           "/cloudwatch/home?region={{ .Region }}#logsV2:log-groups",
+	"rds":            "/rds/home?region={{ .Region }}",
+	"route53":        "/route53/v2/home",
+	"s3":             "/s3/home?region={{ .Region }}",
+	"secretsmanager": "/secretsmanager/listsecrets?region={{ .Region }}",
+	"sns":            "/sns/v3/home?region={{ .Region }}",
+	"sqs":            "/sqs/v2/home?region={{ .Region }}",
+	"ssm":            "/systems-manager/home?region={{ .Region }}",
+	"stepfunctions":  "/states/home?region={{ .Region }}",
+	"vpc":            "/vpc/home?region={{ .Region }}",
+}
+
+// ConsoleServices returns the default service shortcuts merged with
+// the user provided ones
+func ConsoleServices(custom map[string]string) map[string]string {
+	services := map[string]string{}
+	for k, v := range DEFAULT_CONSOLE_SERVICES {
+		services[k] = v
+	}
+	for k, v := range custom {
+		services[strings.ToLower(k)] = v
+	}
+	return services
+}
+
+// ConsoleServiceUrl returns the AWS Console URL for the service shortcut
+func ConsoleServiceUrl(service, region string, custom map[string]string) (string, error) {
+	services := ConsoleServices(custom)
+	path, ok := services[strings.ToLower(service)]
+	if !ok {
+		names := []string{}
+		for k := range services {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("Unknown console service '%s'.  Valid services: %s",
+			service, strings.Join(names, ", "))
+	}
+
+	templ, err := template.New("service").Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("Invalid console service '%s': %s", service, err.Error())
+	}
+	buf := new(bytes.Buffer)
+	if err = templ.Execute(buf, map[string]string{"Region": region}); err != nil {
+		return "", fmt.Errorf("Invalid console service '%s': %s", service, err.Error())
+	}
+	return consolePathUrl(buf.String(), region)
+}
+
+// ConsoleArnUrl returns the AWS Console URL for the resource ARN.  The region
+// is the one we sign in to and is used for resources which do not specify one.
+func ConsoleArnUrl(arn, region string) (string, error) {
+	// arn:partition:service:region:account-id:resource
+	s := strings.SplitN(arn, ":", 6)
+	if len(s) != 6 || s[0] != "arn" || s[5] == "" {
+		return "", fmt.Errorf("Unable to parse ARN: %s", arn)
+	}
+	partition, service, account, resource := s[1], s[2], s[4], s[5]
+	// the role can only sign in to the console of its own partition
+	if RegionPartition(region).Name != partition {
+		return "", fmt.Errorf("ARN %s is not in the same partition as %s", arn, region)
+	}
+	resourceRegion := region
+	if s[3] != "" {
+		resourceRegion = s[3]
+	}
+
+	// resource-type/id or resource-type:id
+	rtype, id := resource, ""
+	if i := strings.IndexAny(resource, "/:"); i >= 0 {
+		rtype, id = resource[:i], resource[i+1:]
+	}
+
+	r := url.QueryEscape(resourceRegion)
+	var path string
+	switch service {
+	case "s3":
+		if i := strings.Index(resource, "/"); i >= 0 {
+			path = fmt.Sprintf("/s3/object/%s?region=%s&prefix=%s",
+				url.PathEscape(resource[:i]), r, url.QueryEscape(resource[i+1:]))
+		} else {
+			path = fmt.Sprintf("/s3/buckets/%s?region=%s", url.PathEscape(resource), r)
+		}
+	case "ec2":
+		switch rtype {
+		case "instance":
+			path = fmt.Sprintf("/ec2/home?region=%s#InstanceDetails:instanceId=%s", r, id)
+		case "volume":
+			path = fmt.Sprintf("/ec2/home?region=%s#VolumeDetails:volumeId=%s", r, id)
+		case "security-group":
+			path = fmt.Sprintf("/ec2/home?region=%s#SecurityGroup:groupId=%s", r, id)
+		case "image":
+			path = fmt.Sprintf("/ec2/home?region=%s#ImageDetails:imageId=%s", r, id)
+		case "vpc":
+			path = fmt.Sprintf("/vpc/home?region=%s#VpcDetails:VpcId=%s", r, id)
+		case "subnet":
+			path = fmt.Sprintf("/vpc/home?region=%s#SubnetDetails:subnetId=%s", r, id)
+		}
+	case "lambda":
+		if rtype == "function" {
+			name := strings.SplitN(id, ":", 2)[0]
+			path = fmt.Sprintf("/lambda/home?region=%s#/functions/%s", r, url.PathEscape(name))
+		}
+	case "iam":
+		// IAM names are the last element of the path
+		name := id[strings.LastIndex(id, "/")+1:]
+		switch rtype {
+		case "role":
+			path = fmt.Sprintf("/iamv2/home#/roles/details/%s", url.PathEscape(name))
+		case "user":
+			path = fmt.Sprintf("/iamv2/home#/users/details/%s", url.PathEscape(name))
+		case "group":
+			path = fmt.Sprintf("/iamv2/home#/groups/details/%s", url.PathEscape(name))
+		case "policy":
+			path = fmt.Sprintf("/iamv2/home#/policies/details/%s", url.QueryEscape(arn))
+		}
+	case "cloudformation":
+		if rtype == "stack" {
+			path = fmt.Sprintf("/cloudformation/home?region=%s#/stacks/stackinfo?stackId=%s",
+				r, url.QueryEscape(arn))
+		}
+	case "dynamodb":
+		if rtype == "table" {
+			name := strings.SplitN(id, "/", 2)[0]
+			path = fmt.Sprintf("/dynamodbv2/home?region=%s#table?name=%s", r, url.QueryEscape(name))
+		}
+	case "logs":
+		if rtype == "log-group" {
+			name := strings.TrimSuffix(id, ":*")
+			// CloudWatch double encodes the log group name with $ instead of %
+			name = strings.ReplaceAll(url.QueryEscape(url.QueryEscape(name)), "%", "$")
+			path = fmt.Sprintf("/cloudwatch/home?region=%s#logsV2:log-groups/log-group/%s", r, name)
+		}
+	case "sqs":
+		queue := fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", resourceRegion, account, resource)
+		path = fmt.Sprintf("/sqs/v2/home?region=%s#/queues/%s", r, url.QueryEscape(queue))
+	case "sns":
+		path = fmt.Sprintf("/sns/v3/home?region=%s#/topic/%s", r, arn)
+	case "kms":
+		if rtype == "key" {
+			path = fmt.Sprintf("/kms/home?region=%s#/kms/keys/%s", r, id)
+		}
+	case "secretsmanager":
+		if rtype == "secret" {
+			// strip the random suffix AWS adds to the secret name
+			name := id
+			if i := strings.LastIndex(id, "-"); i > 0 && len(id)-i == 7 {
+				name = id[:i]
+			}
+			path = fmt.Sprintf("/secretsmanager/secret?name=%s&region=%s", url.QueryEscape(name), r)
+		}
+	case "rds":
+		switch rtype {
+		case "db":
+			path = fmt.Sprintf("/rds/home?region=%s#database:id=%s;is-cluster=false", r, id)
+		case "cluster":
+			path = fmt.Sprintf("/rds/home?region=%s#database:id=%s;is-cluster=true", r, id)
+		}
+	case "states":
+		if rtype == "stateMachine" {
+			path = fmt.Sprintf("/states/home?region=%s#/statemachines/view/%s", r, url.QueryEscape(arn))
+		}
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("Unsupported ARN for the AWS Console: %s", arn)
+	}
+	return consolePathUrl(path, region)
+}
+
+// ConsoleDestinationUrl returns the AWS Console URL for the destination which
+// may be an ARN, a service shortcut, a console path or a full console URL.
+// An empty destination returns the console home page.
+func ConsoleDestinationUrl(dest, region string, custom map[string]string) (string, error) {
+	switch {
+	case dest == "":
+		return consolePathUrl(fmt.Sprintf("/console/home?region=%s", url.QueryEscape(region)), region)
+	case strings.HasPrefix(dest, "arn:"):
+		return ConsoleArnUrl(dest, region)
+	case strings.HasPrefix(dest, "/"):
+		return consolePathUrl(dest, region)
+	case strings.Contains(dest, "://"):
+		if err := RegionPartition(region).ValidateConsoleUrl(dest); err != nil {
+			return "", err
+		}
+		return dest, nil
+	}
+	return ConsoleServiceUrl(dest, region, custom)
+}
+
+// consolePathUrl returns the full URL for the path in the console domain
+// for the region's partition
+func consolePathUrl(path, region string) (string, error) {
+	p := RegionPartition(region)
+	u := p.ConsoleUrl() + path
+	if err := p.ValidateConsoleUrl(u); err != nil {
+		return "", err
+	}
+	return u, nil
+}
@@ -0,0 +1,152 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegionPartition(t *testing.T) {
+	assert.Equal(t, "aws", RegionPartition("us-east-1").Name)
+	assert.Equal(t, "aws", RegionPartition("").Name)
+	assert.Equal(t, "aws-us-gov", RegionPartition("us-gov-west-1").Name)
+	assert.Equal(t, "aws-cn", RegionPartition("cn-north-1").Name)
+	assert.Equal(t, "https://signin.amazonaws-us-gov.com/federation",
+		RegionPartition("us-gov-east-1").FederationUrl())
+	assert.Equal(t, "https://signin.amazonaws.cn/oauth", RegionPartition("cn-northwest-1").LogoutUrl())
+}
+
+func TestValidateConsoleUrl(t *testing.T) {
+	p := RegionPartition("us-east-1")
+	assert.NoError(t, p.ValidateConsoleUrl("https://console.aws.amazon.com/s3/home"))
+	assert.NoError(t, p.ValidateConsoleUrl("https://us-west-2.console.aws.amazon.com/ec2/home"))
+
+	assert.Error(t, p.ValidateConsoleUrl("http://console.aws.amazon.com/s3/home"))
+	assert.Error(t, p.ValidateConsoleUrl("https://console.aws.amazon.com.evil.com/"))
+	assert.Error(t, p.ValidateConsoleUrl("https://evilconsole.aws.amazon.com/"))
+	assert.Error(t, p.ValidateConsoleUrl("https://console.aws.amazon.com@evil.com/"))
+	assert.Error(t, p.ValidateConsoleUrl("https://console.amazonaws.cn/"))
+	assert.Error(t, p.ValidateConsoleUrl("https://console.aws.amazon.com:8443/"))
+	assert.Error(t, p.ValidateConsoleUrl("::"))
+}
+
+func TestConsoleServiceUrl(t *testing.T) {
+	u, err := ConsoleServiceUrl("s3", "us-west-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/s3/home?region=us-west-2", u)
+
+	u, err = ConsoleServiceUrl("IAM", "cn-north-1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.amazonaws.cn/iamv2/home", u)
+
+	custom := map[string]string{
+		"Athena": "/athena/home?region={{ .Region }}#/query-editor",
+		"s3":     "/s3/buckets?region={{ .Region }}",
+	}
+	u, err = ConsoleServiceUrl("athena", "us-east-1", custom)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/athena/home?region=us-east-1#/query-editor", u)
+
+	u, err = ConsoleServiceUrl("s3", "us-east-1", custom)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/s3/buckets?region=us-east-1", u)
+
+	_, err = ConsoleServiceUrl("foobar", "us-east-1", nil)
+	assert.Contains(t, err.Error(), "Unknown console service 'foobar'")
+
+	_, err = ConsoleServiceUrl("bad", "us-east-1", map[string]string{"bad": "/{{ .Region"})
+	assert.Error(t, err)
+
+	_, err = ConsoleServiceUrl("evil", "us-east-1", map[string]string{"evil": ".evil.com/"})
+	assert.Error(t, err)
+}
+
+func TestConsoleArnUrl(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:s3:::my-bucket":                                         "https://console.aws.amazon.com/s3/buckets/my-bucket?region=us-east-1",
+		"arn:aws:s3:::my-bucket/some/key":                                "https://console.aws.amazon.com/s3/object/my-bucket?region=us-east-1&prefix=some%2Fkey",
+		"arn:aws:ec2:us-west-2:123456789012:instance/i-0123456789abcdef": "https://console.aws.amazon.com/ec2/home?region=us-west-2#InstanceDetails:instanceId=i-0123456789abcdef",
+		"arn:aws:lambda:us-east-1:123456789012:function:my-func:prod":    "https://console.aws.amazon.com/lambda/home?region=us-east-1#/functions/my-func",
+		"arn:aws:iam::123456789012:role/path/MyRole":                     "https://console.aws.amazon.com/iamv2/home#/roles/details/MyRole",
+		"arn:aws:dynamodb:us-east-1:123456789012:table/Books":            "https://console.aws.amazon.com/dynamodbv2/home?region=us-east-1#table?name=Books",
+		"arn:aws:logs:us-east-1:123456789012:log-group:/aws/lambda/x:*":  "https://console.aws.amazon.com/cloudwatch/home?region=us-east-1#logsV2:log-groups/log-group/$252Faws$252Flambda$252Fx",
+		"arn:aws:sqs:us-east-1:123456789012:my-queue":                    "https://console.aws.amazon.com/sqs/v2/home?region=us-east-1#/queues/https%3A%2F%2Fsqs.us-east-1.amazonaws.com%2F123456789012%2Fmy-queue",
+		"arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf": "https://console.aws.amazon.com/secretsmanager/secret?name=db&region=us-east-1",
+	}
+	for arn, expected := range tests {
+		u, err := ConsoleArnUrl(arn, "us-east-1")
+		assert.NoError(t, err, arn)
+		assert.Equal(t, expected, u, arn)
+	}
+
+	for _, arn := range []string{
+		"arn:aws:s3",
+		"foo:aws:s3:::bucket",
+		"arn:aws:s3:::",
+		"arn:aws:foobar:us-east-1:123456789012:thing/x",
+		"arn:aws:ec2:us-east-1:123456789012:unknown/x",
+		"arn:aws-cn:s3:::bucket",
+		"arn:aws-us-gov:rds:us-gov-west-1:123456789012:db:mydb",
+	} {
+		_, err := ConsoleArnUrl(arn, "us-east-1")
+		assert.Error(t, err, arn)
+	}
+
+	// the partition must match the region we sign in to, not the resource
+	u, err := ConsoleArnUrl("arn:aws-us-gov:rds:us-gov-west-1:123456789012:db:mydb", "us-gov-east-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.amazonaws-us-gov.com/rds/home?region=us-gov-west-1#database:id=mydb;is-cluster=false", u)
+
+	u, err = ConsoleArnUrl("arn:aws-cn:s3:::bucket", "cn-north-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.amazonaws.cn/s3/buckets/bucket?region=cn-north-1", u)
+
+	_, err = ConsoleArnUrl("arn:aws:ec2:us-west-2:123456789012:instance/i-0123456789abcdef", "us-gov-west-1")
+	assert.Contains(t, err.Error(), "is not in the same partition as us-gov-west-1")
+}
+
+func TestConsoleDestinationUrl(t *testing.T) {
+	u, err := ConsoleDestinationUrl("", "us-east-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/console/home?region=us-east-2", u)
+
+	u, err = ConsoleDestinationUrl("ec2", "us-east-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/ec2/home?region=us-east-2", u)
+
+	u, err = ConsoleDestinationUrl("/billing/home#/bills", "us-east-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/billing/home#/bills", u)
+
+	u, err = ConsoleDestinationUrl("arn:aws:s3:::bucket", "us-east-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.aws.amazon.com/s3/buckets/bucket?region=us-east-2", u)
+
+	u, err = ConsoleDestinationUrl("https://us-east-2.console.aws.amazon.com/ec2/home", "us-east-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://us-east-2.console.aws.amazon.com/ec2/home", u)
+
+	_, err = ConsoleDestinationUrl("https://www.example.com/", "us-east-2", nil)
+	assert.Error(t, err)
+
+	_, err = ConsoleDestinationUrl("https://console.aws.amazon.com/", "cn-north-1", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BACKUP_SUFFIX      = ".bak"
+	BACKUP_TIME_FORMAT = "20060102-150405.000" // sorts by time
+)
+
+// WriteFileAtomic writes the data to a temp file in the same directory and
+// renames it over the file so readers never see a partial file.  The mode of
+// an existing file is preserved, otherwise perm is used.  Symlinks are
+// followed so the file they point to is replaced instead of the link.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if realPath, err := filepath.EvalSymlinks(path); err == nil {
+		path = realPath
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := EnsureDirExists(path); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf(".%s.*.tmp", filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	tmpFile := f.Name()
+	defer os.Remove(tmpFile) // no-op after rename
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+// BackupFile copies the file to a timestamped backup in the same directory and
+// removes all but the newest keep backups.  Returns the path of the backup or
+// an empty string if the file does not exist.
+func BackupFile(path string, keep int) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.%s%s", path, time.Now().Format(BACKUP_TIME_FORMAT), BACKUP_SUFFIX)
+	if err = WriteFileAtomic(backup, data, 0600); err != nil {
+		return "", err
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		return backup, err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err = os.Remove(backups[i]); err != nil {
+			return backup, err
+		}
+	}
+	return backup, nil
+}
+
+// ListBackups returns the backups of the file created by BackupFile, newest first
+func ListBackups(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return []string{}, err
+	}
+
+	prefix := filepath.Base(path) + "."
+	backups := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, BACKUP_SUFFIX) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix), BACKUP_SUFFIX)
+		if _, err := time.Parse(BACKUP_TIME_FORMAT, ts); err == nil {
+			backups = append(backups, filepath.Join(filepath.Dir(path), name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
@@ -0,0 +1,132 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "config")
+
+	assert.NoError(t, WriteFileAtomic(path, []byte("a long first version\n"), 0600))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "a long first version\n", string(data))
+
+	// shorter content must not leave anything behind
+	assert.NoError(t, WriteFileAtomic(path, []byte("short\n"), 0600))
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "short\n", string(data))
+
+	// no temp files left
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// existing mode is preserved
+		assert.NoError(t, os.Chmod(path, 0640))
+		assert.NoError(t, WriteFileAtomic(path, []byte("mode\n"), 0600))
+		info, err = os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra privileges on Windows")
+	}
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "config")
+	link := filepath.Join(dir, "aws", "config")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(link), 0755))
+	assert.NoError(t, WriteFileAtomic(target, []byte("old\n"), 0600))
+	assert.NoError(t, os.Symlink("../dotfiles/config", link))
+
+	assert.NoError(t, WriteFileAtomic(link, []byte("new\n"), 0600))
+
+	// the link is still a link to the updated file
+	info, err := os.Lstat(link)
+	assert.NoError(t, err)
+	assert.NotEqual(t, os.FileMode(0), info.Mode()&os.ModeSymlink)
+	data, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(data))
+
+	// temp file was created next to the target
+	entries, err := os.ReadDir(filepath.Dir(link))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+
+	// nothing to backup
+	backup, err := BackupFile(path, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "", backup)
+
+	backups, err := ListBackups(path)
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+
+	// unrelated files are ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.foo.bak"), []byte("foo"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "credentials.20220101-000000.000.bak"), []byte("foo"), 0600))
+
+	created := []string{}
+	for _, v := range []string{"one", "two", "three"} {
+		assert.NoError(t, os.WriteFile(path, []byte(v), 0600))
+		backup, err = BackupFile(path, 2)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "", backup)
+		created = append(created, backup)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, err = ListBackups(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{created[2], created[1]}, backups)
+
+	data, err := os.ReadFile(backups[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(data))
+
+	_, err = os.Stat(created[0])
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, "config.foo.bak"))
+	assert.NoError(t, err)
+}
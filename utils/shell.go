@@ -0,0 +1,211 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	SHELL_AUTO       = "auto"
+	SHELL_BASH       = "bash" // any POSIX shell
+	SHELL_FISH       = "fish"
+	SHELL_POWERSHELL = "powershell"
+	SHELL_CMD        = "cmd"
+	SHELL_NUSHELL    = "nushell"
+	SHELL_DOTENV     = "dotenv"
+	SHELL_JSON       = "json"
+)
+
+// map of process names to our shell formats
+var shellNames = map[string]string{
+	"sh":         SHELL_BASH,
+	"ash":        SHELL_BASH,
+	"bash":       SHELL_BASH,
+	"dash":       SHELL_BASH,
+	"ksh":        SHELL_BASH,
+	"zsh":        SHELL_BASH,
+	"fish":       SHELL_FISH,
+	"pwsh":       SHELL_POWERSHELL,
+	"powershell": SHELL_POWERSHELL,
+	"cmd":        SHELL_CMD,
+	"nu":         SHELL_NUSHELL,
+}
+
+// DetectShell returns the format of the shell which started us based on
+// our parent process and environment variables.  Defaults to SHELL_BASH.
+func DetectShell() string {
+	if os.Getenv("NU_VERSION") != "" {
+		return SHELL_NUSHELL
+	}
+
+	// Linux tells us the name of our parent process
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", os.Getppid()))
+	if err == nil {
+		if shell, ok := shellNames[strings.TrimSpace(string(comm))]; ok {
+			return shell
+		}
+	}
+
+	if shell, ok := shellName(os.Getenv("SHELL")); ok {
+		return shell
+	}
+
+	if runtime.GOOS == "windows" {
+		// cmd.exe sets $PROMPT, PowerShell does not
+		if os.Getenv("PROMPT") != "" {
+			return SHELL_CMD
+		}
+		return SHELL_POWERSHELL
+	}
+	return SHELL_BASH
+}
+
+// shellName returns the format of the shell at the given path
+func shellName(path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	// handle Windows paths on any OS
+	name := path[strings.LastIndexAny(path, `/\`)+1:]
+	name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+	shell, ok := shellNames[name]
+	return shell, ok
+}
+
+// ShellExport returns the commands to set the environment variables in the
+// given shell format.  Variables with an empty value are unset.
+func ShellExport(format string, vars map[string]string) (string, error) {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if format == SHELL_JSON {
+		out := map[string]*string{}
+		for _, k := range keys {
+			v := vars[k]
+			if v == "" {
+				out[k] = nil
+			} else {
+				out[k] = &v
+			}
+		}
+		return shellJson(out)
+	}
+
+	var ret strings.Builder
+	for _, k := range keys {
+		var line string
+		var err error
+		if vars[k] == "" {
+			line, err = shellUnset(format, k)
+		} else {
+			line, err = shellSet(format, k, vars[k])
+		}
+		if err != nil {
+			return "", err
+		}
+		ret.WriteString(line + "\n")
+	}
+	return ret.String(), nil
+}
+
+// ShellUnset returns the commands to unset the environment variables in the
+// given shell format
+func ShellUnset(format string, names []string) (string, error) {
+	vars := map[string]string{}
+	for _, name := range names {
+		vars[name] = ""
+	}
+	return ShellExport(format, vars)
+}
+
+func shellSet(format, key, value string) (string, error) {
+	switch format {
+	case SHELL_BASH:
+		return fmt.Sprintf("export %s='%s'", key, strings.ReplaceAll(value, `'`, `'\''`)), nil
+
+	case SHELL_FISH:
+		r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+		return fmt.Sprintf("set -gx %s '%s'", key, r.Replace(value)), nil
+
+	case SHELL_POWERSHELL:
+		return fmt.Sprintf("$Env:%s = '%s'", key, strings.ReplaceAll(value, `'`, `''`)), nil
+
+	case SHELL_CMD:
+		// cmd.exe has no way to escape these inside a quoted set
+		if strings.ContainsAny(value, "\"\r\n") {
+			return "", fmt.Errorf("Unable to set %s in cmd: value contains unsupported characters", key)
+		}
+		return fmt.Sprintf(`set "%s=%s"`, key, value), nil
+
+	case SHELL_NUSHELL:
+		return fmt.Sprintf("$env.%s = %s", key, doubleQuote(value)), nil
+
+	case SHELL_DOTENV:
+		if !strings.ContainsAny(value, "'\r\n") {
+			return fmt.Sprintf("%s='%s'", key, value), nil
+		}
+		return fmt.Sprintf("%s=%s", key, strings.ReplaceAll(doubleQuote(value), "$", `\$`)), nil
+	}
+	return "", fmt.Errorf("Invalid shell format: %s", format)
+}
+
+func shellUnset(format, key string) (string, error) {
+	switch format {
+	case SHELL_BASH:
+		return fmt.Sprintf("unset %s", key), nil
+	case SHELL_FISH:
+		return fmt.Sprintf("set -e %s", key), nil
+	case SHELL_POWERSHELL:
+		return fmt.Sprintf("Remove-Item Env:%s -ErrorAction SilentlyContinue", key), nil
+	case SHELL_CMD:
+		return fmt.Sprintf("set %s=", key), nil
+	case SHELL_NUSHELL:
+		return fmt.Sprintf("hide-env -i %s", key), nil
+	case SHELL_DOTENV:
+		return fmt.Sprintf("%s=", key), nil
+	}
+	return "", fmt.Errorf("Invalid shell format: %s", format)
+}
+
+// doubleQuote returns the value in double quotes with backslash escapes
+func doubleQuote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
+
+func shellJson(vars map[string]*string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,108 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2022 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellExport(t *testing.T) {
+	vars := map[string]string{
+		"FOO":   `it's a "test" $HOME \o/`,
+		"BAR":   "",
+		"TOKEN": "abc+/=",
+	}
+
+	tests := map[string]string{
+		SHELL_BASH: `unset BAR
+export FOO='it'\''s a "test" $HOME \o/'
+export TOKEN='abc+/='
+`,
+		SHELL_FISH: `set -e BAR
+set -gx FOO 'it\'s a "test" $HOME \\o/'
+set -gx TOKEN 'abc+/='
+`,
+		SHELL_POWERSHELL: `Remove-Item Env:BAR -ErrorAction SilentlyContinue
+$Env:FOO = 'it''s a "test" $HOME \o/'
+$Env:TOKEN = 'abc+/='
+`,
+		SHELL_NUSHELL: `hide-env -i BAR
+$env.FOO = "it's a \"test\" $HOME \\o/"
+$env.TOKEN = "abc+/="
+`,
+		SHELL_DOTENV: `BAR=
+FOO="it's a \"test\" \$HOME \\o/"
+TOKEN='abc+/='
+`,
+		SHELL_JSON: `{
+  "BAR": null,
+  "FOO": "it's a \"test\" $HOME \\o/",
+  "TOKEN": "abc+/="
+}
+`,
+	}
+
+	for format, expected := range tests {
+		out, err := ShellExport(format, vars)
+		assert.NoError(t, err, format)
+		assert.Equal(t, expected, out, format)
+	}
+
+	// cmd.exe can't handle double quotes
+	_, err := ShellExport(SHELL_CMD, vars)
+	assert.Error(t, err)
+	out, err := ShellExport(SHELL_CMD, map[string]string{"BAR": "", "TOKEN": "a&b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "set BAR=\nset \"TOKEN=a&b\"\n", out)
+
+	_, err = ShellExport("invalid", vars)
+	assert.Error(t, err)
+}
+
+func TestShellUnset(t *testing.T) {
+	out, err := ShellUnset(SHELL_BASH, []string{"FOO", "BAR"})
+	assert.NoError(t, err)
+	assert.Equal(t, "unset BAR\nunset FOO\n", out)
+
+	out, err = ShellUnset(SHELL_JSON, []string{"FOO"})
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"FOO\": null\n}\n", out)
+}
+
+func TestShellName(t *testing.T) {
+	s, ok := shellName("/usr/local/bin/fish")
+	assert.True(t, ok)
+	assert.Equal(t, SHELL_FISH, s)
+
+	s, ok = shellName(`C:\Program Files\PowerShell\7\pwsh.exe`)
+	assert.True(t, ok)
+	assert.Equal(t, SHELL_POWERSHELL, s)
+
+	_, ok = shellName("/bin/tcsh")
+	assert.False(t, ok)
+	_, ok = shellName("")
+	assert.False(t, ok)
+}
+
+func TestDetectShell(t *testing.T) {
+	t.Setenv("NU_VERSION", "0.80.0")
+	assert.Equal(t, SHELL_NUSHELL, DetectShell())
+}
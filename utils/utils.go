@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -214,6 +215,34 @@ func ParseRoleARN(arn string) (int64, string, error) {
 	return aId, role, nil
 }
 
+var ssoReservedRole = regexp.MustCompile(`^AWSReservedSSO_(.+)_[0-9a-f]{16}$`)
+
+// ParseAssumedRoleARN parses the STS assumed-role ARN returned by
+// sts:GetCallerIdentity and returns the AccountID and IAM Role name.
+// The AWSReservedSSO_<PermissionSet>_<id> roles created by AWS SSO are
+// mapped back to the name of the PermissionSet.
+func ParseAssumedRoleARN(arn string) (int64, string, error) {
+	s := strings.Split(arn, ":")
+	if len(s) != 6 || s[0] != "arn" || s[2] != "sts" {
+		return ParseRoleARN(arn)
+	}
+
+	r := strings.Split(s[5], "/")
+	if len(r) != 3 || r[0] != "assumed-role" {
+		return 0, "", fmt.Errorf("Unable to parse ARN: %s", arn)
+	}
+
+	accountId, role, err := ParseRoleARN(fmt.Sprintf("%s:%s", s[4], r[1]))
+	if err != nil {
+		return 0, "", fmt.Errorf("Unable to parse ARN: %s", arn)
+	}
+
+	if m := ssoReservedRole.FindStringSubmatch(role); m != nil {
+		role = m[1]
+	}
+	return accountId, role, nil
+}
+
 // MakeRoleARN create an IAM Role ARN using an int64 for the account
 func MakeRoleARN(account int64, name string) string {
 	a, err := AccountIdToString(account)
@@ -77,6 +77,34 @@ func (suite *UtilsTestSuite) TestParseRoleARN() {
 	assert.Error(t, err)
 }
 
+func (suite *UtilsTestSuite) TestParseAssumedRoleARN() {
+	t := suite.T()
+
+	a, r, err := ParseAssumedRoleARN("arn:aws:sts::000000011111:assumed-role/AWSReservedSSO_Admin_Access_0123456789abcdef/user@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11111), a)
+	assert.Equal(t, "Admin_Access", r)
+
+	a, r, err = ParseAssumedRoleARN("arn:aws:sts::000000011111:assumed-role/MyRole/session")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11111), a)
+	assert.Equal(t, "MyRole", r)
+
+	a, r, err = ParseAssumedRoleARN("arn:aws:iam::000000011111:role/Foo")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11111), a)
+	assert.Equal(t, "Foo", r)
+
+	_, _, err = ParseAssumedRoleARN("arn:aws:sts::000000011111:federated-user/Foo")
+	assert.Error(t, err)
+
+	_, _, err = ParseAssumedRoleARN("arn:aws:sts::abc:assumed-role/MyRole/session")
+	assert.Error(t, err)
+
+	_, _, err = ParseAssumedRoleARN("")
+	assert.Error(t, err)
+}
+
 func (suite *UtilsTestSuite) TestMakeRoleARN() {
 	t := suite.T()
 